@@ -4,6 +4,8 @@ import (
 	"io/fs"
 	"strconv"
 
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
 	"github.com/moby/buildkit/client/llb"
 )
 
@@ -14,12 +16,18 @@ const (
 
 // CacheMount mounts a persistent cache at the given directory during a [Run]
 // instruction's execution.
+//
+// The ID of the cache defaults to its destination. If PerBase is set, the
+// default ID also includes the target's base image name and tag (but not its
+// digest) and platform so that the cache is not shared between different
+// distributions or architectures.
 type CacheMount struct {
 	Destination string
 	ID          string
 	Access      string
 	UID         string
 	GID         string
+	PerBase     bool
 }
 
 // RunOption returns an [llb.RunOption] for this cache mount.
@@ -27,6 +35,10 @@ func (cm CacheMount) RunOption(target *Target) llb.RunOption {
 	id := cm.ID
 	if id == "" {
 		id = target.ExpandEnv(cm.Destination)
+
+		if cm.PerBase {
+			id += ":" + baseName(target.Base) + ":" + platforms.Format(target.Platform())
+		}
 	}
 
 	mode := llb.CacheMountShared
@@ -85,3 +97,20 @@ func (cm CacheMount) RunOption(target *Target) llb.RunOption {
 		opts...,
 	)
 }
+
+// baseName returns the given base image reference without any digest.
+func baseName(base string) string {
+	ref, err := reference.ParseNormalizedNamed(base)
+
+	if err != nil {
+		return base
+	}
+
+	name := reference.FamiliarName(ref)
+
+	if tagged, ok := ref.(reference.Tagged); ok {
+		name += ":" + tagged.Tag()
+	}
+
+	return name
+}
@@ -3,6 +3,7 @@ package build_test
 import (
 	"testing"
 
+	"github.com/containerd/containerd/platforms"
 	"github.com/moby/buildkit/solver/pb"
 	"gitlab.wikimedia.org/repos/releng/blubber/build"
 	"gitlab.wikimedia.org/repos/releng/blubber/util/testtarget"
@@ -40,6 +41,33 @@ func TestCacheMount(t *testing.T) {
 		req.Equal("/var/cache/apt", mnt.CacheOpt.ID)
 	})
 
+	t.Run("default ID per base image and platform", func(t *testing.T) {
+		_, req := testtarget.Compile(t,
+			testtarget.NewTargets("foo"),
+			build.RunAllWithOptions{
+				[]build.Run{
+					{"apt-get install", []string{"build-essentials"}},
+				},
+				[]build.RunOption{
+					build.CacheMount{
+						Destination: "/var/cache/apt",
+						PerBase:     true,
+					},
+				},
+			},
+		)
+
+		_, eops := req.ContainsNExecOps(1)
+		req.Len(eops[0].Exec.Mounts, 2)
+		mnt := eops[0].Exec.Mounts[1]
+
+		req.NotNil(mnt.CacheOpt)
+		req.Equal(
+			"/var/cache/apt:testtarget.test/base/foo:latest:"+platforms.DefaultString(),
+			mnt.CacheOpt.ID,
+		)
+	})
+
 	t.Run("with ID and access", func(t *testing.T) {
 		_, req := testtarget.Compile(t,
 			testtarget.NewTargets("foo"),
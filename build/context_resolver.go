@@ -1,10 +1,111 @@
 package build
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"path"
+	"strings"
 
 	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
 )
 
-// ContextResolver returns an initialzed llb.State for a build context.
-type ContextResolver func(context.Context) (*llb.State, error)
+// IgnoreFilename is the name of the file at the root of the build context
+// that lists patterns of files to exclude from the build context, in
+// addition to those excluded by a .dockerignore file.
+const IgnoreFilename = ".blubberignore"
+
+// ContextResolver returns an initialzed llb.State for a build context. The
+// given options (e.g. include and exclude patterns) are applied when loading
+// a local context.
+type ContextResolver func(context.Context, ...llb.LocalOption) (*llb.State, error)
+
+// NamedContextResolver returns an initialized llb.State for the named build
+// context of the given name (e.g. one given by `--build-context` to buildx)
+// and target platform, along with its image config if the context is an
+// image. A nil state is returned if no context of the given name is defined.
+type NamedContextResolver func(ctx context.Context, name string, platform oci.Platform) (*llb.State, *oci.Image, error)
+
+// ContextFileReader reads the file at the given path of the main build
+// context as loaded with the given options (e.g. include and exclude
+// patterns), failing if the file is excluded by them.
+type ContextFileReader func(ctx context.Context, path string, opts ...llb.LocalOption) ([]byte, error)
+
+// ContextIncludes returns whether the file at the given path of a local build
+// context is included when the context is loaded with the given options,
+// i.e. whether it or one of its parent directories matches the include
+// patterns, if any, and neither matches the exclude patterns.
+func ContextIncludes(file string, opts ...llb.LocalOption) (bool, error) {
+	li := &llb.LocalInfo{}
+
+	for _, opt := range opts {
+		opt.SetLocalOption(li)
+	}
+
+	file = path.Clean(strings.TrimPrefix(file, "/"))
+
+	if li.IncludePatterns != "" {
+		included := []string{}
+
+		if err := json.Unmarshal([]byte(li.IncludePatterns), &included); err != nil {
+			return false, errors.Wrap(err, "failed to parse include patterns")
+		}
+
+		ok, err := patternmatcher.MatchesOrParentMatches(file, included)
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	if li.ExcludePatterns != "" {
+		excluded := []string{}
+
+		if err := json.Unmarshal([]byte(li.ExcludePatterns), &excluded); err != nil {
+			return false, errors.Wrap(err, "failed to parse exclude patterns")
+		}
+
+		ok, err := patternmatcher.MatchesOrParentMatches(file, excluded)
+
+		if err != nil || ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// ExcludePatterns returns an [llb.LocalOption] that excludes files matching
+// the given patterns in addition to any previously excluded (e.g. by a
+// .dockerignore file), unlike [llb.ExcludePatterns] which replaces them.
+func ExcludePatterns(patterns []string) llb.LocalOption {
+	return localOptionFunc(func(li *llb.LocalInfo) {
+		if len(patterns) == 0 {
+			return
+		}
+
+		excluded := []string{}
+
+		if li.ExcludePatterns != "" {
+			_ = json.Unmarshal([]byte(li.ExcludePatterns), &excluded)
+		}
+
+		llb.ExcludePatterns(append(excluded, patterns...)).SetLocalOption(li)
+	})
+}
+
+// ReadIgnorePatterns parses the patterns of the given ignore file, which uses
+// the same syntax as .dockerignore files.
+func ReadIgnorePatterns(data []byte) ([]string, error) {
+	return ignorefile.ReadAll(bytes.NewReader(data))
+}
+
+type localOptionFunc func(*llb.LocalInfo)
+
+func (fn localOptionFunc) SetLocalOption(li *llb.LocalInfo) {
+	fn(li)
+}
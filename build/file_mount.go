@@ -0,0 +1,37 @@
+package build
+
+import (
+	"io/fs"
+	"path"
+
+	"github.com/moby/buildkit/client/llb"
+)
+
+const fileMountMode = fs.FileMode(0o0644)
+
+// FileMount mounts a read-only file with the given content at the given path
+// during a [Run] instruction's execution. It can be used to provide or
+// override configuration for a single command without altering the image
+// filesystem.
+type FileMount struct {
+	Destination string
+	Content     []byte
+}
+
+// RunOption returns an [llb.RunOption] for this file mount.
+func (fm FileMount) RunOption(target *Target) llb.RunOption {
+	destination := target.ExpandEnv(fm.Destination)
+	name := path.Base(destination)
+
+	state := llb.Scratch().File(
+		llb.Mkfile(name, fileMountMode, fm.Content),
+		target.Describef("%s preparing file mount %s", emojiFile, destination),
+	)
+
+	return llb.AddMount(
+		destination,
+		state,
+		llb.SourcePath(name),
+		llb.Readonly,
+	)
+}
@@ -0,0 +1,43 @@
+package build_test
+
+import (
+	"testing"
+
+	"github.com/moby/buildkit/solver/pb"
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/util/testtarget"
+)
+
+func TestFileMount(t *testing.T) {
+	_, req := testtarget.Compile(t,
+		testtarget.NewTargets("foo"),
+		build.RunAllWithOptions{
+			[]build.Run{{"apt-get install", []string{"libfoo"}}},
+			[]build.RunOption{
+				build.FileMount{
+					Destination: "/etc/apt/apt.conf.d/foo",
+					Content:     []byte("foo"),
+				},
+			},
+		},
+	)
+
+	ops, eops := req.ContainsNExecOps(1)
+	req.Len(eops[0].Exec.Mounts, 2)
+	mnt := eops[0].Exec.Mounts[1]
+
+	req.Equal("/etc/apt/apt.conf.d/foo", mnt.Dest)
+	req.Equal("foo", mnt.Selector)
+	req.Equal(pb.MountType_BIND, mnt.MountType)
+	req.True(mnt.Readonly)
+
+	inputs := req.HasValidInputs(ops[0])
+	req.Len(inputs, 2)
+
+	fop, ok := inputs[1].Op.(*pb.Op_File)
+	req.True(ok)
+
+	_, mkfiles := req.ContainsNMkfileActions(fop.File, 1)
+	req.Equal("/foo", mkfiles[0].Mkfile.Path)
+	req.Equal([]byte("foo"), mkfiles[0].Mkfile.Data)
+}
@@ -4,10 +4,17 @@
 package build
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
+	"path"
+	"slices"
+	"strings"
 
 	"github.com/moby/buildkit/client/llb"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 )
 
@@ -30,6 +37,11 @@ func (base Base) Compile(target *Target) error {
 	return nil
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (base Base) String() string {
+	return "FROM " + base.Image
+}
+
 // ScratchBase is a concrete build instruction for declaring no base image.
 type ScratchBase struct {
 	Stage string // optional internal name used for multi-stage builds
@@ -41,6 +53,11 @@ func (sb ScratchBase) Compile(target *Target) error {
 	return nil
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (sb ScratchBase) String() string {
+	return "FROM scratch"
+}
+
 // Run is a concrete build instruction for passing any number of arguments to
 // a shell command.
 //
@@ -57,6 +74,11 @@ func (run Run) Compile(target *Target) error {
 	return target.Run(run.Command, run.Arguments)
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (run Run) String() string {
+	return "RUN " + shellCommand(run.Command, run.Arguments)
+}
+
 // RunAll is a concrete build instruction for declaring multiple Run
 // instructions that will be executed together in a `cmd1 && cmd2` chain.
 type RunAll struct {
@@ -68,6 +90,11 @@ func (ra RunAll) Compile(target *Target) error {
 	return (RunAllWithOptions{Runs: ra.Runs}).Compile(target)
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (ra RunAll) String() string {
+	return RunAllWithOptions{Runs: ra.Runs}.String()
+}
+
 // RunOption is any type that can be reduced to a single [llb.RunOption].
 type RunOption interface {
 	// RunOption returns an [llb.RunOption] for the given target.
@@ -97,6 +124,17 @@ func (ra RunAllWithOptions) Compile(target *Target) error {
 	return target.RunAll(runs, opts...)
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (ra RunAllWithOptions) String() string {
+	commands := make([]string, len(ra.Runs))
+
+	for i, run := range ra.Runs {
+		commands[i] = shellCommand(run.Command, run.Arguments)
+	}
+
+	return "RUN " + strings.Join(commands, " && ")
+}
+
 // RunScript is a concrete build instruction that executes the given script.
 type RunScript struct {
 	Script  []byte
@@ -113,6 +151,13 @@ func (rs RunScript) Compile(target *Target) error {
 	return target.RunScript(rs.Script, opts...)
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (rs RunScript) String() string {
+	_, digest := normalizeScript(rs.Script)
+
+	return "RUN [script sha256:" + digest + "]"
+}
+
 // Copy is a concrete build instruction for copying source files/directories
 // from the build host into the image.
 type Copy struct {
@@ -130,11 +175,18 @@ func (copy Copy) Compile(target *Target) error {
 	)
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (copy Copy) String() string {
+	return copyString("", "", copy)
+}
+
 // CopyAs is a concrete build instruction for copying source
-// files/directories and setting their ownership to the given UID/GID.
+// files/directories and setting their ownership to the given UID/GID. If the
+// GID is empty, the primary group of the given user is used.
 //
 // While it can technically wrap any build.Instruction, it is meant to be used
-// with build.Copy and build.CopyFrom to enforce file/directory ownership.
+// with build.Copy, build.CopyFrom and build.CopyWith to enforce
+// file/directory ownership.
 type CopyAs struct {
 	UID string // owner UID
 	GID string // owner GID
@@ -143,37 +195,37 @@ type CopyAs struct {
 
 // Compile to the given [Target]
 func (ca CopyAs) Compile(target *Target) error {
-	from := ""
-	sources := []string{}
-	destination := ""
-	exclude := []string{}
+	from, copy, options, ok := unwrapCopy(ca.Instruction)
 
-	switch ins := ca.Instruction.(type) {
-	case Copy:
-		sources = ins.Sources
-		destination = ins.Destination
-		exclude = ins.Exclude
-	case CopyFrom:
-		from = ins.From
-		sources = ins.Copy.Sources
-		destination = ins.Copy.Destination
-		exclude = ins.Copy.Exclude
-	default:
-		return errors.New("a CopyAs may only wrap Copy and CopyFrom")
+	if !ok {
+		return errors.New("a CopyAs may only wrap Copy, CopyFrom and CopyWith")
 	}
 
-	opts := []llb.CopyOption{
-		llb.WithUser(
-			target.ExpandEnv(fmt.Sprintf("%s:%s", ca.UID, ca.GID)),
-		),
-		llb.WithExcludePatterns(exclude),
+	return options.compile(
+		target, from, copy,
+		llb.WithUser(target.ExpandEnv(ca.owner())),
+	)
+}
+
+// String returns a Dockerfile-like description of the instruction.
+func (ca CopyAs) String() string {
+	owner := ca.owner()
+
+	if from, copy, options, ok := unwrapCopy(ca.Instruction); ok {
+		return copyString(from, owner, copy, options.flags()...)
 	}
 
-	if from == "" {
-		return target.CopyFromBuildContext(sources, destination, opts...)
+	return fmt.Sprintf("COPY --chown=%s %v", owner, ca.Instruction)
+}
+
+// owner returns the owner in the form "uid:gid", or just "uid" if no GID is
+// given.
+func (ca CopyAs) owner() string {
+	if ca.GID == "" {
+		return ca.UID
 	}
 
-	return target.CopyFrom(from, sources, destination, opts...)
+	return ca.UID + ":" + ca.GID
 }
 
 // CopyFrom is a concrete build instruction for copying source
@@ -193,6 +245,327 @@ func (cf CopyFrom) Compile(target *Target) error {
 	)
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (cf CopyFrom) String() string {
+	return copyString(cf.From, "", cf.Copy)
+}
+
+// CopyOptions holds options for copying files beyond the sources,
+// destination and exclusions of a [Copy].
+type CopyOptions struct {
+	Include          []string    // include glob patterns
+	Mode             os.FileMode // file mode of copied files, if non-zero
+	Parents          bool        // preserve the directory structure of sources
+	PreserveSymlinks bool        // copy symlinked sources as links
+}
+
+// CopyWith is a concrete build instruction that copies files according to the
+// given options. It wraps a Copy or CopyFrom instruction.
+type CopyWith struct {
+	Options CopyOptions
+	Instruction
+}
+
+// Compile to the given [Target]
+func (cw CopyWith) Compile(target *Target) error {
+	from, copy, options, ok := unwrapCopy(cw)
+
+	if !ok {
+		return errors.New("a CopyWith may only wrap Copy and CopyFrom")
+	}
+
+	return options.compile(target, from, copy)
+}
+
+// String returns a Dockerfile-like description of the instruction.
+func (cw CopyWith) String() string {
+	if from, copy, options, ok := unwrapCopy(cw); ok {
+		return copyString(from, "", copy, options.flags()...)
+	}
+
+	return fmt.Sprintf("COPY %v", cw.Instruction)
+}
+
+// compile copies the sources of the given copy from the given dependency, or
+// the main build context if empty, according to these options.
+func (options CopyOptions) compile(target *Target, from string, copy Copy, llbOpts ...llb.CopyOption) error {
+	if options.Mode != 0 {
+		llbOpts = append(llbOpts, llb.ChmodOpt{Mode: options.Mode})
+	}
+
+	if !options.Parents {
+		return options.copy(target, from, copy.Sources, copy.Destination, options.Include, copy.Exclude, llbOpts)
+	}
+
+	// Copy from the root of each source (the working directory of the source
+	// filesystem or an explicit "/./" pivot) only the files matching the
+	// sources, so that their parent directories beneath the root are
+	// preserved. Include and exclude patterns are rebased accordingly.
+	var fromState *llb.State
+
+	if from == "" {
+		ctxState, err := target.BuildContext()
+
+		if err != nil {
+			return err
+		}
+
+		fromState = ctxState
+	} else {
+		namedCtxState := target.NamedContext(from)
+		fromState = &namedCtxState
+	}
+
+	dir, err := fromState.GetDir(context.TODO())
+
+	if err != nil {
+		return err
+	}
+
+	roots, sources := parentsRoots(dir, copy.Sources)
+
+	for _, root := range roots {
+		include := rebasePatterns(sources[root], options.Include)
+
+		if len(include) == 0 {
+			include = sources[root]
+		}
+
+		err := options.copy(
+			target, from, []string{root}, copy.Destination,
+			include, rebasePatterns(sources[root], copy.Exclude),
+			llbOpts,
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copy copies the given sources from the given dependency, or the main build
+// context if empty, including and excluding files matching the given
+// patterns.
+func (options CopyOptions) copy(
+	target *Target,
+	from string,
+	sources []string,
+	destination string,
+	include []string,
+	exclude []string,
+	llbOpts []llb.CopyOption,
+) error {
+	llbOpts = append(llbOpts, llb.WithExcludePatterns(exclude))
+
+	if len(include) > 0 || options.PreserveSymlinks {
+		llbOpts = append(llbOpts, copyInfoFunc(func(ci *llb.CopyInfo) {
+			ci.IncludePatterns = include
+
+			if options.PreserveSymlinks {
+				ci.FollowSymlinks = false
+			}
+		}))
+	}
+
+	if from == "" {
+		return target.CopyFromBuildContext(sources, destination, llbOpts...)
+	}
+
+	return target.CopyFrom(from, sources, destination, llbOpts...)
+}
+
+// flags returns Dockerfile-like flags describing these options.
+func (options CopyOptions) flags() []string {
+	flags := []string{}
+
+	if options.Mode != 0 {
+		flags = append(flags, fmt.Sprintf("--chmod=%04o", options.Mode.Perm()))
+	}
+
+	if options.Parents {
+		flags = append(flags, "--parents")
+	}
+
+	if options.PreserveSymlinks {
+		flags = append(flags, "--no-follow-symlinks")
+	}
+
+	for _, pattern := range options.Include {
+		flags = append(flags, "--include="+pattern)
+	}
+
+	return flags
+}
+
+// parentsRoots groups the given sources of a copy with parents by the root
+// beneath which their parent directories are preserved, returning the roots
+// in order along with the paths of the sources relative to each. The root is
+// the part of a source before a "/./" pivot, if any, or otherwise the given
+// working directory, unless the source lies outside of it, in which case the
+// root is "/".
+func parentsRoots(dir string, sources []string) ([]string, map[string][]string) {
+	roots := []string{}
+	relative := map[string][]string{}
+
+	for _, source := range sources {
+		root := dir
+
+		if pivot, rest, ok := strings.Cut(source, "/./"); ok {
+			root, source = pivot, rest
+
+			if !path.IsAbs(root) {
+				root = path.Join(dir, root)
+			}
+
+			source = path.Join(root, source)
+		} else if !path.IsAbs(source) {
+			source = path.Join(dir, source)
+		}
+
+		root = path.Join("/", root)
+		source = path.Join("/", source)
+
+		if root != "/" && !strings.HasPrefix(source, root+"/") {
+			root = "/"
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(source, root), "/")
+
+		if _, ok := relative[root]; !ok {
+			roots = append(roots, root)
+		}
+
+		relative[root] = append(relative[root], rel)
+	}
+
+	return roots, relative
+}
+
+// rebasePatterns returns the given include or exclude patterns, which are
+// relative to each of the given source paths, relative to the root of the
+// sources instead.
+func rebasePatterns(sources []string, patterns []string) []string {
+	rebased := []string{}
+
+	for _, source := range sources {
+		for _, pattern := range patterns {
+			rebased = append(rebased, path.Join(source, pattern))
+		}
+	}
+
+	return rebased
+}
+
+// unwrapCopy returns the dependency, copy and options of the given Copy,
+// CopyFrom or CopyWith instruction.
+func unwrapCopy(instruction Instruction) (string, Copy, CopyOptions, bool) {
+	switch ins := instruction.(type) {
+	case Copy:
+		return "", ins, CopyOptions{}, true
+	case CopyFrom:
+		return ins.From, ins.Copy, CopyOptions{}, true
+	case CopyWith:
+		switch wrapped := ins.Instruction.(type) {
+		case Copy:
+			return "", wrapped, ins.Options, true
+		case CopyFrom:
+			return wrapped.From, wrapped.Copy, ins.Options, true
+		}
+	}
+
+	return "", Copy{}, CopyOptions{}, false
+}
+
+type copyInfoFunc func(*llb.CopyInfo)
+
+func (fn copyInfoFunc) SetCopyOption(ci *llb.CopyInfo) {
+	fn(ci)
+}
+
+// Download is a concrete build instruction for downloading a remote file
+// into the image. The file is verified against the given checksum.
+type Download struct {
+	URL         string        // remote file URL
+	Checksum    digest.Digest // expected digest of the file
+	Destination string        // destination file path
+	Mode        os.FileMode   // file mode
+	Extract     bool          // unpack archives into the destination directory
+}
+
+// Compile to the given [Target]
+func (dl Download) Compile(target *Target) error {
+	options := []llb.CopyOption{}
+
+	if dl.Extract {
+		options = append(options, copyInfoFunc(func(ci *llb.CopyInfo) {
+			ci.AttemptUnpack = true
+		}))
+	}
+
+	return target.CopyFromHTTP(dl.URL, dl.Checksum, dl.Destination, dl.Mode, options...)
+}
+
+// String returns a Dockerfile-like description of the instruction.
+func (dl Download) String() string {
+	flags := fmt.Sprintf("--checksum=%s --chmod=%04o", dl.Checksum, dl.Mode.Perm())
+
+	if dl.Extract {
+		flags += " --unpack"
+	}
+
+	return fmt.Sprintf("ADD %s %s %s", flags, dl.URL, dl.Destination)
+}
+
+// Assemble is a build instruction that assembles a minimal root filesystem
+// from the filesystem of the given dependency. See [Target.Assemble].
+type Assemble struct {
+	From     string   // dependency variant or image
+	Paths    []string // paths to copy
+	Optional []string // paths to copy only if they exist
+	Binaries []string // binaries to copy along with their shared libraries
+	Users    []string // users whose passwd and group entries to copy
+}
+
+// Compile to the given [Target]
+func (as Assemble) Compile(target *Target) error {
+	return target.Assemble(as.From, as.Paths, as.Optional, as.Binaries, as.Users)
+}
+
+// String returns a Dockerfile-like description of the instruction.
+func (as Assemble) String() string {
+	args := []string{"--from=" + as.From}
+
+	for _, user := range as.Users {
+		args = append(args, "--user="+user)
+	}
+
+	for _, binary := range as.Binaries {
+		args = append(args, "--binary="+binary)
+	}
+
+	for _, optional := range as.Optional {
+		args = append(args, "--optional="+optional)
+	}
+
+	return "ASSEMBLE " + strings.Join(append(args, as.Paths...), " ")
+}
+
+// Squash is a build instruction that squashes the layers added on top of the
+// base image into a single layer. See [Target.Squash].
+type Squash struct{}
+
+// Compile to the given [Target]
+func (sq Squash) Compile(target *Target) error {
+	return target.Squash()
+}
+
+// String returns a Dockerfile-like description of the instruction.
+func (sq Squash) String() string {
+	return "SQUASH"
+}
+
 // EntryPoint is a build instruction for declaring a container's default
 // runtime process.
 type EntryPoint struct {
@@ -206,6 +579,13 @@ func (ep EntryPoint) Compile(target *Target) error {
 	return nil
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (ep EntryPoint) String() string {
+	cmd, _ := json.Marshal(ep.Command)
+
+	return "ENTRYPOINT " + string(cmd)
+}
+
 // Env is a concrete build instruction for declaring a container's runtime
 // environment variables.
 type Env struct {
@@ -219,6 +599,11 @@ func (env Env) Compile(target *Target) error {
 	return target.AddEnv(env.Definitions)
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (env Env) String() string {
+	return "ENV " + strings.Join(sortedDefinitions(env.Definitions), " ")
+}
+
 // Label is a concrete build instruction for declaring a number of meta-data
 // key/value pairs to be included in the image.
 type Label struct {
@@ -232,6 +617,11 @@ func (label Label) Compile(target *Target) error {
 	return nil
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (label Label) String() string {
+	return "LABEL " + strings.Join(sortedDefinitions(label.Definitions), " ")
+}
+
 // User is a build instruction for setting which user will run future
 // commands.
 type User struct {
@@ -252,6 +642,15 @@ func (user User) Compile(target *Target) error {
 	return target.User(uid)
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (user User) String() string {
+	if user.UID == "" {
+		return "USER 0"
+	}
+
+	return "USER " + user.UID
+}
+
 // WorkingDirectory is a build instruction for defining the working directory
 // for future command and entrypoint instructions.
 type WorkingDirectory struct {
@@ -265,6 +664,11 @@ func (wd WorkingDirectory) Compile(target *Target) error {
 	return target.WorkingDirectory(wd.Path)
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (wd WorkingDirectory) String() string {
+	return "WORKDIR " + wd.Path
+}
+
 // StringArg is a build instruction defining a build-time replaceable argument
 // with a string value.
 type StringArg struct {
@@ -277,6 +681,11 @@ func (arg StringArg) Compile(target *Target) error {
 	return target.ExposeBuildArg(arg.Name, arg.Default)
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (arg StringArg) String() string {
+	return "ARG " + arg.Name + "=" + quote(arg.Default)
+}
+
 // UintArg is a build instruction defining a build-time replaceable argument
 // with an integer value.
 type UintArg struct {
@@ -289,6 +698,11 @@ func (arg UintArg) Compile(target *Target) error {
 	return target.ExposeBuildArg(arg.Name, fmt.Sprintf("%d", arg.Default))
 }
 
+// String returns a Dockerfile-like description of the instruction.
+func (arg UintArg) String() string {
+	return fmt.Sprintf("ARG %s=%d", arg.Name, arg.Default)
+}
+
 // File is a build instruction that creates a single file with the literal
 // []byte contents.
 type File struct {
@@ -301,3 +715,40 @@ type File struct {
 func (f File) Compile(target *Target) error {
 	return target.Mkfile(f.Path, f.Mode, f.Content)
 }
+
+// String returns a Dockerfile-like description of the instruction.
+func (f File) String() string {
+	return fmt.Sprintf("FILE %s %04o", f.Path, f.Mode.Perm())
+}
+
+func copyString(from string, owner string, copy Copy, extraFlags ...string) string {
+	flags := []string{}
+
+	if from != "" {
+		flags = append(flags, "--from="+from)
+	}
+
+	if owner != "" {
+		flags = append(flags, "--chown="+owner)
+	}
+
+	for _, pattern := range copy.Exclude {
+		flags = append(flags, "--exclude="+pattern)
+	}
+
+	flags = append(flags, extraFlags...)
+
+	args := append(flags, copy.Sources...)
+
+	return "COPY " + strings.Join(append(args, copy.Destination), " ")
+}
+
+func sortedDefinitions(definitions map[string]string) []string {
+	defs := make([]string, 0, len(definitions))
+
+	for _, name := range slices.Sorted(maps.Keys(definitions)) {
+		defs = append(defs, name+"="+quote(definitions[name]))
+	}
+
+	return defs
+}
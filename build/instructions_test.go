@@ -1,11 +1,15 @@
 package build_test
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"testing"
 
 	"github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"gitlab.wikimedia.org/repos/releng/blubber/build"
 	"gitlab.wikimedia.org/repos/releng/blubber/util/testtarget"
@@ -156,6 +160,28 @@ func TestCopyAs(t *testing.T) {
 		}
 	})
 
+	t.Run("without a group", func(t *testing.T) {
+		_, req := testtarget.Compile(t,
+			testtarget.NewTargets("foo"),
+			build.CopyAs{
+				"nobody", "",
+				build.Copy{[]string{"source1"}, "dest", nil},
+			},
+		)
+
+		_, fileOps := req.ContainsNFileOps(1)
+		_, copies := req.ContainsNCopyActions(fileOps[0], 1)
+
+		owner := copies[0].Copy.Owner
+		req.Equal("nobody", owner.User.GetByName().GetName())
+		req.Nil(owner.Group)
+
+		req.Equal(
+			"COPY --chown=nobody source1 dest",
+			build.CopyAs{"nobody", "", build.Copy{[]string{"source1"}, "dest", nil}}.String(),
+		)
+	})
+
 	t.Run("wrapping CopyFrom", func(t *testing.T) {
 		exclude := []string{"**/*.bak"}
 
@@ -235,6 +261,139 @@ func TestCopyAs(t *testing.T) {
 	})
 }
 
+func TestCopyWith(t *testing.T) {
+	t.Run("mode, include and symlinks", func(t *testing.T) {
+		_, req := testtarget.Compile(t,
+			testtarget.NewTargets("foo"),
+			build.CopyWith{
+				build.CopyOptions{
+					Include:          []string{"*.sh"},
+					Mode:             os.FileMode(0o755),
+					PreserveSymlinks: true,
+				},
+				build.Copy{[]string{"bin"}, "bin/", nil},
+			},
+		)
+
+		_, fileOps := req.ContainsNFileOps(1)
+		_, copies := req.ContainsNCopyActions(fileOps[0], 1)
+
+		copy := copies[0].Copy
+		req.Equal("/bin", copy.Src)
+		req.Equal("/srv/app/bin/", copy.Dest)
+		req.Equal([]string{"*.sh"}, copy.IncludePatterns)
+		req.Equal(int32(0o755), copy.Mode)
+		req.False(copy.FollowSymlink)
+	})
+
+	t.Run("parents", func(t *testing.T) {
+		_, req := testtarget.Setup(t,
+			testtarget.NewTargets("bar", "foo"),
+			func(bar *build.Target) {
+				bar.WorkingDirectory("/srv/bar")
+			},
+			func(foo *build.Target) {
+				build.CopyWith{
+					build.CopyOptions{Parents: true},
+					build.CopyFrom{"bar", build.Copy{[]string{"lib/*.so", "/opt/lib"}, "/dest/", nil}},
+				}.Compile(foo)
+			},
+		)
+
+		_, fileOps := req.ContainsNFileOps(2)
+		_, copies := req.ContainsNCopyActions(fileOps[0], 1)
+		_, optCopies := req.ContainsNCopyActions(fileOps[1], 1)
+
+		if copies[0].Copy.Src != "/srv/bar" {
+			copies, optCopies = optCopies, copies
+		}
+
+		copy := copies[0].Copy
+		req.Equal("/srv/bar", copy.Src)
+		req.Equal("/dest/", copy.Dest)
+		req.Equal([]string{"lib/*.so"}, copy.IncludePatterns)
+		req.True(copy.FollowSymlink)
+
+		copy = optCopies[0].Copy
+		req.Equal("/", copy.Src)
+		req.Equal("/dest/", copy.Dest)
+		req.Equal([]string{"opt/lib"}, copy.IncludePatterns)
+	})
+
+	t.Run("parents with a pivot", func(t *testing.T) {
+		_, req := testtarget.Setup(t,
+			testtarget.NewTargets("bar", "foo"),
+			func(bar *build.Target) {
+				bar.WorkingDirectory("/srv/bar")
+			},
+			func(foo *build.Target) {
+				build.CopyWith{
+					build.CopyOptions{Parents: true},
+					build.CopyFrom{"bar", build.Copy{[]string{"/opt/./lib/*.so", "src/./app"}, "/dest/", nil}},
+				}.Compile(foo)
+			},
+		)
+
+		_, fileOps := req.ContainsNFileOps(2)
+		_, copies := req.ContainsNCopyActions(fileOps[0], 1)
+		_, srcCopies := req.ContainsNCopyActions(fileOps[1], 1)
+
+		if copies[0].Copy.Src != "/opt" {
+			copies, srcCopies = srcCopies, copies
+		}
+
+		req.Equal("/opt", copies[0].Copy.Src)
+		req.Equal([]string{"lib/*.so"}, copies[0].Copy.IncludePatterns)
+
+		req.Equal("/srv/bar/src", srcCopies[0].Copy.Src)
+		req.Equal([]string{"app"}, srcCopies[0].Copy.IncludePatterns)
+	})
+
+	t.Run("parents with includes and excludes", func(t *testing.T) {
+		_, req := testtarget.Setup(t,
+			testtarget.NewTargets("bar", "foo"),
+			func(bar *build.Target) {
+				bar.WorkingDirectory("/srv/bar")
+			},
+			func(foo *build.Target) {
+				build.CopyWith{
+					build.CopyOptions{Parents: true, Include: []string{"*.py"}},
+					build.CopyFrom{"bar", build.Copy{[]string{"lib", "/srv/bar/app"}, "/dest/", []string{"*.pyc"}}},
+				}.Compile(foo)
+			},
+		)
+
+		_, fileOps := req.ContainsNFileOps(1)
+		_, copies := req.ContainsNCopyActions(fileOps[0], 1)
+
+		copy := copies[0].Copy
+		req.Equal("/srv/bar", copy.Src)
+		req.Equal([]string{"lib/*.py", "app/*.py"}, copy.IncludePatterns)
+		req.Equal([]string{"lib/*.pyc", "app/*.pyc"}, copy.ExcludePatterns)
+	})
+
+	t.Run("wrapped by CopyAs", func(t *testing.T) {
+		_, req := testtarget.Compile(t,
+			testtarget.NewTargets("foo"),
+			build.CopyAs{
+				"www-data", "www-data",
+				build.CopyWith{
+					build.CopyOptions{Mode: os.FileMode(0o644)},
+					build.Copy{[]string{"app.conf"}, "/etc/app.conf", nil},
+				},
+			},
+		)
+
+		_, fileOps := req.ContainsNFileOps(1)
+		_, copies := req.ContainsNCopyActions(fileOps[0], 1)
+
+		copy := copies[0].Copy
+		req.Equal(int32(0o644), copy.Mode)
+		req.Equal("www-data", copy.Owner.User.GetByName().GetName())
+		req.Equal("www-data", copy.Owner.Group.GetByName().GetName())
+	})
+}
+
 func TestEntryPoint(t *testing.T) {
 	image, req := testtarget.Compile(t,
 		testtarget.NewTargets("foo"),
@@ -436,3 +595,177 @@ func TestFile(t *testing.T) {
 	req.Equal([]byte(`bar`), mkfile.Data)
 	req.Equal(int32(0400), mkfile.Mode)
 }
+
+func TestDownload(t *testing.T) {
+	checksum := digest.FromBytes([]byte("foo"))
+
+	_, req := testtarget.Compile(t,
+		testtarget.NewTargets("foo"),
+		build.Download{
+			URL:         "https://example.test/foo.asc",
+			Checksum:    checksum,
+			Destination: "/etc/foo.asc",
+			Mode:        os.FileMode(0o644),
+		},
+	)
+
+	fops, fileOps := req.ContainsNFileOps(1)
+	inputs := req.HasValidInputs(fops[0])
+	req.Len(inputs, 2)
+
+	req.IsType((*pb.Op_Source)(nil), inputs[1].Op)
+	source := inputs[1].Op.(*pb.Op_Source).Source
+	req.Equal("https://example.test/foo.asc", source.Identifier)
+	req.Equal(checksum.String(), source.Attrs[pb.AttrHTTPChecksum])
+	req.Equal("foo.asc", source.Attrs[pb.AttrHTTPFilename])
+
+	_, copies := req.ContainsNCopyActions(fileOps[0], 1)
+	req.Equal("/foo.asc", copies[0].Copy.Src)
+	req.Equal("/etc/foo.asc", copies[0].Copy.Dest)
+	req.False(copies[0].Copy.AttemptUnpackDockerCompatibility)
+}
+
+func TestDownloadExtract(t *testing.T) {
+	checksum := digest.FromBytes([]byte("foo"))
+
+	_, req := testtarget.Compile(t,
+		testtarget.NewTargets("foo"),
+		build.Download{
+			URL:         "https://example.test/dist/foo.tar.gz?raw=1",
+			Checksum:    checksum,
+			Destination: "/opt/foo/",
+			Mode:        os.FileMode(0o644),
+			Extract:     true,
+		},
+	)
+
+	fops, fileOps := req.ContainsNFileOps(1)
+	inputs := req.HasValidInputs(fops[0])
+	req.Len(inputs, 2)
+
+	source := inputs[1].Op.(*pb.Op_Source).Source
+	req.Equal("foo.tar.gz", source.Attrs[pb.AttrHTTPFilename])
+
+	_, copies := req.ContainsNCopyActions(fileOps[0], 1)
+	req.Equal("/foo.tar.gz", copies[0].Copy.Src)
+	req.Equal("/opt/foo/", copies[0].Copy.Dest)
+	req.True(copies[0].Copy.AttemptUnpackDockerCompatibility)
+	req.True(copies[0].Copy.CreateDestPath)
+}
+
+func TestDownloadThroughProxy(t *testing.T) {
+	checksum := digest.FromBytes([]byte("foo"))
+	download := build.Download{
+		URL:         "https://example.test/foo.asc",
+		Checksum:    checksum,
+		Destination: "/etc/foo.asc",
+		Mode:        os.FileMode(0o644),
+	}
+
+	t.Run("downloads with a build process", func(t *testing.T) {
+		_, req := testtarget.Setup(t,
+			testtarget.NewTargets("foo"),
+			func(target *build.Target) {
+				target.Options.BuildArgs["http_proxy"] = "http://proxy.example:8080"
+				require.NoError(t, download.Compile(target))
+			},
+		)
+
+		// Only the base image is a source
+		_, sources := req.ContainsNSourceOps(1)
+		req.Contains(sources[0].Source.Identifier, "testtarget.test/base/foo")
+
+		_, execOps := req.ContainsNExecOps(1)
+		exec := execOps[0].Exec
+		req.Equal("0", exec.Meta.User)
+		req.Equal("http://proxy.example:8080", exec.Meta.ProxyEnv.HttpProxy)
+		req.Equal(
+			[]string{"fetch", "https://example.test/foo.asc", "foo.asc", checksum.Encoded(), "0644"},
+			exec.Meta.Args[3:],
+		)
+		req.Contains(exec.Meta.Args[2], `echo "$3  $2" | sha256sum -c -`)
+
+		fops, fileOps := req.ContainsNFileOps(1)
+		inputs := req.HasValidInputs(fops[0])
+		req.Len(inputs, 2)
+		req.IsType((*pb.Op_Exec)(nil), inputs[1].Op)
+
+		_, copies := req.ContainsNCopyActions(fileOps[0], 1)
+		req.Equal("/foo.asc", copies[0].Copy.Src)
+		req.Equal("/etc/foo.asc", copies[0].Copy.Dest)
+	})
+
+	t.Run("requires a base image", func(t *testing.T) {
+		req := require.New(t)
+
+		var targets build.TargetGroup
+		target := targets.NewTarget("foo", "", nil, build.NewOptions())
+		target.Options.BuildArgs["HTTPS_PROXY"] = "http://proxy.example:8080"
+
+		req.NoError(targets.InitializeAll(context.Background()))
+		req.ErrorContains(download.Compile(target), "without a base image providing curl or wget")
+	})
+}
+
+func TestInstructionString(t *testing.T) {
+	for _, tc := range []struct {
+		instruction build.Instruction
+		expected    string
+	}{
+		{build.Base{Image: "foo"}, "FROM foo"},
+		{build.ScratchBase{}, "FROM scratch"},
+		{build.Run{"echo %s", []string{"foo", "bar"}}, `RUN echo "foo" "bar"`},
+		{
+			build.RunAll{[]build.Run{{"foo", []string{}}, {"bar %s", []string{"baz"}}}},
+			`RUN foo && bar "baz"`,
+		},
+		{
+			build.Copy{[]string{"foo", "bar"}, "/baz", []string{"*.o"}},
+			"COPY --exclude=*.o foo bar /baz",
+		},
+		{
+			build.CopyFrom{"foo", build.Copy{[]string{"/bar"}, "/baz", nil}},
+			"COPY --from=foo /bar /baz",
+		},
+		{
+			build.CopyAs{"123", "223", build.CopyFrom{"foo", build.Copy{[]string{"/bar"}, "/baz", nil}}},
+			"COPY --from=foo --chown=123:223 /bar /baz",
+		},
+		{
+			build.CopyAs{"0", "0", build.CopyWith{
+				build.CopyOptions{Mode: os.FileMode(0o755), Parents: true},
+				build.Copy{[]string{"bin/*.sh"}, "/usr/local/", nil},
+			}},
+			"COPY --chown=0:0 --chmod=0755 --parents bin/*.sh /usr/local/",
+		},
+		{build.EntryPoint{[]string{"/foo", "bar"}}, `ENTRYPOINT ["/foo","bar"]`},
+		{build.Env{map[string]string{"foo": "bar", "baz": "qux"}}, `ENV baz="qux" foo="bar"`},
+		{build.Label{map[string]string{"foo": "bar"}}, `LABEL foo="bar"`},
+		{build.User{}, "USER 0"},
+		{build.User{"123"}, "USER 123"},
+		{build.WorkingDirectory{"/srv"}, "WORKDIR /srv"},
+		{build.StringArg{"foo", "bar"}, `ARG foo="bar"`},
+		{build.UintArg{"foo", 123}, "ARG foo=123"},
+		{build.File{"/foo", os.FileMode(0o644), []byte("foo")}, "FILE /foo 0644"},
+		{
+			build.Download{"https://example.test/foo", "sha256:abc", "/foo", os.FileMode(0o644), false},
+			"ADD --checksum=sha256:abc --chmod=0644 https://example.test/foo /foo",
+		},
+		{
+			build.Download{"https://example.test/foo.tar.gz", "sha256:abc", "/opt/foo/", os.FileMode(0o644), true},
+			"ADD --checksum=sha256:abc --chmod=0644 --unpack https://example.test/foo.tar.gz /opt/foo/",
+		},
+		{
+			build.Assemble{
+				From:     "build",
+				Paths:    []string{"/srv/app", "/opt/lib"},
+				Optional: []string{"/etc/ssl/cert.pem"},
+				Binaries: []string{"/usr/bin/foo"},
+				Users:    []string{"$RUNS_AS"},
+			},
+			"ASSEMBLE --from=build --user=$RUNS_AS --binary=/usr/bin/foo --optional=/etc/ssl/cert.pem /srv/app /opt/lib",
+		},
+	} {
+		assert.Equal(t, tc.expected, fmt.Sprint(tc.instruction))
+	}
+}
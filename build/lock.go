@@ -0,0 +1,269 @@
+package build
+
+import (
+	"context"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
+	"github.com/ghodss/yaml"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/client/llb/sourceresolver"
+	digest "github.com/opencontainers/go-digest"
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// LockFilename is the name of the lock file that is expected to live
+	// alongside the Blubber config.
+	LockFilename = "blubber.lock"
+
+	lockVersion = "v1"
+)
+
+// Lock records the digests to which image references (base images and
+// external images used by copies and mounts) were resolved for each
+// platform. When given to a [Target] via [Options], image references are
+// pinned to the recorded digests, and newly resolved digests are recorded.
+type Lock struct {
+	Version string                              `json:"version"`
+	Images  map[string]map[string]digest.Digest `json:"images"`
+
+	// image references and platforms of the recorded digests that have been
+	// retrieved or recorded since the lock was read
+	used  map[string]map[string]bool
+	mutex sync.Mutex
+}
+
+// NewLock returns a new empty [Lock].
+func NewLock() *Lock {
+	return &Lock{
+		Version: lockVersion,
+		Images:  map[string]map[string]digest.Digest{},
+	}
+}
+
+// ReadLock unmarshals the given YAML lock file data.
+func ReadLock(data []byte) (*Lock, error) {
+	lock := NewLock()
+
+	err := yaml.Unmarshal(data, lock)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse lock file")
+	}
+
+	if lock.Version != lockVersion {
+		return nil, errors.Errorf("unsupported lock file version %q", lock.Version)
+	}
+
+	if lock.Images == nil {
+		lock.Images = map[string]map[string]digest.Digest{}
+	}
+
+	return lock, nil
+}
+
+// ReadLockFile reads and unmarshals the lock file at the given path.
+func ReadLockFile(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadLock(data)
+}
+
+// Marshal returns the lock file as YAML.
+func (lock *Lock) Marshal() ([]byte, error) {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	return yaml.Marshal(lock)
+}
+
+// WriteFile writes the lock file as YAML to the given path.
+func (lock *Lock) WriteFile(path string) error {
+	data, err := lock.Marshal()
+
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal lock file")
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Get returns the digest recorded for the given image reference and
+// platform.
+func (lock *Lock) Get(ref string, platform oci.Platform) (digest.Digest, bool) {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	dgst, ok := lock.Images[ref][platforms.Format(platform)]
+
+	if ok {
+		lock.use(ref, platform)
+	}
+
+	return dgst, ok
+}
+
+// Set records the digest for the given image reference and platform.
+func (lock *Lock) Set(ref string, platform oci.Platform, dgst digest.Digest) {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	if lock.Images[ref] == nil {
+		lock.Images[ref] = map[string]digest.Digest{}
+	}
+
+	lock.Images[ref][platforms.Format(platform)] = dgst
+	lock.use(ref, platform)
+}
+
+// Copy returns a copy of the lock with the same recorded digests.
+func (lock *Lock) Copy() *Lock {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	lockCopy := NewLock()
+	lockCopy.Version = lock.Version
+
+	for ref, digests := range lock.Images {
+		lockCopy.Images[ref] = maps.Clone(digests)
+	}
+
+	return lockCopy
+}
+
+// Platforms returns the platforms for which digests are recorded.
+func (lock *Lock) Platforms() ([]oci.Platform, error) {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	names := map[string]bool{}
+
+	for _, digests := range lock.Images {
+		for name := range digests {
+			names[name] = true
+		}
+	}
+
+	result := make([]oci.Platform, 0, len(names))
+
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		platform, err := platforms.Parse(name)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid platform %q in lock file", name)
+		}
+
+		result = append(result, platform)
+	}
+
+	return result, nil
+}
+
+// Unused returns the recorded digests, formatted as "image (platform)", that
+// have been neither retrieved nor recorded since the lock was read.
+func (lock *Lock) Unused() []string {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	unused := []string{}
+
+	for _, ref := range slices.Sorted(maps.Keys(lock.Images)) {
+		for _, platform := range slices.Sorted(maps.Keys(lock.Images[ref])) {
+			if !lock.used[ref][platform] {
+				unused = append(unused, ref+" ("+platform+")")
+			}
+		}
+	}
+
+	return unused
+}
+
+// use marks the digest of the given image reference and platform as used.
+// The caller must hold the mutex.
+func (lock *Lock) use(ref string, platform oci.Platform) {
+	if lock.used == nil {
+		lock.used = map[string]map[string]bool{}
+	}
+
+	if lock.used[ref] == nil {
+		lock.used[ref] = map[string]bool{}
+	}
+
+	lock.used[ref][platforms.Format(platform)] = true
+}
+
+// ResolveLatest resolves the digest to which the tag of the given image
+// reference currently points for the given platform, ignoring any digest
+// pinned in the reference itself. References without a tag resolve the
+// "latest" tag, unless they are pinned to a digest (see [Untagged]).
+func ResolveLatest(ctx context.Context, resolver llb.ImageMetaResolver, name string, platform oci.Platform) (digest.Digest, error) {
+	ref, err := reference.ParseNormalizedNamed(name)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse image ref %q", name)
+	}
+
+	if tagged, ok := ref.(reference.Tagged); ok {
+		ref, err = reference.WithTag(reference.TrimNamed(ref), tagged.Tag())
+
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to parse image ref %q", name)
+		}
+	} else if _, ok := ref.(reference.Digested); ok {
+		return "", errors.Errorf("%s is pinned to a digest and has no tag to resolve", name)
+	} else {
+		ref = reference.TagNameOnly(reference.TrimNamed(ref))
+	}
+
+	_, dgst, _, err := resolver.ResolveImageConfig(ctx, ref.String(), sourceresolver.Opt{
+		Platform: &platform,
+	})
+
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve %s", ref)
+	}
+
+	return dgst, nil
+}
+
+// PinnedDigest returns the digest pinned in the given image reference, if
+// any.
+func PinnedDigest(name string) (digest.Digest, bool) {
+	ref, err := reference.ParseNormalizedNamed(name)
+
+	if err != nil {
+		return "", false
+	}
+
+	if digested, ok := ref.(reference.Digested); ok {
+		return digested.Digest(), true
+	}
+
+	return "", false
+}
+
+// Untagged returns whether the given image reference is pinned to a digest
+// without a tag, e.g. "foo@sha256:...", in which case there is no tag whose
+// latest digest could be resolved.
+func Untagged(name string) bool {
+	ref, err := reference.ParseNormalizedNamed(name)
+
+	if err != nil {
+		return false
+	}
+
+	_, tagged := ref.(reference.Tagged)
+	_, digested := ref.(reference.Digested)
+
+	return digested && !tagged
+}
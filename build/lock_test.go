@@ -0,0 +1,139 @@
+package build_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/client/llb/sourceresolver"
+	digest "github.com/opencontainers/go-digest"
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/util/testmetaresolver"
+	"gitlab.wikimedia.org/repos/releng/blubber/util/testtarget"
+)
+
+func TestLock(t *testing.T) {
+	amd64 := oci.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := oci.Platform{OS: "linux", Architecture: "arm64"}
+	dgst := digest.FromBytes([]byte("foo"))
+
+	t.Run("round trip", func(t *testing.T) {
+		req := require.New(t)
+
+		lock := build.NewLock()
+		lock.Set("foo:1.0", amd64, dgst)
+
+		data, err := lock.Marshal()
+		req.NoError(err)
+
+		req.Equal(
+			"images:\n  foo:1.0:\n    linux/amd64: "+dgst.String()+"\nversion: v1\n",
+			string(data),
+		)
+
+		lock, err = build.ReadLock(data)
+		req.NoError(err)
+
+		locked, ok := lock.Get("foo:1.0", amd64)
+		req.True(ok)
+		req.Equal(dgst, locked)
+
+		_, ok = lock.Get("foo:1.0", arm64)
+		req.False(ok)
+	})
+
+	t.Run("unused", func(t *testing.T) {
+		req := require.New(t)
+
+		lock, err := build.ReadLock([]byte(
+			"version: v1\nimages:\n  foo:1.0:\n    linux/amd64: " + dgst.String() +
+				"\n    linux/arm64: " + dgst.String() +
+				"\n  bar:2.0:\n    linux/amd64: " + dgst.String() + "\n",
+		))
+		req.NoError(err)
+
+		lock.Get("foo:1.0", amd64)
+		lock.Set("baz:3.0", amd64, dgst)
+
+		req.Equal([]string{"bar:2.0 (linux/amd64)", "foo:1.0 (linux/arm64)"}, lock.Unused())
+
+		lockPlatforms, err := lock.Platforms()
+		req.NoError(err)
+		req.Equal([]oci.Platform{amd64, arm64}, lockPlatforms)
+
+		lockCopy := lock.Copy()
+		req.Equal(lock.Images, lockCopy.Images)
+		req.Len(lockCopy.Unused(), 4)
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		_, err := build.ReadLock([]byte("version: v2\nimages: {}\n"))
+
+		require.ErrorContains(t, err, `unsupported lock file version "v2"`)
+	})
+}
+
+func TestInitializeWithLock(t *testing.T) {
+	ctx := context.Background()
+	locked := digest.FromBytes([]byte("locked"))
+
+	t.Run("pins the base image to the locked digest", func(t *testing.T) {
+		req := require.New(t)
+		target := testtarget.NewTarget("foo")
+		resolver := &recordingResolver{ImageMetaResolver: target.Options.MetaResolver}
+		target.Options.MetaResolver = resolver
+		target.Options.Lock = build.NewLock()
+		target.Options.Lock.Set("testtarget.test/base/foo", target.Platform(), locked)
+
+		req.NoError(target.Initialize(ctx))
+		req.Equal([]string{"testtarget.test/base/foo@" + locked.String()}, resolver.refs)
+	})
+
+	t.Run("records resolved digests", func(t *testing.T) {
+		req := require.New(t)
+		target := testtarget.NewTarget("foo")
+		target.Options.Lock = build.NewLock()
+
+		req.NoError(target.Initialize(ctx))
+
+		recorded, ok := target.Options.Lock.Get("testtarget.test/base/foo", target.Platform())
+		req.True(ok)
+		req.Equal("testtarget.test/base/foo:latest@"+recorded.String(), target.Base)
+	})
+
+	t.Run("fails on missing digests when locked", func(t *testing.T) {
+		target := testtarget.NewTarget("foo")
+		target.Options.Lock = build.NewLock()
+		target.Options.Locked = true
+
+		require.ErrorContains(t,
+			target.Initialize(ctx),
+			"blubber.lock has no digest recorded for testtarget.test/base/foo",
+		)
+	})
+}
+
+type recordingResolver struct {
+	llb.ImageMetaResolver
+	refs []string
+}
+
+func (rr *recordingResolver) ResolveImageConfig(ctx context.Context, ref string, opt sourceresolver.Opt) (string, digest.Digest, []byte, error) {
+	rr.refs = append(rr.refs, ref)
+	return rr.ImageMetaResolver.ResolveImageConfig(ctx, ref, opt)
+}
+
+func TestResolveLatest(t *testing.T) {
+	amd64 := oci.Platform{OS: "linux", Architecture: "amd64"}
+	pinned := "docker-registry.wikimedia.org/foo@" + digest.FromBytes([]byte("foo")).String()
+
+	require.True(t, build.Untagged(pinned))
+	require.False(t, build.Untagged("docker-registry.wikimedia.org/foo:1.0@"+digest.FromBytes([]byte("foo")).String()))
+	require.False(t, build.Untagged("docker-registry.wikimedia.org/foo"))
+
+	_, err := build.ResolveLatest(context.Background(), testmetaresolver.New("foo", oci.Image{}), pinned, amd64)
+	require.EqualError(t, err, pinned+" is pinned to a digest and has no tag to resolve")
+}
@@ -1,7 +1,7 @@
 package build
 
 import (
-	"fmt"
+	"strings"
 )
 
 // NewStringArg creates an ARG instruction with a string default value.
@@ -14,14 +14,14 @@ func NewUintArg(varname string, value uint) UintArg {
 	return UintArg{varname, value}
 }
 
-// ApplyUser wraps any build.Copy instructions as build.CopyAs using the given
-// UID/GID.
+// ApplyUser wraps any build.Copy, build.CopyFrom and build.CopyWith
+// instructions as build.CopyAs using the given UID/GID.
 func ApplyUser(uid string, gid string, instructions []Instruction) []Instruction {
 	applied := make([]Instruction, len(instructions))
 
 	for i, instruction := range instructions {
 		switch instruction.(type) {
-		case Copy, CopyFrom:
+		case Copy, CopyFrom, CopyWith:
 			applied[i] = CopyAs{uid, gid, instruction}
 		default:
 			applied[i] = instruction
@@ -37,6 +37,12 @@ func Chown(uid string, gid string, path string) Run {
 	return Run{"chown %s:%s", []string{uid, gid, path}}
 }
 
+// ChownRecursive returns a build.Run instruction for recursively setting
+// ownership on the given paths.
+func ChownRecursive(uid string, gid string, paths []string) Run {
+	return Run{"chown -R %s:%s", append([]string{uid, gid}, paths...)}
+}
+
 // CreateDirectories returns a build.Run instruction for creating all the
 // given directories.
 func CreateDirectories(paths []string) Run {
@@ -49,18 +55,111 @@ func CreateDirectory(path string) Run {
 	return CreateDirectories([]string{path})
 }
 
+// UserAccount describes a user account along with its primary and
+// supplementary groups. Default values are used for an empty Home or Shell.
+type UserAccount struct {
+	Name   string   // user and primary group name
+	UID    string   // user ID
+	GID    string   // primary group ID
+	Home   string   // home directory
+	Shell  string   // login shell
+	Groups []string // supplementary group names
+}
+
+// HomeDirectory returns the configured home directory of the account, or
+// the default one for its name.
+func (account UserAccount) HomeDirectory() string {
+	if account.Home != "" {
+		return account.Home
+	}
+
+	return homeDir(account.Name)
+}
+
 // CreateUser returns build.Run instructions for creating the given user
 // account and group.
 func CreateUser(name string, uid string, gid string) []Run {
-	return []Run{
-		{
-			"(getent group %s || groupadd -o -g %s -r %s)",
-			[]string{fmt.Sprint(gid), fmt.Sprint(gid), name},
+	return CreateUserAccount(UserAccount{Name: name, UID: uid, GID: gid})
+}
+
+// CreateUserAccount returns build.Run instructions for creating the given
+// user account and its primary group, and adding the user to its
+// supplementary groups. Creation of the group and user is skipped if one
+// with the same ID already exists. If one with the same name already exists,
+// it is reused only if its IDs match the configured ones.
+func CreateUserAccount(account UserAccount) []Run {
+	useradd := "useradd -l -o -m -d %s -r -g %s -u %s"
+	args := []string{account.HomeDirectory(), account.GID, account.UID}
+
+	if account.Shell != "" {
+		useradd += " -s %s"
+		args = append(args, account.Shell)
+	}
+
+	checkUser, checkUserArgs := checkUserIDs(account)
+
+	passwdArgs := append([]string{account.Name}, checkUserArgs...)
+	passwdArgs = append(passwdArgs, account.UID)
+	passwdArgs = append(passwdArgs, args...)
+	passwdArgs = append(passwdArgs, account.Name)
+
+	return append(
+		[]Run{
+			{
+				"(if getent group %s >/dev/null; then " +
+					"[ \"$(getent group %s | cut -d: -f3)\" = %s ] || (echo %s >&2 && false); " +
+					"else getent group %s || groupadd -o -g %s -r %s; fi)",
+				[]string{
+					account.Name,
+					account.Name, account.GID, "group " + account.Name + " exists with a gid other than " + account.GID,
+					account.GID, account.GID, account.Name,
+				},
+			},
+			{
+				"(if getent passwd %s >/dev/null; then " + checkUser + "; " +
+					"else getent passwd %s || " + useradd + " %s; fi)",
+				passwdArgs,
+			},
 		},
-		{
-			"(getent passwd %s || useradd -l -o -m -d %s -r -g %s -u %s %s)",
-			[]string{fmt.Sprint(uid), homeDir(name), fmt.Sprint(gid), fmt.Sprint(uid), name},
+		addToGroups(account)...,
+	)
+}
+
+// ReuseUserAccount returns build.Run instructions for verifying that the
+// given user account already exists with the configured IDs and adding the
+// user to its supplementary groups.
+func ReuseUserAccount(account UserAccount) []Run {
+	checkUser, checkUserArgs := checkUserIDs(account)
+
+	return append(
+		[]Run{
+			{
+				"(getent passwd %s || (echo %s >&2 && false)) && " + checkUser,
+				append([]string{account.Name, "user " + account.Name + " does not exist"}, checkUserArgs...),
+			},
 		},
+		addToGroups(account)...,
+	)
+}
+
+// checkUserIDs returns a command and its arguments that fail unless the
+// existing user of the given account's name has the account's UID and GID.
+func checkUserIDs(account UserAccount) (string, []string) {
+	return "([ \"$(id -u %s)\" = %s ] && [ \"$(id -g %s)\" = %s ] || (echo %s >&2 && false))",
+		[]string{
+			account.Name, account.UID,
+			account.Name, account.GID,
+			"user " + account.Name + " exists with a uid or gid other than " + account.UID + ":" + account.GID,
+		}
+}
+
+func addToGroups(account UserAccount) []Run {
+	if len(account.Groups) == 0 {
+		return []Run{}
+	}
+
+	return []Run{
+		{"usermod -a -G %s %s", []string{strings.Join(account.Groups, ","), account.Name}},
 	}
 }
 
@@ -36,6 +36,17 @@ func TestChown(t *testing.T) {
 	)
 }
 
+func TestChownRecursive(t *testing.T) {
+	assert.Equal(
+		t,
+		build.Run{
+			Command:   "chown -R %s:%s",
+			Arguments: []string{"123", "124", "/foo", "/bar"},
+		},
+		build.ChownRecursive("123", "124", []string{"/foo", "/bar"}),
+	)
+}
+
 func TestCreateDirectories(t *testing.T) {
 	assert.Equal(
 		t,
@@ -63,18 +74,94 @@ func TestCreateUser(t *testing.T) {
 		t,
 		[]build.Run{
 			{
-				Command:   "(getent group %s || groupadd -o -g %s -r %s)",
-				Arguments: []string{"124", "124", "foo"},
+				Command: "(if getent group %s >/dev/null; then " +
+					"[ \"$(getent group %s | cut -d: -f3)\" = %s ] || (echo %s >&2 && false); " +
+					"else getent group %s || groupadd -o -g %s -r %s; fi)",
+				Arguments: []string{"foo", "foo", "124", "group foo exists with a gid other than 124", "124", "124", "foo"},
 			},
 			{
-				Command:   "(getent passwd %s || useradd -l -o -m -d %s -r -g %s -u %s %s)",
-				Arguments: []string{"123", "/home/foo", "124", "123", "foo"},
+				Command: "(if getent passwd %s >/dev/null; then " +
+					"([ \"$(id -u %s)\" = %s ] && [ \"$(id -g %s)\" = %s ] || (echo %s >&2 && false)); " +
+					"else getent passwd %s || useradd -l -o -m -d %s -r -g %s -u %s %s; fi)",
+				Arguments: []string{
+					"foo",
+					"foo", "123", "foo", "124", "user foo exists with a uid or gid other than 123:124",
+					"123", "/home/foo", "124", "123", "foo",
+				},
 			},
 		},
 		build.CreateUser("foo", "123", "124"),
 	)
 }
 
+func TestCreateUserAccount(t *testing.T) {
+	assert.Equal(
+		t,
+		[]build.Run{
+			{
+				Command: "(if getent group %s >/dev/null; then " +
+					"[ \"$(getent group %s | cut -d: -f3)\" = %s ] || (echo %s >&2 && false); " +
+					"else getent group %s || groupadd -o -g %s -r %s; fi)",
+				Arguments: []string{"foo", "foo", "124", "group foo exists with a gid other than 124", "124", "124", "foo"},
+			},
+			{
+				Command: "(if getent passwd %s >/dev/null; then " +
+					"([ \"$(id -u %s)\" = %s ] && [ \"$(id -g %s)\" = %s ] || (echo %s >&2 && false)); " +
+					"else getent passwd %s || useradd -l -o -m -d %s -r -g %s -u %s -s %s %s; fi)",
+				Arguments: []string{
+					"foo",
+					"foo", "123", "foo", "124", "user foo exists with a uid or gid other than 123:124",
+					"123", "/srv/foo", "124", "123", "/bin/bash", "foo",
+				},
+			},
+			{
+				Command:   "usermod -a -G %s %s",
+				Arguments: []string{"www-data,ssl-cert", "foo"},
+			},
+		},
+		build.CreateUserAccount(build.UserAccount{
+			Name:   "foo",
+			UID:    "123",
+			GID:    "124",
+			Home:   "/srv/foo",
+			Shell:  "/bin/bash",
+			Groups: []string{"www-data", "ssl-cert"},
+		}),
+	)
+}
+
+func TestReuseUserAccount(t *testing.T) {
+	assert.Equal(
+		t,
+		[]build.Run{
+			{
+				Command: "(getent passwd %s || (echo %s >&2 && false)) && " +
+					"([ \"$(id -u %s)\" = %s ] && [ \"$(id -g %s)\" = %s ] || (echo %s >&2 && false))",
+				Arguments: []string{
+					"www-data", "user www-data does not exist",
+					"www-data", "33", "www-data", "33", "user www-data exists with a uid or gid other than 33:33",
+				},
+			},
+			{
+				Command:   "usermod -a -G %s %s",
+				Arguments: []string{"ssl-cert", "www-data"},
+			},
+		},
+		build.ReuseUserAccount(build.UserAccount{
+			Name:   "www-data",
+			UID:    "33",
+			GID:    "33",
+			Groups: []string{"ssl-cert"},
+		}),
+	)
+}
+
+func TestUserAccountHomeDirectory(t *testing.T) {
+	assert.Equal(t, "/home/foo", build.UserAccount{Name: "foo"}.HomeDirectory())
+	assert.Equal(t, "/root", build.UserAccount{Name: "root"}.HomeDirectory())
+	assert.Equal(t, "/srv/foo", build.UserAccount{Name: "foo", Home: "/srv/foo"}.HomeDirectory())
+}
+
 func TestHome(t *testing.T) {
 	t.Run("root", func(t *testing.T) {
 		assert.Equal(t,
@@ -2,6 +2,7 @@ package build
 
 import (
 	"context"
+	"time"
 
 	"github.com/containerd/containerd/platforms"
 	"github.com/moby/buildkit/client/llb"
@@ -19,6 +20,14 @@ type Options struct {
 	// Function that returns the initial llb.State for the main build context.
 	BuildContext ContextResolver
 
+	// Function that returns the initial llb.State for a named build context.
+	// If nil, no named contexts are defined.
+	NamedContext NamedContextResolver
+
+	// Function that reads files of the main build context, e.g. to render
+	// templates. If nil, files cannot be read.
+	ReadContextFile ContextFileReader
+
 	// The target variant
 	Variant string
 
@@ -40,6 +49,21 @@ type Options struct {
 	// Function that returns whether or not to disable caching for a given named
 	// target.
 	NoCache CacheDisabler
+
+	// Time to use in place of the current time for image metadata and the
+	// timestamps of files created by the build. Typically taken from the
+	// SOURCE_DATE_EPOCH build argument.
+	//
+	// See https://reproducible-builds.org/docs/source-date-epoch/
+	SourceDateEpoch *time.Time
+
+	// Digests to which image references are pinned. Newly resolved digests
+	// are recorded in the lock. See [Lock].
+	Lock *Lock
+
+	// Whether to fail when an image reference has no digest recorded in the
+	// lock.
+	Locked bool
 }
 
 // NewOptions creates a new Options with default values assigned
@@ -47,8 +71,9 @@ func NewOptions() *Options {
 	defaultPlatform := platforms.DefaultSpec()
 
 	return &Options{
-		BuildContext: func(ctx context.Context) (*llb.State, error) {
-			localCtx := llb.Local(defaultBuildContext, llb.SharedKeyHint(defaultBuildContext))
+		BuildContext: func(ctx context.Context, opts ...llb.LocalOption) (*llb.State, error) {
+			opts = append([]llb.LocalOption{llb.SharedKeyHint(defaultBuildContext)}, opts...)
+			localCtx := llb.Local(defaultBuildContext, opts...)
 			return &localCtx, nil
 		},
 		NoCache:         func(_ string) bool { return false },
@@ -61,6 +86,11 @@ func NewOptions() *Options {
 	}
 }
 
+// Reproducible returns whether the build should produce reproducible output.
+func (opts *Options) Reproducible() bool {
+	return opts.SourceDateEpoch != nil
+}
+
 // MultiPlatform returns whether the build options contain multiple target
 // platforms.
 func (opts *Options) MultiPlatform() bool {
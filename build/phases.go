@@ -1,5 +1,7 @@
 package build
 
+import "fmt"
+
 // Phase enum type
 type Phase int
 
@@ -12,8 +14,29 @@ const (
 	PhasePreInstall                    // third, before application files and artifacts are copied
 	PhaseInstall                       // fourth, application files and artifacts are copied
 	PhasePostInstall                   // fifth, after application files and artifacts are copied
+	PhaseFinal                         // sixth, cleanup and slimming of the final filesystem
 )
 
+// String returns the name of the phase.
+func (phase Phase) String() string {
+	switch phase {
+	case PhasePrivileged:
+		return "privileged"
+	case PhasePrivilegeDropped:
+		return "privilege-dropped"
+	case PhasePreInstall:
+		return "pre-install"
+	case PhaseInstall:
+		return "install"
+	case PhasePostInstall:
+		return "post-install"
+	case PhaseFinal:
+		return "final"
+	}
+
+	return fmt.Sprintf("phase-%d", int(phase))
+}
+
 // PhaseCompileable defines and interface that all configuration types should
 // implement if they want to inject build instructions into any of the defined
 // build phases.
@@ -29,5 +52,6 @@ func Phases() []Phase {
 		PhasePreInstall,
 		PhaseInstall,
 		PhasePostInstall,
+		PhaseFinal,
 	}
 }
@@ -0,0 +1,18 @@
+package build
+
+import "github.com/moby/buildkit/client/llb"
+
+// RunAs executes a [Run] instruction's command as the given user without
+// changing the user of subsequent instructions or of the image.
+type RunAs struct {
+	User string
+}
+
+// RunOption returns an [llb.RunOption] for this user.
+func (ra RunAs) RunOption(target *Target) llb.RunOption {
+	user := target.ExpandEnv(ra.User)
+
+	return runOptionFunc(func(ei *llb.ExecInfo) {
+		ei.State = ei.State.User(user)
+	})
+}
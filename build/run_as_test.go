@@ -0,0 +1,28 @@
+package build_test
+
+import (
+	"testing"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/util/testtarget"
+)
+
+func TestRunAs(t *testing.T) {
+	_, req := testtarget.Setup(t,
+		testtarget.NewTargets("foo"),
+		func(foo *build.Target) {
+			foo.User("123")
+
+			build.RunAllWithOptions{
+				[]build.Run{{"rm -rf /usr/share/doc/*", []string{}}},
+				[]build.RunOption{build.RunAs{User: "0"}},
+			}.Compile(foo)
+
+			foo.RunShell("whoami")
+		},
+	)
+
+	_, eops := req.ContainsNExecOps(2)
+	req.Equal("0", eops[0].Exec.Meta.User)
+	req.Equal("123", eops[1].Exec.Meta.User)
+}
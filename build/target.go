@@ -1,15 +1,14 @@
 package build
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	neturl "net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
@@ -19,6 +18,7 @@ import (
 	"github.com/moby/buildkit/client/llb/sourceresolver"
 	"github.com/moby/buildkit/solver/result"
 	"github.com/moby/buildkit/util/system"
+	digest "github.com/opencontainers/go-digest"
 	oci "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
@@ -38,6 +38,9 @@ const (
 
 	// LocalContextKeyword is the name used to identify the main build context
 	LocalContextKeyword = "local"
+
+	assembleStagingDir = "/.blubber-assemble"
+	fetchDirectory     = "/.blubber-fetch"
 )
 
 // Target is used during compilation to keep track of build arguments, the
@@ -52,6 +55,16 @@ type Target struct {
 	platform     *oci.Platform
 	dependencies *TargetGroup
 	user         string
+	lockedImages map[string]string
+
+	namedContexts map[string]llb.State
+	namedBase     bool
+
+	baseState   llb.State
+	baseHistory int
+
+	contextInclude []string
+	contextExclude []string
 }
 
 // NewTarget constructs a [Target] using the given arguments and defaults
@@ -78,7 +91,7 @@ func (target *Target) BuildEnv() map[string]string {
 	targetPlatform := target.Platform()
 	buildPlatform := target.BuildPlatform()
 
-	return map[string]string{
+	env := map[string]string{
 		// Provide the same environment variables that Docker does for build and
 		// target platform
 		// see https://docs.docker.com/engine/reference/builder/#automatic-platform-args-in-the-global-scope
@@ -91,53 +104,37 @@ func (target *Target) BuildEnv() map[string]string {
 		"TARGETARCH":     targetPlatform.Architecture,
 		"TARGETVARIANT":  targetPlatform.Variant,
 	}
+
+	// Let build processes that support it (e.g. dpkg, pip, python's
+	// compileall) clamp their own timestamps
+	if target.Options.Reproducible() {
+		env["SOURCE_DATE_EPOCH"] = strconv.FormatInt(target.Options.SourceDateEpoch.Unix(), 10)
+	}
+
+	return env
 }
 
 // Initialize performs preprocessing steps, resolving the base image config,
 // adding build-time environment variables, etc.
 func (target *Target) Initialize(ctx context.Context) error {
 	if target.Base != "" {
-		ref, err := reference.ParseNormalizedNamed(target.Base)
+		ok, err := target.initializeNamedBase(ctx)
 
 		if err != nil {
-			return errors.Wrapf(err, "failed to parse stage name %q", target.Base)
+			return err
 		}
 
-		// Note this is based on implementation in upstream's Dockerfile2LLB
-		// TODO figure out why removing a specific digest is necessary when
-		// resolving an image. Perhaps it's to allow the resolver to find the right
-		// platform-specific image in what could be a manifest list?
-		resolveName := reference.TagNameOnly(ref).String()
-		platform := target.Platform()
+		if ok {
+			return target.initializeState()
+		}
 
-		mutRef, digest, config, err := target.Options.MetaResolver.ResolveImageConfig(ctx, resolveName, sourceresolver.Opt{
-			Platform: &platform,
-			LogName:  target.Logf("resolving image metadata for %s", resolveName),
-		})
+		base, config, err := target.resolveImage(ctx, target.Base)
 
 		if err != nil {
-			return errors.Wrap(err, "failed to resolve image config")
-		}
-
-		// The return of a new ref by ResolveImageConfig is a new behavior as of
-		// buildkit v0.14.0. It isn't clear exactly why this is needed, but the
-		// following overwriting of ref is based on the dockerfile frontend
-		if ref.String() != mutRef {
-			ref, err = reference.ParseNormalizedNamed(mutRef)
-			if err != nil {
-				return errors.Wrapf(err, "failed to parse ref %q", mutRef)
-			}
+			return err
 		}
 
-		if digest != "" {
-			refWithDigest, err := reference.WithDigest(ref, digest)
-
-			if err != nil {
-				return errors.Wrap(err, "failed to get digest from ref")
-			}
-
-			target.Base = refWithDigest.String()
-		}
+		target.Base = base
 
 		var img oci.Image
 		if err := json.Unmarshal(config, &img); err != nil {
@@ -145,7 +142,6 @@ func (target *Target) Initialize(ctx context.Context) error {
 		}
 
 		target.image = &img
-		target.image.Created = nil
 
 		imageOpts := []llb.ImageOption{
 			llb.Platform(target.Platform()),
@@ -162,6 +158,51 @@ func (target *Target) Initialize(ctx context.Context) error {
 		)
 	}
 
+	return target.initializeState()
+}
+
+// initializeNamedBase initializes the target's state and image config from
+// the named build context of the same name as the target's base, returning
+// whether such a context is defined.
+func (target *Target) initializeNamedBase(ctx context.Context) (bool, error) {
+	resolver := target.Options.NamedContext
+
+	if resolver == nil {
+		return false, nil
+	}
+
+	state, img, err := resolver(ctx, target.Base, target.Platform())
+
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to resolve named context %q", target.Base)
+	}
+
+	if state == nil {
+		return false, nil
+	}
+
+	target.state = *state
+	target.namedBase = true
+
+	if img != nil {
+		target.image = img
+	}
+
+	return true, nil
+}
+
+// initializeState sets up the target's initial state using the resolved
+// base image config.
+func (target *Target) initializeState() error {
+	// The creation time of the base image is never inherited. For
+	// reproducible builds it is set to the given epoch, otherwise it is left
+	// for the exporter to set.
+	target.image.Created = target.Options.SourceDateEpoch
+
+	// Keep track of the base filesystem and history for squashing
+	target.baseState = target.state
+	target.baseHistory = len(target.image.History)
+
 	// Set up our initial state using meta data from the image config. This
 	// includes environment variables, the working directory, and the default
 	// build process owner (user)
@@ -196,6 +237,165 @@ func (target *Target) Initialize(ctx context.Context) error {
 	return nil
 }
 
+// Compile compiles the given [Instruction] and records an entry describing it
+// in the image history. The entry is marked as an empty layer if the
+// instruction did not add any filesystem operation to the target state, i.e.
+// if the state's output is unchanged. The given origin (e.g. the build phase
+// and configuration section from which the instruction originated) is
+// included in the entry's description.
+func (target *Target) Compile(instruction Instruction, origin string) error {
+	output := target.state.Output()
+
+	err := instruction.Compile(target)
+	if err != nil {
+		return err
+	}
+
+	switch instruction.(type) {
+	case Base, ScratchBase:
+		// The history of the base image is inherited as is
+		return nil
+	}
+
+	target.Image.AddHistory(
+		fmt.Sprintf("[%s] %v", origin, instruction),
+		target.state.Output() == output,
+	)
+
+	return nil
+}
+
+// LockImage resolves the given external image reference (e.g. one used by a
+// copy or mount), pinning it to the digest recorded in the [Lock] given via
+// [Options] or otherwise recording the resolved digest. Subsequent uses of
+// the reference by [Target.NamedContext] use the pinned reference.
+func (target *Target) LockImage(ctx context.Context, name string) error {
+	pinned, _, err := target.resolveImage(ctx, name)
+
+	if err != nil {
+		return err
+	}
+
+	if target.lockedImages == nil {
+		target.lockedImages = map[string]string{}
+	}
+
+	target.lockedImages[name] = pinned
+
+	return nil
+}
+
+// ResolveNamedContext resolves the named build context of the given name
+// (e.g. one referenced by a copy or mount) using the [NamedContextResolver]
+// given via [Options], returning whether such a context is defined.
+// Subsequent uses of the name by [Target.NamedContext] use the resolved
+// context.
+func (target *Target) ResolveNamedContext(ctx context.Context, name string) (bool, error) {
+	resolver := target.Options.NamedContext
+
+	if resolver == nil {
+		return false, nil
+	}
+
+	state, _, err := resolver(ctx, name, target.Platform())
+
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to resolve named context %q", name)
+	}
+
+	if state == nil {
+		return false, nil
+	}
+
+	if target.namedContexts == nil {
+		target.namedContexts = map[string]llb.State{}
+	}
+
+	target.namedContexts[name] = *state
+
+	return true, nil
+}
+
+// HasNamedBase returns whether the target's base was resolved from a named
+// build context rather than an image reference.
+func (target *Target) HasNamedBase() bool {
+	return target.namedBase
+}
+
+// resolveImage resolves the config of the given image reference for the
+// target platform, returning the reference pinned to the resolved digest
+// along with the raw config. If a [Lock] was given via [Options], the
+// reference is first pinned to the digest recorded in the lock, and the
+// resolved digest is recorded in the lock.
+func (target *Target) resolveImage(ctx context.Context, name string) (string, []byte, error) {
+	ref, err := reference.ParseNormalizedNamed(name)
+
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "failed to parse stage name %q", name)
+	}
+
+	platform := target.Platform()
+	lock := target.Options.Lock
+
+	if lock != nil {
+		locked, ok := lock.Get(name, platform)
+
+		if ok {
+			ref, err = reference.WithDigest(ref, locked)
+
+			if err != nil {
+				return "", nil, errors.Wrapf(err, "failed to pin %q to locked digest", name)
+			}
+		} else if target.Options.Locked {
+			return "", nil, errors.Errorf(
+				"%s has no digest recorded for %s (%s); run `blubber lock` to update it",
+				LockFilename, name, platforms.Format(platform),
+			)
+		}
+	}
+
+	// Note this is based on implementation in upstream's Dockerfile2LLB
+	// TODO figure out why removing a specific digest is necessary when
+	// resolving an image. Perhaps it's to allow the resolver to find the right
+	// platform-specific image in what could be a manifest list?
+	resolveName := reference.TagNameOnly(ref).String()
+
+	mutRef, digest, config, err := target.Options.MetaResolver.ResolveImageConfig(ctx, resolveName, sourceresolver.Opt{
+		Platform: &platform,
+		LogName:  target.Logf("resolving image metadata for %s", resolveName),
+	})
+
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to resolve image config")
+	}
+
+	// The return of a new ref by ResolveImageConfig is a new behavior as of
+	// buildkit v0.14.0. It isn't clear exactly why this is needed, but the
+	// following overwriting of ref is based on the dockerfile frontend
+	if ref.String() != mutRef {
+		ref, err = reference.ParseNormalizedNamed(mutRef)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "failed to parse ref %q", mutRef)
+		}
+	}
+
+	if digest == "" {
+		return name, config, nil
+	}
+
+	refWithDigest, err := reference.WithDigest(ref, digest)
+
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to get digest from ref")
+	}
+
+	if lock != nil {
+		lock.Set(name, platform, digest)
+	}
+
+	return refWithDigest.String(), config, nil
+}
+
 // ExposeBuildArg looks for a build argument and adds an environment variable
 // for it to the target build state. If a build argument is not found, the
 // given default value is used.
@@ -249,9 +449,44 @@ func (target *Target) DescribeExecf(msg string, values ...interface{}) llb.Const
 	return target.Describef("%s %s "+msg, v...)
 }
 
+// FilterContext restricts the files of the main build context loaded by
+// [Target.BuildContext] to those matching the given include patterns, if
+// any, and not matching the given exclude patterns.
+func (target *Target) FilterContext(include []string, exclude []string) {
+	target.contextInclude = include
+	target.contextExclude = exclude
+}
+
 // BuildContext returns the llb.State for the main build context
 func (target *Target) BuildContext() (*llb.State, error) {
-	return target.Options.BuildContext(context.TODO())
+	return target.Options.BuildContext(context.TODO(), target.contextOptions()...)
+}
+
+// ReadContextFile reads the file at the given path of the main build context
+// using the [ContextFileReader] given via [Options]. Files excluded from the
+// context by [Target.FilterContext] cannot be read.
+func (target *Target) ReadContextFile(ctx context.Context, path string) ([]byte, error) {
+	if target.Options.ReadContextFile == nil {
+		return nil, errors.New("files cannot be read from the build context")
+	}
+
+	return target.Options.ReadContextFile(ctx, path, target.contextOptions()...)
+}
+
+// contextOptions returns the options with which the main build context is
+// loaded.
+func (target *Target) contextOptions() []llb.LocalOption {
+	opts := []llb.LocalOption{}
+
+	if len(target.contextInclude) > 0 {
+		opts = append(opts, llb.IncludePatterns(target.contextInclude))
+	}
+
+	if len(target.contextExclude) > 0 {
+		opts = append(opts, ExcludePatterns(target.contextExclude))
+	}
+
+	return opts
 }
 
 // CopyFromBuildContext copies one or more sources from the main build context
@@ -270,6 +505,239 @@ func (target *Target) CopyFrom(from string, sources []string, destination string
 	return target.copy(sources, destination, from, options)
 }
 
+// CopyFromHTTP downloads the file at the given URL, verifies it against the
+// given checksum, and copies it to the given destination path on the target
+// filesystem with the given file mode. If the destination is a directory
+// (i.e. ends with "/"), the file retains the name of the URL path.
+//
+// Files are downloaded by BuildKit itself unless proxies are given as build
+// arguments, in which case they are downloaded through the proxies by a
+// build process using the curl or wget of the target's filesystem (see
+// [Target.fetchHTTP]).
+func (target *Target) CopyFromHTTP(url string, checksum digest.Digest, destination string, mode os.FileMode, options ...llb.CopyOption) error {
+	if checksum == "" {
+		return errors.Errorf("a checksum is required to download %s", url)
+	}
+
+	if err := checksum.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid checksum for %s", url)
+	}
+
+	filename := downloadFilename(url, destination)
+
+	var httpState llb.State
+
+	if pe := target.proxyEnv(); pe != nil {
+		fetched, err := target.fetchHTTP(url, checksum, filename, mode, *pe)
+
+		if err != nil {
+			return err
+		}
+
+		httpState = fetched
+	} else {
+		httpState = llb.HTTP(
+			url,
+			llb.Checksum(checksum),
+			llb.Filename(filename),
+			llb.Chmod(mode),
+			target.Describef("%s %s", emojiExternal, url),
+		)
+	}
+
+	copyOpts := []llb.CopyOption{
+		&llb.CopyInfo{
+			CreateDestPath: true,
+		},
+	}
+
+	copyOpts = append(copyOpts, options...)
+
+	if target.Options.Reproducible() {
+		copyOpts = append(copyOpts, llb.WithCreatedTime(*target.Options.SourceDateEpoch))
+	}
+
+	fileOpts := []llb.ConstraintsOpt{
+		target.Describef("%s %s -> %+v", emojiExternal, url, destination),
+	}
+
+	if target.noCache() {
+		fileOpts = append(fileOpts, llb.IgnoreCache)
+	}
+
+	target.state = target.state.File(
+		llb.Copy(httpState, "/"+filename, destination, copyOpts...),
+		fileOpts...,
+	)
+	return nil
+}
+
+// fetchHTTP returns a state containing the file at the given URL under the
+// given filename, downloaded through the given proxies by a build process
+// executed on the target's current filesystem, which must provide a shell,
+// curl or wget, and a checksum utility for the digest algorithm (e.g.
+// sha256sum). The file is verified against the given checksum.
+func (target *Target) fetchHTTP(url string, checksum digest.Digest, filename string, mode os.FileMode, pe llb.ProxyEnv) (llb.State, error) {
+	if target.state.Output() == nil {
+		return llb.State{}, errors.Errorf(
+			"cannot download %s through a proxy without a base image providing curl or wget", url,
+		)
+	}
+
+	script := strings.Join([]string{
+		`set -e`,
+		`cd ` + fetchDirectory,
+		`if command -v curl > /dev/null; then curl -fsSL -o "$2" "$1"; else wget -q -O "$2" "$1"; fi`,
+		`echo "$3  $2" | ` + checksum.Algorithm().String() + `sum -c - > /dev/null || (echo "checksum of $1 does not match $3" >&2 && false)`,
+		`chmod "$4" "$2"`,
+	}, "\n")
+
+	runOpts := []llb.RunOption{
+		llb.Args([]string{
+			"/bin/sh", "-c", script, "fetch",
+			url, filename, checksum.Encoded(), fmt.Sprintf("%04o", mode.Perm()),
+		}),
+		llb.User("0"),
+		llb.WithProxy(pe),
+		target.DescribeExecf("%s %s", emojiExternal, url),
+	}
+
+	if target.noCache() {
+		runOpts = append(runOpts, llb.IgnoreCache)
+	}
+
+	return target.state.Run(runOpts...).AddMount(fetchDirectory, llb.Scratch()), nil
+}
+
+// downloadFilename returns the name of the file downloaded from the given URL
+// to the given destination.
+func downloadFilename(rawURL string, destination string) string {
+	if !strings.HasSuffix(destination, "/") {
+		return path.Base(destination)
+	}
+
+	if parsed, err := neturl.Parse(rawURL); err == nil {
+		if name := path.Base(parsed.Path); name != "." && name != "/" {
+			return name
+		}
+	}
+
+	return "download"
+}
+
+// Assemble copies the given paths, the given binaries and the shared
+// libraries they link against, and the passwd and group entries of root and
+// the given users from the filesystem of the given dependency (a variant or
+// image) onto the target filesystem, preserving ownership and permissions.
+// Optional paths are only copied if they exist.
+//
+// The files are collected by a shell script executed in the dependency as
+// root. Shared libraries are resolved using ldd, so the dependency must
+// provide a shell and ldd but the target does not. User names may reference
+// environment variables of the target (e.g. "$RUNS_AS"), which are expanded
+// before the script is executed in the dependency.
+func (target *Target) Assemble(from string, paths []string, optional []string, binaries []string, users []string) error {
+	if from == "" {
+		return errors.New("no dependency to assemble from")
+	}
+
+	names := make([]string, 0, len(users))
+	for _, user := range users {
+		if name := target.ExpandEnv(user); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	script := assembleScript(assembleStagingDir, paths, optional, binaries, names)
+
+	dep := target.NamedContext(from)
+
+	exec := dep.Run(
+		llb.Args([]string{"/bin/sh", "-c", script}),
+		runOptionFunc(func(ei *llb.ExecInfo) {
+			ei.State = ei.State.User("0")
+		}),
+		target.Describef("%s assembling from {%s}", emojiImage, from),
+	)
+
+	staged := exec.AddMount(assembleStagingDir, llb.Scratch())
+
+	copyOpts := []llb.CopyOption{
+		&llb.CopyInfo{
+			CopyDirContentsOnly: true,
+			CreateDestPath:      true,
+		},
+	}
+
+	if target.Options.Reproducible() {
+		copyOpts = append(copyOpts, llb.WithCreatedTime(*target.Options.SourceDateEpoch))
+	}
+
+	fileOpts := []llb.ConstraintsOpt{
+		target.Describef("%s {%s} -> /", emojiImage, from),
+	}
+
+	if target.noCache() {
+		fileOpts = append(fileOpts, llb.IgnoreCache)
+	}
+
+	target.state = target.state.File(
+		llb.Copy(staged, "/", "/", copyOpts...),
+		fileOpts...,
+	)
+	return nil
+}
+
+// Squash squashes all layers added on top of the base image into a single
+// layer. The layers and history of the base image are retained so they can
+// still be shared with other images. Files removed from previous layers no
+// longer take up space, and files removed from the base image are recorded
+// as deletions in the squashed layer.
+//
+// The squashed layer is the difference between the base filesystem and a copy
+// of the entire filesystem onto a fresh state. As the copy does not share a
+// history with the base, the difference is always exported as a single layer.
+func (target *Target) Squash() error {
+	copyOpts := []llb.CopyOption{
+		&llb.CopyInfo{
+			CopyDirContentsOnly: true,
+			CreateDestPath:      true,
+		},
+	}
+
+	if target.Options.Reproducible() {
+		copyOpts = append(copyOpts, llb.WithCreatedTime(*target.Options.SourceDateEpoch))
+	}
+
+	fileOpts := []llb.ConstraintsOpt{
+		target.Describef("%s squashing layers", emojiImage),
+	}
+
+	if target.noCache() {
+		fileOpts = append(fileOpts, llb.IgnoreCache)
+	}
+
+	squashed := llb.Scratch().File(
+		llb.Copy(target.state, "/", "/", copyOpts...),
+		fileOpts...,
+	)
+
+	if target.baseState.Output() != nil {
+		squashed = llb.Merge(
+			[]llb.State{
+				target.baseState,
+				llb.Diff(target.baseState, squashed, target.Describef("%s diffing squashed layers", emojiImage)),
+			},
+			target.Describef("%s merging squashed layers onto base", emojiImage),
+		)
+	}
+
+	// Retain the metadata (env, working directory, user) of the current state
+	target.state = target.state.WithOutput(squashed.Output())
+	target.image.History = target.image.History[:target.baseHistory]
+	return nil
+}
+
 func (target *Target) copy(sources []string, destination string, from string, options []llb.CopyOption) error {
 	// If there is more than 1 file being copied, the destination must be a
 	// directory ending with "/"
@@ -322,6 +790,10 @@ func (target *Target) copy(sources []string, destination string, from string, op
 
 	copyOpts = append(copyOpts, options...)
 
+	if target.Options.Reproducible() {
+		copyOpts = append(copyOpts, llb.WithCreatedTime(*target.Options.SourceDateEpoch))
+	}
+
 	for _, src := range sources {
 		if fa == nil {
 			fa = llb.Copy(*fromState, src, destination, copyOpts...)
@@ -338,18 +810,27 @@ func (target *Target) copy(sources []string, destination string, from string, op
 	return nil
 }
 
-// Mkfile creates a single file with the given content.
-func (target *Target) Mkfile(path string, mode os.FileMode, data []byte, opts ...llb.MkfileOption) error {
+// Mkfile creates a single file with the given content. Missing parent
+// directories are created first so that files may also be written to an
+// empty (scratch) filesystem.
+func (target *Target) Mkfile(file string, mode os.FileMode, data []byte, opts ...llb.MkfileOption) error {
 	fileOpts := []llb.ConstraintsOpt{
-		target.Describef("%s %+v", emojiFile, path),
+		target.Describef("%s %+v", emojiFile, file),
 	}
 
 	if target.noCache() {
 		fileOpts = append(fileOpts, llb.IgnoreCache)
 	}
 
+	mkdirOpts := []llb.MkdirOption{llb.WithParents(true)}
+
+	if target.Options.Reproducible() {
+		opts = append(opts, llb.WithCreatedTime(*target.Options.SourceDateEpoch))
+		mkdirOpts = append(mkdirOpts, llb.WithCreatedTime(*target.Options.SourceDateEpoch))
+	}
+
 	target.state = target.state.File(
-		llb.Mkfile(path, mode, data, opts...),
+		llb.Mkdir(path.Dir(file), 0755, mkdirOpts...).Mkfile(file, mode, data, opts...),
 		fileOpts...,
 	)
 	return nil
@@ -385,20 +866,7 @@ func (target *Target) RunAll(runs [][]string, opts ...llb.RunOption) error {
 			return errors.New("no run command")
 		}
 
-		cmd := run[0]
-		args := run[1:]
-
-		// 1. Count the number of % formatting tokens (n) in the command string
-		// 2. Format the command string along with n of the leading arguments
-		// 3. Append the remaining arguments to the command string
-		numInnerArgs := strings.Count(cmd, `%`) - strings.Count(cmd, `%%`)
-		command := sprintf(cmd, args[0:numInnerArgs])
-
-		if len(args) > numInnerArgs {
-			command += " " + strings.Join(quoteAll(args[numInnerArgs:]), " ")
-		}
-
-		commands[i] = command
+		commands[i] = shellCommand(run[0], run[1:])
 	}
 
 	return target.RunShell(strings.Join(commands, " && "), opts...)
@@ -408,13 +876,7 @@ func (target *Target) RunAll(runs [][]string, opts ...llb.RunOption) error {
 // mounting that filesystem during execution. A default `#!/bin/sh` line is
 // prepended if the script does not contain its own shebang.
 func (target *Target) RunScript(script []byte, opts ...llb.RunOption) error {
-	if !bytes.HasPrefix(script, []byte(`#!`)) {
-		script = append([]byte("#!/bin/sh\n"), script...)
-	}
-
-	sha := sha256.New()
-	sha.Write(script)
-	digest := hex.EncodeToString(sha.Sum(nil))
+	script, digest := normalizeScript(script)
 	scriptName := "script"
 	scriptDir := path.Join("/", digest)
 	scriptPath := path.Join(scriptDir, scriptName)
@@ -451,33 +913,52 @@ func (target *Target) RunShell(command string, opts ...llb.RunOption) error {
 }
 
 // ExpandEnv substitutes environment variable references in the given string
-// for the current values taken from the current target state
+// for the current values taken from the current target state. A default value
+// may be given using `${NAME:-default}`, which is used if the variable is
+// undefined or empty, or `${NAME-default}`, which is used only if it is
+// undefined.
 func (target *Target) ExpandEnv(subject string) string {
 	ctx := context.TODO()
 
 	return os.Expand(subject, func(key string) string {
-		val, ok, _ := target.state.GetEnv(ctx, key)
+		name, def := key, ""
+		emptyIsUnset := false
 
-		if ok {
+		if i := strings.Index(key, "-"); i >= 0 {
+			name, def = key[:i], key[i+1:]
+
+			if strings.HasSuffix(name, ":") {
+				name, emptyIsUnset = strings.TrimSuffix(name, ":"), true
+			}
+		}
+
+		val, ok, _ := target.state.GetEnv(ctx, name)
+
+		if ok && !(emptyIsUnset && val == "") {
 			return val
 		}
 
-		return ""
+		return def
 	})
 }
 
+// HasEnv returns whether the given environment variable is defined in the
+// current target state.
+func (target *Target) HasEnv(name string) bool {
+	_, ok, _ := target.state.GetEnv(context.TODO(), name)
+	return ok
+}
+
 // NamedContext looks in the target's dependencies for an entry with the given
 // name and returns its [llb.State]. If no dependency with the given name is
-// found, the name is assumed to be an image ref. If the name is "local", the
-// main build context is used.
-//
-// TODO if a NamedContextResolver were implemented (similar to
-// [ContextResolver], we could delegate to that. This would allow the buildkit
-// gateway to in turn delegate to [dockerui.Client.NamedContext].
+// found, named build contexts previously resolved by
+// [Target.ResolveNamedContext] are consulted, and failing that the name is
+// assumed to be an image ref. If the name is "local", the main build context
+// is used.
 func (target *Target) NamedContext(name string) llb.State {
 	if name == LocalContextKeyword {
 		mainCtx, err := target.BuildContext()
-		if err != nil {
+		if err == nil {
 			return *mainCtx
 		}
 	}
@@ -487,6 +968,14 @@ func (target *Target) NamedContext(name string) llb.State {
 		return dep.state
 	}
 
+	if state, ok := target.namedContexts[name]; ok {
+		return state
+	}
+
+	if pinned, ok := target.lockedImages[name]; ok {
+		name = pinned
+	}
+
 	imageOpts := []llb.ImageOption{
 		llb.Platform(target.Platform()),
 		target.Describef("%s %s", emojiExternal, name),
@@ -622,6 +1111,12 @@ func (target *Target) Scan(scanner Scanner) (result.Attestation[*llb.State], err
 	return scanner(target.state, depStates)
 }
 
+// Dependencies returns the group of targets that this target may depend
+// upon, including the target itself.
+func (target *Target) Dependencies() TargetGroup {
+	return *target.dependencies
+}
+
 // noCache returns whether caching for this target is disabled.
 func (target *Target) noCache() bool {
 	return target.Options.NoCache(target.Name)
@@ -645,6 +1140,20 @@ func (target *Target) run(runOpts ...llb.RunOption) error {
 	return nil
 }
 
+// IsProxyArg returns whether the given build argument is one of the
+// predefined proxy arguments (HTTP_PROXY, HTTPS_PROXY, FTP_PROXY, NO_PROXY,
+// ALL_PROXY, in either case), which are passed to build processes but may
+// contain credentials and are therefore not recorded in build metadata.
+func IsProxyArg(name string) bool {
+	for _, proxyArg := range []string{"http_proxy", "https_proxy", "ftp_proxy", "no_proxy", "all_proxy"} {
+		if strings.EqualFold(name, proxyArg) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // proxyEnv returns a non-nil *llb.ProxyEnv if any build arguments were passed
 // for HTTP_PROXY, HTTPS_PROXY, FTP_PROXY, NO_PROXY, ALL_PROXY. Otherwise, it
 // returns nil.
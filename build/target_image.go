@@ -1,5 +1,11 @@
 package build
 
+import (
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const historyComment = "buildkit.blubber.v0"
+
 // TargetImage wraps a [Target] and provides builder style methods for altering its
 // internal image configuration.
 type TargetImage struct {
@@ -60,6 +66,20 @@ func (img *TargetImage) AddLabels(labels map[string]string) *TargetImage {
 	return img
 }
 
+// AddHistory appends an entry to the image history. The entry should be
+// marked as an empty layer if the step it describes did not alter the
+// filesystem.
+func (img *TargetImage) AddHistory(createdBy string, emptyLayer bool) *TargetImage {
+	img.target.image.History = append(img.target.image.History, oci.History{
+		Created:    img.target.Options.SourceDateEpoch,
+		CreatedBy:  createdBy,
+		Comment:    historyComment,
+		EmptyLayer: emptyLayer,
+	})
+
+	return img
+}
+
 func replaceEnv(env []string, name, value string) []string {
 	replacedExisting := false
 
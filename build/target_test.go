@@ -4,6 +4,7 @@ import (
 	"context"
 	"io/fs"
 	"testing"
+	"time"
 
 	"github.com/moby/buildkit/client/llb"
 	"github.com/moby/buildkit/solver/pb"
@@ -133,6 +134,140 @@ func TestBuildEnv(t *testing.T) {
 	)
 }
 
+func TestReproducible(t *testing.T) {
+	epoch := time.Unix(1700000000, 0).UTC()
+
+	t.Run("sets the image creation time", func(t *testing.T) {
+		targets := testtarget.NewTargets("foo")
+		targets[0].Options.SourceDateEpoch = &epoch
+
+		image, _ := testtarget.Setup(t, targets)
+
+		require.NotNil(t, image.Created)
+		require.Equal(t, epoch, *image.Created)
+	})
+
+	t.Run("exposes SOURCE_DATE_EPOCH to build processes", func(t *testing.T) {
+		req := require.New(t)
+		target := testtarget.NewTarget("foo")
+		target.Options.SourceDateEpoch = &epoch
+
+		req.Contains(target.BuildEnv(), "SOURCE_DATE_EPOCH")
+		req.Equal("1700000000", target.BuildEnv()["SOURCE_DATE_EPOCH"])
+	})
+
+	t.Run("sets timestamps of created files", func(t *testing.T) {
+		_, req := testtarget.Setup(t,
+			testtarget.NewTargets("foo"),
+			func(target *build.Target) {
+				target.Options.SourceDateEpoch = &epoch
+				target.Mkfile("/foo", fs.FileMode(0o644), []byte("foo"))
+				target.CopyFromBuildContext([]string{"bar"}, "/bar")
+			},
+		)
+
+		_, fileOps := req.ContainsNFileOps(2)
+
+		_, mkfiles := req.ContainsNMkfileActions(fileOps[0], 1)
+		req.Equal(epoch.UnixNano(), mkfiles[0].Mkfile.Timestamp)
+
+		_, copies := req.ContainsNCopyActions(fileOps[1], 1)
+		req.Equal(epoch.UnixNano(), copies[0].Copy.Timestamp)
+	})
+
+	t.Run("leaves image creation time unset by default", func(t *testing.T) {
+		image, _ := testtarget.Setup(t, testtarget.NewTargets("foo"))
+
+		require.Nil(t, image.Created)
+	})
+}
+
+func TestCompile(t *testing.T) {
+	t.Run("records image history", func(t *testing.T) {
+		image, _ := testtarget.Setup(t,
+			testtarget.NewTargets("foo"),
+			func(target *build.Target) {
+				require.NoError(t, target.Compile(build.Base{Image: "foo"}, "privileged/base"))
+				require.NoError(t, target.Compile(build.Run{"echo %s", []string{"foo"}}, "privileged/apt"))
+				require.NoError(t, target.Compile(build.User{"123"}, "privileged/user"))
+			},
+		)
+
+		require.Equal(t,
+			[]oci.History{
+				{
+					CreatedBy:  `[privileged/apt] RUN echo "foo"`,
+					Comment:    "buildkit.blubber.v0",
+					EmptyLayer: false,
+				},
+				{
+					CreatedBy:  "[privileged/user] USER 123",
+					Comment:    "buildkit.blubber.v0",
+					EmptyLayer: true,
+				},
+			},
+			image.History,
+		)
+	})
+
+	t.Run("records a non-empty layer for each filesystem operation", func(t *testing.T) {
+		image, req := testtarget.Setup(t,
+			testtarget.NewTargets("foo"),
+			func(target *build.Target) {
+				for _, ins := range []build.Instruction{
+					build.Env{map[string]string{"FOO": "bar"}},
+					build.Run{"echo %s", []string{"foo"}},
+					build.Label{map[string]string{"foo": "bar"}},
+					build.File{Path: "/srv/foo", Content: []byte("foo"), Mode: 0o644},
+					build.WorkingDirectory{"/srv"},
+					build.Copy{Sources: []string{"."}, Destination: "."},
+					build.User{"123"},
+					build.RunAll{[]build.Run{{"echo", []string{"foo"}}, {"echo", []string{"bar"}}}},
+					build.EntryPoint{[]string{"foo"}},
+				} {
+					require.NoError(t, target.Compile(ins, "final/test"))
+				}
+			},
+		)
+
+		req.ContainsNExecOps(2)
+		req.ContainsNFileOps(2)
+
+		var layers []string
+		for _, entry := range image.History {
+			if !entry.EmptyLayer {
+				layers = append(layers, entry.CreatedBy)
+			}
+		}
+
+		req.Len(image.History, 9)
+		req.Equal(
+			[]string{
+				`[final/test] RUN echo "foo"`,
+				"[final/test] FILE /srv/foo 0644",
+				"[final/test] COPY . .",
+				`[final/test] RUN echo "foo" && echo "bar"`,
+			},
+			layers,
+		)
+	})
+
+	t.Run("records history creation time when reproducible", func(t *testing.T) {
+		epoch := time.Unix(1700000000, 0).UTC()
+
+		image, _ := testtarget.Setup(t,
+			testtarget.NewTargets("foo"),
+			func(target *build.Target) {
+				target.Options.SourceDateEpoch = &epoch
+				require.NoError(t, target.Compile(build.WorkingDirectory{"/srv"}, "privileged/lives"))
+			},
+		)
+
+		require.Len(t, image.History, 1)
+		require.Equal(t, &epoch, image.History[0].Created)
+	})
+}
+
 func TestExposeBuildArg(t *testing.T) {
 	t.Run("tries build args from options first", func(t *testing.T) {
 		_, req := testtarget.Setup(t,
@@ -228,6 +363,103 @@ func TestCopyFromBuildContext(t *testing.T) {
 	}
 }
 
+func TestFilterContext(t *testing.T) {
+	_, req := testtarget.Setup(t,
+		testtarget.NewTargets("foo"),
+		func(target *build.Target) {
+			target.Options.BuildContext = func(ctx context.Context, opts ...llb.LocalOption) (*llb.State, error) {
+				opts = append([]llb.LocalOption{llb.ExcludePatterns([]string{".git"})}, opts...)
+				localCtx := llb.Local("context", opts...)
+				return &localCtx, nil
+			}
+
+			target.FilterContext([]string{"services/api", "lib"}, []string{"**/node_modules"})
+			target.CopyFromBuildContext([]string{"."}, "/srv/app/")
+		},
+	)
+
+	fops, _ := req.ContainsNFileOps(1)
+	inputs := req.HasValidInputs(fops[0])
+	req.Len(inputs, 2)
+
+	req.IsType((*pb.Op_Source)(nil), inputs[1].Op)
+
+	attrs := inputs[1].Op.(*pb.Op_Source).Source.Attrs
+	req.Equal(`["services/api","lib"]`, attrs[pb.AttrIncludePatterns])
+	req.Equal(`[".git","**/node_modules"]`, attrs[pb.AttrExcludePatterns])
+}
+
+func TestReadContextFile(t *testing.T) {
+	req := require.New(t)
+
+	var targets build.TargetGroup
+	target := targets.NewTarget("foo", "", nil, build.NewOptions())
+
+	_, err := target.ReadContextFile(context.Background(), "foo.tmpl")
+	req.EqualError(err, "files cannot be read from the build context")
+
+	target.Options.ReadContextFile = func(_ context.Context, path string, opts ...llb.LocalOption) ([]byte, error) {
+		included, err := build.ContextIncludes(path, opts...)
+
+		if err != nil || !included {
+			return nil, fs.ErrNotExist
+		}
+
+		return []byte(path), nil
+	}
+
+	target.FilterContext([]string{"templates"}, []string{"**/*.secret"})
+
+	data, err := target.ReadContextFile(context.Background(), "templates/foo.tmpl")
+	req.NoError(err)
+	req.Equal("templates/foo.tmpl", string(data))
+
+	_, err = target.ReadContextFile(context.Background(), "templates/foo.secret")
+	req.ErrorIs(err, fs.ErrNotExist)
+
+	_, err = target.ReadContextFile(context.Background(), "foo.tmpl")
+	req.ErrorIs(err, fs.ErrNotExist)
+}
+
+func TestContextIncludes(t *testing.T) {
+	examples := []struct {
+		file     string
+		opts     []llb.LocalOption
+		included bool
+	}{
+		{"foo.tmpl", nil, true},
+		{"foo.tmpl", []llb.LocalOption{llb.IncludePatterns([]string{"*.tmpl"})}, true},
+		{"lib/foo.tmpl", []llb.LocalOption{llb.IncludePatterns([]string{"lib"})}, true},
+		{"/lib/foo.tmpl", []llb.LocalOption{llb.IncludePatterns([]string{"lib"})}, true},
+		{"foo.tmpl", []llb.LocalOption{llb.IncludePatterns([]string{"lib"})}, false},
+		{"foo.tmpl", []llb.LocalOption{build.ExcludePatterns([]string{"*.tmpl"})}, false},
+		{"lib/foo.tmpl", []llb.LocalOption{build.ExcludePatterns([]string{"lib"})}, false},
+		{
+			"lib/foo.tmpl",
+			[]llb.LocalOption{
+				build.ExcludePatterns([]string{".git"}),
+				build.ExcludePatterns([]string{"**/*.tmpl"}),
+			},
+			false,
+		},
+		{
+			"lib/foo.tmpl",
+			[]llb.LocalOption{
+				llb.IncludePatterns([]string{"lib"}),
+				build.ExcludePatterns([]string{"**/*.secret"}),
+			},
+			true,
+		},
+	}
+
+	for _, example := range examples {
+		included, err := build.ContextIncludes(example.file, example.opts...)
+
+		require.NoError(t, err)
+		require.Equalf(t, example.included, included, "%s", example.file)
+	}
+}
+
 func TestCopyFrom(t *testing.T) {
 	_, req := testtarget.Setup(t,
 		testtarget.NewTargets("bar", "foo"),
@@ -266,6 +498,203 @@ func TestCopyFrom(t *testing.T) {
 	req.Equal("/srv/foo/dest", copy.Dest)
 }
 
+func TestNamedContexts(t *testing.T) {
+	ctx := context.Background()
+	req := require.New(t)
+
+	options := build.NewOptions()
+	options.MetaResolver = testmetaresolver.New("foo/base", oci.Image{})
+	options.NamedContext = func(_ context.Context, name string, platform oci.Platform) (*llb.State, *oci.Image, error) {
+		switch name {
+		case "base":
+			st := llb.Image("docker-registry.wikimedia.org/override")
+			return &st, &oci.Image{Config: oci.ImageConfig{WorkingDir: "/override"}}, nil
+		case "monorepo":
+			st := llb.Git("https://gitlab.wikimedia.org/repos/monorepo.git", "main")
+			return &st, nil, nil
+		}
+
+		return nil, nil, nil
+	}
+
+	target := build.NewTarget("foo", "base", nil, options)
+
+	req.NoError(target.Initialize(ctx))
+	req.True(target.HasNamedBase())
+
+	ok, err := target.ResolveNamedContext(ctx, "monorepo")
+	req.NoError(err)
+	req.True(ok)
+
+	ok, err = target.ResolveNamedContext(ctx, "foo/other")
+	req.NoError(err)
+	req.False(ok)
+
+	target.CopyFrom("monorepo", []string{"lib"}, "lib")
+
+	def, image, err := target.Marshal(ctx)
+	req.NoError(err)
+	req.Equal("/override", image.Config.WorkingDir)
+
+	llbreq := llbtest.New(t, def)
+
+	_, sourceOps := llbreq.ContainsNSourceOps(2)
+
+	identifiers := []string{
+		sourceOps[0].Source.Identifier,
+		sourceOps[1].Source.Identifier,
+	}
+
+	req.Contains(identifiers, "docker-image://docker-registry.wikimedia.org/override:latest")
+	req.Contains(identifiers, "git://gitlab.wikimedia.org/repos/monorepo.git#main")
+
+	_, fileOps := llbreq.ContainsNFileOps(1)
+	_, copies := llbreq.ContainsNCopyActions(fileOps[0], 1)
+
+	req.Equal("/lib", copies[0].Copy.Src)
+	req.Equal("/override/lib", copies[0].Copy.Dest)
+}
+
+func TestAssemble(t *testing.T) {
+	_, req := testtarget.Setup(t,
+		testtarget.NewTargets("bar", "foo"),
+		func(bar *build.Target) {
+			bar.AddEnv(map[string]string{"RUNS_AS": "barrunner"})
+			bar.RunShell("last op")
+		},
+		func(foo *build.Target) {
+			req := require.New(t)
+			req.NoError(foo.AddEnv(map[string]string{"RUNS_AS": "foorunner"}))
+			req.NoError(foo.Assemble(
+				"bar",
+				[]string{"/srv/app"},
+				[]string{"/etc/ssl/cert.pem"},
+				[]string{"/usr/bin/foo"},
+				[]string{"$RUNS_AS"},
+			))
+		},
+	)
+
+	_, eops := req.ContainsNExecOps(2)
+
+	// The files are collected by a script executed in bar as root
+	exec := eops[1].Exec
+	req.Equal("0", exec.Meta.User)
+	req.Equal("/bin/sh", exec.Meta.Args[0])
+	req.Contains(exec.Meta.Args[2], `copy "/srv/app"`)
+	req.Contains(exec.Meta.Args[2], `if [ -e "/etc/ssl/cert.pem" ]; then copy "/etc/ssl/cert.pem"; fi`)
+	req.Contains(exec.Meta.Args[2], `ldd "/usr/bin/foo"`)
+
+	// User names are expanded in the environment of the assembling target
+	req.Contains(exec.Meta.Args[2], `printf '%s\n' root "foorunner"`)
+	req.NotContains(exec.Meta.Args[2], "barrunner")
+
+	fops, fileOps := req.ContainsNFileOps(1)
+	inputs := req.HasValidInputs(fops[0])
+	req.Len(inputs, 2)
+
+	// The staged files are copied from the output of the script's mount
+	req.IsType((*pb.Op_Exec)(nil), inputs[1].Op)
+
+	_, copies := req.ContainsNCopyActions(fileOps[0], 1)
+	req.Equal("/", copies[0].Copy.Src)
+	req.Equal("/", copies[0].Copy.Dest)
+	req.True(copies[0].Copy.DirCopyContents)
+}
+
+func TestSquash(t *testing.T) {
+	image, req := testtarget.Setup(t,
+		testtarget.NewTargets("foo"),
+		func(foo *build.Target) {
+			foo.AddEnv(map[string]string{"FOO": "bar"})
+			foo.Compile(build.Run{"rm -rf /usr/share/doc", []string{}}, "final/slim")
+			foo.Compile(build.Squash{}, "final/slim")
+			foo.RunShell("echo $FOO")
+		},
+	)
+
+	ops, fileOps := req.ContainsNFileOps(1)
+	inputs := req.HasValidInputs(ops[0])
+	req.Len(inputs, 1)
+
+	// The squashed filesystem is copied from the result of the first run
+	req.IsType((*pb.Op_Exec)(nil), inputs[0].Op)
+
+	_, copies := req.ContainsNCopyActions(fileOps[0], 1)
+	req.Equal("/", copies[0].Copy.Src)
+	req.Equal("/", copies[0].Copy.Dest)
+
+	// Metadata of the state is retained
+	eops, execs := req.ContainsNExecOps(2)
+	req.Contains(execs[1].Exec.Meta.Env, "FOO=bar")
+
+	// The difference between the base and the squashed copy is merged onto
+	// the base image
+	inputs = req.HasValidInputs(eops[1])
+	req.Len(inputs, 1)
+	req.IsType((*pb.Op_Merge)(nil), inputs[0].Op)
+
+	mergeInputs := req.HasValidInputs(inputs[0])
+	req.Len(mergeInputs, 2)
+	req.IsType((*pb.Op_Source)(nil), mergeInputs[0].Op)
+	req.IsType((*pb.Op_Diff)(nil), mergeInputs[1].Op)
+
+	diffInputs := req.HasValidInputs(mergeInputs[1])
+	req.Len(diffInputs, 2)
+	req.Equal(mergeInputs[0], diffInputs[0])
+	req.Equal(ops[0], diffInputs[1])
+
+	// Only the squashed layer is recorded in the history
+	req.Len(image.History, 1)
+	req.Equal("[final/slim] SQUASH", image.History[0].CreatedBy)
+	req.False(image.History[0].EmptyLayer)
+}
+
+func TestSquashKeepsBaseHistory(t *testing.T) {
+	image, _ := testtarget.Setup(t,
+		testtarget.NewTargetsWithBaseImage(
+			[]string{"foo"},
+			oci.Image{
+				Config: oci.ImageConfig{User: "root"},
+				History: []oci.History{
+					{CreatedBy: "base layer"},
+					{CreatedBy: "base config", EmptyLayer: true},
+				},
+			},
+		),
+		func(foo *build.Target) {
+			require.NoError(t, foo.Compile(build.Run{"rm -rf /usr/share/doc", []string{}}, "final/slim"))
+			require.NoError(t, foo.Compile(build.Squash{}, "final/slim"))
+		},
+	)
+
+	require.Len(t, image.History, 3)
+	require.Equal(t, "base layer", image.History[0].CreatedBy)
+	require.Equal(t, "base config", image.History[1].CreatedBy)
+	require.Equal(t, "[final/slim] SQUASH", image.History[2].CreatedBy)
+}
+
+func TestSquashScratch(t *testing.T) {
+	ctx := context.Background()
+	req := require.New(t)
+
+	target := build.NewTarget("foo", "", nil, build.NewOptions())
+	req.NoError(target.Initialize(ctx))
+	req.NoError(target.Mkfile("/foo", fs.FileMode(0o644), []byte("foo")))
+	req.NoError(target.Squash())
+
+	def, _, err := target.Marshal(ctx)
+	req.NoError(err)
+
+	// Without a base, the squashed copy is the entire filesystem
+	llbreq := llbtest.New(t, def)
+	llbreq.ContainsNSourceOps(0)
+
+	_, fileOps := llbreq.ContainsNFileOps(2)
+	_, copies := llbreq.ContainsNCopyActions(fileOps[1], 1)
+	req.Equal("/", copies[0].Copy.Src)
+}
+
 func TestExpandEnv(t *testing.T) {
 	ctx := context.Background()
 	req := require.New(t)
@@ -278,6 +707,24 @@ func TestExpandEnv(t *testing.T) {
 	})
 
 	req.Equal("FOO is foo", target.ExpandEnv("FOO is $FOO"))
+
+	t.Run("with defaults", func(t *testing.T) {
+		target.AddEnv(map[string]string{
+			"EMPTY": "",
+		})
+
+		req.Equal("foo", target.ExpandEnv("${FOO:-bar}"))
+		req.Equal("bar", target.ExpandEnv("${EMPTY:-bar}"))
+		req.Equal("", target.ExpandEnv("${EMPTY-bar}"))
+		req.Equal("bar", target.ExpandEnv("${UNDEFINED-bar}"))
+		req.Equal("", target.ExpandEnv("${UNDEFINED}"))
+	})
+
+	t.Run("HasEnv", func(t *testing.T) {
+		req.True(target.HasEnv("FOO"))
+		req.True(target.HasEnv("EMPTY"))
+		req.False(target.HasEnv("UNDEFINED"))
+	})
 }
 
 func TestLogf(t *testing.T) {
@@ -1,9 +1,13 @@
 package build
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 func sortedKeys(keyValues map[string]string) []string {
@@ -43,3 +47,71 @@ func sprintf(format string, arguments []string) string {
 
 	return fmt.Sprintf(format, args...)
 }
+
+// shellCommand formats the given command string and arguments as a single
+// shell command. See [Target.RunAll].
+func shellCommand(cmd string, args []string) string {
+	// 1. Count the number of % formatting tokens (n) in the command string
+	// 2. Format the command string along with n of the leading arguments
+	// 3. Append the remaining arguments to the command string
+	numInnerArgs := strings.Count(cmd, `%`) - strings.Count(cmd, `%%`)
+	command := sprintf(cmd, args[0:numInnerArgs])
+
+	if len(args) > numInnerArgs {
+		command += " " + strings.Join(quoteAll(args[numInnerArgs:]), " ")
+	}
+
+	return command
+}
+
+// normalizeScript prepends a default `#!/bin/sh` line to the given script if
+// it does not contain its own shebang, and returns it along with its hex
+// encoded SHA256 digest.
+func normalizeScript(script []byte) ([]byte, string) {
+	if !bytes.HasPrefix(script, []byte(`#!`)) {
+		script = append([]byte("#!/bin/sh\n"), script...)
+	}
+
+	sha := sha256.New()
+	sha.Write(script)
+
+	return script, hex.EncodeToString(sha.Sum(nil))
+}
+
+// assembleScript returns a shell script that copies the given paths,
+// binaries and their shared libraries, and passwd and group entries for root
+// and the given users into the given staging directory. See
+// [Target.Assemble].
+func assembleScript(staging string, paths []string, optional []string, binaries []string, users []string) string {
+	script := []string{
+		"set -eu",
+		"dest=" + quote(staging),
+		`copy() { mkdir -p "$dest$(dirname "$1")" && cp -a "$1" "$dest$(dirname "$1")/"; }`,
+		`copy_lib() { mkdir -p "$dest$(dirname "$1")" && cp -L "$1" "$dest$1"; }`,
+	}
+
+	for _, p := range paths {
+		script = append(script, "copy "+quote(p))
+	}
+
+	for _, p := range optional {
+		script = append(script, "if [ -e "+quote(p)+" ]; then copy "+quote(p)+"; fi")
+	}
+
+	for _, binary := range binaries {
+		script = append(script,
+			"copy "+quote(binary),
+			"for lib in $(ldd "+quote(binary)+" 2>/dev/null | grep -o '/[^ ]*' || true); do copy_lib \"$lib\"; done",
+		)
+	}
+
+	script = append(script,
+		`mkdir -p "$dest/etc"`,
+		"for user in $(printf '%s\\n' root "+strings.Join(quoteAll(users), " ")+" | sort -u); do",
+		`  grep "^$user:" /etc/passwd >> "$dest/etc/passwd" || true`,
+		`  grep "^$user:" /etc/group >> "$dest/etc/group" || true`,
+		"done",
+	)
+
+	return strings.Join(script, "\n") + "\n"
+}
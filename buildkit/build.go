@@ -2,7 +2,11 @@ package buildkit
 
 import (
 	"context"
+	"io/fs"
+	"path"
+	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/containerd/containerd/platforms"
 	"github.com/moby/buildkit/client/llb"
@@ -14,6 +18,7 @@ import (
 	"github.com/moby/buildkit/solver/pb"
 	"github.com/moby/buildkit/solver/result"
 	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	digest "github.com/opencontainers/go-digest"
 	oci "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 
@@ -64,6 +69,9 @@ func Build(ctx context.Context, c client.Client) (*client.Result, error) {
 	// Ensure --no-cache client options work
 	buildOptions.NoCache = bc.IsNoCache
 
+	// Support reproducible builds via the SOURCE_DATE_EPOCH build argument
+	buildOptions.SourceDateEpoch = bc.Config.Epoch
+
 	if len(bc.Config.BuildPlatforms) > 0 {
 		buildOptions.BuildPlatform = bc.Config.BuildPlatforms[0]
 	}
@@ -72,11 +80,51 @@ func Build(ctx context.Context, c client.Client) (*client.Result, error) {
 		buildOptions.Variant = bc.Config.Target
 	}
 
-	buildOptions.BuildContext = func(ctx context.Context) (*llb.State, error) {
-		return bc.MainContext(ctx)
+	ignored, err := readIgnorePatterns(ctx, c, bc)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", build.IgnoreFilename)
+	}
+
+	buildOptions.BuildContext = func(ctx context.Context, opts ...llb.LocalOption) (*llb.State, error) {
+		opts = append([]llb.LocalOption{build.ExcludePatterns(ignored)}, opts...)
+		return bc.MainContext(ctx, opts...)
 	}
 
-	cfg, err := readBlubberConfig(ctx, bc)
+	buildOptions.NamedContext = func(ctx context.Context, name string, platform oci.Platform) (*llb.State, *oci.Image, error) {
+		nc, err := bc.NamedContext(name, dockerui.ContextOpt{
+			Platform:    &platform,
+			ResolveMode: resolveModeName(bc.ImageResolveMode),
+		})
+		if err != nil || nc == nil {
+			return nil, nil, err
+		}
+
+		st, img, err := nc.Load(ctx)
+		if err != nil || img == nil {
+			return st, nil, err
+		}
+
+		return st, &img.Image, nil
+	}
+
+	// Files are read from the build context as filtered by the ignore file
+	// and the given options, e.g. include and exclude patterns of the variant
+	buildOptions.ReadContextFile = func(ctx context.Context, filename string, opts ...llb.LocalOption) ([]byte, error) {
+		src, err := buildOptions.BuildContext(ctx, append(opts, llb.FollowPaths([]string{filename}))...)
+		if err != nil {
+			return nil, err
+		}
+
+		ref, err := solveRef(ctx, c, *src)
+		if err != nil {
+			return nil, err
+		}
+
+		return ref.ReadFile(ctx, client.ReadRequest{Filename: filename})
+	}
+
+	cfg, cfgSrc, err := readBlubberConfig(ctx, c, bc)
 
 	if err != nil {
 		if config.IsValidationError(err) {
@@ -85,6 +133,48 @@ func Build(ctx context.Context, c client.Client) (*client.Result, error) {
 		return nil, errors.Wrap(err, "failed to read blubber config")
 	}
 
+	buildOptions.Lock, err = readLock(ctx, c, cfgSrc.Filename)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read lock file")
+	}
+
+	if buildOptions.Locked {
+		if buildOptions.Lock == nil {
+			return nil, errors.Errorf("%s is required but could not be found", build.LockFilename)
+		}
+
+		err = CheckLock(ctx, buildOptions, cfgSrc.Filename, importReader(c, bc))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	provenance := &Provenance{
+		ConfigFilename: cfgSrc.Filename,
+		ConfigDigest:   digest.FromBytes(cfgSrc.Data),
+		Variant:        buildOptions.Variant,
+		BuildArgs:      buildOptions.BuildArgs,
+		Reproducible:   buildOptions.Reproducible(),
+	}
+
+	if buildOptions.PolicyURI != "" {
+		policy, err := config.ReadPolicyFromURI(buildOptions.PolicyURI)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load policy from %s", buildOptions.PolicyURI)
+		}
+
+		err = policy.Validate(*cfg)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "config fails policy check against %s", buildOptions.PolicyURI)
+		}
+
+		provenance.Policy = &PolicyResult{URI: buildOptions.PolicyURI}
+	}
+
 	err = config.ExpandIncludesAndCopies(cfg, buildOptions.Variant)
 
 	if err != nil {
@@ -171,14 +261,9 @@ func Build(ctx context.Context, c client.Client) (*client.Result, error) {
 
 	if scanner != nil {
 		err = rb.EachPlatform(ctx, func(ctx context.Context, id string, _ oci.Platform) error {
-			v, ok := scanTargets.Load(id)
-			if !ok {
-				return errors.Errorf("no scan targets for %s", id)
-			}
-
-			target, ok := v.(*build.Target)
-			if !ok {
-				return errors.Errorf("invalid scan targets for %T", v)
+			target, err := loadScanTarget(&scanTargets, id)
+			if err != nil {
+				return err
 			}
 
 			att, err := target.Scan(func(core llb.State, dependencies map[string]llb.State) (result.Attestation[*llb.State], error) {
@@ -189,24 +274,28 @@ func Build(ctx context.Context, c client.Client) (*client.Result, error) {
 				return err
 			}
 
-			attSolve, err := result.ConvertAttestation(&att, func(st *llb.State) (client.Reference, error) {
-				def, err := st.Marshal(ctx)
-				if err != nil {
-					return nil, err
-				}
-				r, err := c.Solve(ctx, frontend.SolveRequest{
-					Definition: def.ToPB(),
-				})
-				if err != nil {
-					return nil, err
-				}
-				return r.Ref, nil
-			})
+			return addAttestation(ctx, c, rb, id, att)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if buildOptions.Provenance {
+		err = rb.EachPlatform(ctx, func(ctx context.Context, id string, _ oci.Platform) error {
+			target, err := loadScanTarget(&scanTargets, id)
 			if err != nil {
 				return err
 			}
-			rb.AddAttestation(id, *attSolve)
-			return nil
+
+			att, err := provenance.Attestation(target)
+
+			if err != nil {
+				return errors.Wrap(err, "failed to create provenance attestation")
+			}
+
+			return addAttestation(ctx, c, rb, id, att)
 		})
 
 		if err != nil {
@@ -217,22 +306,200 @@ func Build(ctx context.Context, c client.Client) (*client.Result, error) {
 	return rb.Finalize()
 }
 
-func readBlubberConfig(ctx context.Context, bc *dockerui.Client) (*config.Config, error) {
+func readBlubberConfig(ctx context.Context, c client.Client, bc *dockerui.Client) (*config.Config, *dockerui.Source, error) {
 	cfgSrc, err := bc.ReadEntrypoint(ctx, configLang)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	cfg, err := config.ReadYAMLConfig(cfgSrc.Data)
+	cfg, err := config.ReadYAMLConfigWithImports(ctx, cfgSrc.Data, cfgSrc.Filename, importReader(c, bc))
 	if err != nil {
 		if config.IsValidationError(err) {
-			return nil, errors.Wrapf(err, "config is invalid:\n%v", config.HumanizeValidationError(err))
+			return nil, nil, errors.Wrapf(err, "config is invalid:\n%v", config.HumanizeValidationError(err))
+		}
+
+		return nil, nil, errors.Wrap(err, "error reading config")
+	}
+
+	return cfg, cfgSrc, nil
+}
+
+// readLock reads the lock file that lives alongside the config from the
+// client's config context. A nil [build.Lock] is returned if none exists.
+func readLock(ctx context.Context, c client.Client, cfgFilename string) (*build.Lock, error) {
+	filename := path.Join(path.Dir(cfgFilename), build.LockFilename)
+
+	src := llb.Local(
+		dockerui.DefaultLocalNameDockerfile,
+		llb.FollowPaths([]string{filename}),
+		llb.SessionID(c.BuildOpts().SessionID),
+		llb.SharedKeyHint(dockerui.DefaultLocalNameDockerfile),
+		dockerui.WithInternalName("load lock file from "+filename),
+		llb.Differ(llb.DiffNone, false),
+	)
+
+	ref, err := solveRef(ctx, c, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ref.StatFile(ctx, client.StatRequest{Path: filename}); err != nil {
+		if isNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrapf(err, "failed to stat %s", filename)
+	}
+
+	data, err := ref.ReadFile(ctx, client.ReadRequest{Filename: filename})
+	if err != nil {
+		return nil, err
+	}
+
+	return build.ReadLock(data)
+}
+
+// readIgnorePatterns reads the patterns of the Blubber ignore file at the root
+// of the main build context, if it exists.
+func readIgnorePatterns(ctx context.Context, c client.Client, bc *dockerui.Client) ([]string, error) {
+	src, err := bc.MainContext(ctx,
+		llb.FollowPaths([]string{build.IgnoreFilename}),
+		llb.SharedKeyHint(build.IgnoreFilename),
+		dockerui.WithInternalName("load "+build.IgnoreFilename),
+		llb.Differ(llb.DiffNone, false),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := solveRef(ctx, c, *src)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ref.StatFile(ctx, client.StatRequest{Path: build.IgnoreFilename}); err != nil {
+		if isNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrapf(err, "failed to stat %s", build.IgnoreFilename)
+	}
+
+	data, err := ref.ReadFile(ctx, client.ReadRequest{Filename: build.IgnoreFilename})
+	if err != nil {
+		return nil, err
+	}
+
+	return build.ReadIgnorePatterns(data)
+}
+
+// importReader returns a [config.ImportReader] that reads imported config
+// files from the client's config context, or from the named build contexts
+// given by the client (e.g. with `--build-context`).
+func importReader(c client.Client, bc *dockerui.Client) config.ImportReader {
+	return func(ctx context.Context, buildContext string, filename string) ([]byte, error) {
+		src := llb.Local(
+			dockerui.DefaultLocalNameDockerfile,
+			llb.FollowPaths([]string{filename}),
+			llb.SessionID(c.BuildOpts().SessionID),
+			llb.SharedKeyHint(dockerui.DefaultLocalNameDockerfile),
+			dockerui.WithInternalName("load imported config from "+filename),
+			llb.Differ(llb.DiffNone, false),
+		)
+
+		if buildContext != "" {
+			nc, err := bc.NamedContext(buildContext, dockerui.ContextOpt{})
+			if err != nil {
+				return nil, err
+			}
+
+			if nc == nil {
+				return nil, errors.Errorf("build context %q is not defined", buildContext)
+			}
+
+			st, _, err := nc.Load(ctx)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to load build context %q", buildContext)
+			}
+
+			src = *st
 		}
 
-		return nil, errors.Wrap(err, "error reading config")
+		ref, err := solveRef(ctx, c, src)
+		if err != nil {
+			return nil, err
+		}
+
+		return ref.ReadFile(ctx, client.ReadRequest{Filename: filename})
 	}
+}
 
-	return cfg, nil
+// isNotExist returns whether the given error returned by a gateway client
+// reference indicates that a file does not exist. Errors lose their type when
+// passed over gRPC, so the message of the underlying syscall error is also
+// checked.
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || strings.Contains(err.Error(), syscall.ENOENT.Error())
+}
+
+// solveRef solves the given state and returns a reference to its result.
+func solveRef(ctx context.Context, c client.Client, src llb.State) (client.Reference, error) {
+	def, err := src.Marshal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.Solve(ctx, client.SolveRequest{Definition: def.ToPB()})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.SingleRef()
+}
+
+// loadScanTarget returns the target that was stored for the given platform
+// ID during the build.
+func loadScanTarget(scanTargets *sync.Map, id string) (*build.Target, error) {
+	v, ok := scanTargets.Load(id)
+	if !ok {
+		return nil, errors.Errorf("no scan targets for %s", id)
+	}
+
+	target, ok := v.(*build.Target)
+	if !ok {
+		return nil, errors.Errorf("invalid scan targets for %T", v)
+	}
+
+	return target, nil
+}
+
+// addAttestation solves the given attestation and adds it to the result for
+// the given platform ID.
+func addAttestation(
+	ctx context.Context,
+	c client.Client,
+	rb *dockerui.ResultBuilder,
+	id string,
+	att result.Attestation[*llb.State],
+) error {
+	attSolve, err := result.ConvertAttestation(&att, func(st *llb.State) (client.Reference, error) {
+		def, err := st.Marshal(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r, err := c.Solve(ctx, frontend.SolveRequest{
+			Definition: def.ToPB(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return r.Ref, nil
+	})
+	if err != nil {
+		return err
+	}
+	rb.AddAttestation(id, *attSolve)
+	return nil
 }
 
 func resolveModeName(mode llb.ResolveMode) string {
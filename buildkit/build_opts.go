@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"strconv"
 
+	"github.com/moby/buildkit/frontend/attestations"
 	"github.com/moby/buildkit/frontend/gateway/client"
 	"github.com/pkg/errors"
 
@@ -15,6 +16,8 @@ const (
 	keyEntrypointArgs = "entrypoint-args"
 	keyRunEntrypoint  = "run-variant"
 	keyRunEnvironment = "run-variant-env"
+	keyPolicy         = "policy"
+	keyLocked         = "locked"
 )
 
 // BuildOptions contains options specific to the BuildKit frontend as well as
@@ -30,6 +33,13 @@ type BuildOptions struct {
 	// Additional arguments to be added to the entrypoint command
 	EntrypointArgs []string
 
+	// URI of a policy file against which to validate the config
+	PolicyURI string
+
+	// Whether the client requested provenance attestations (i.e. via the
+	// attest:provenance option)
+	Provenance bool
+
 	*build.Options
 }
 
@@ -62,8 +72,23 @@ func ParseBuildOptions(clientBuildOpts client.BuildOpts) (*BuildOptions, error)
 				return nil, errors.Wrapf(err, "Failed to parse %s: %q", keyRunEnvironment, v)
 			}
 			bo.RunEnvironment = env
+		case keyPolicy:
+			bo.PolicyURI = v
+		case keyLocked:
+			locked, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to parse %s option", keyLocked)
+			}
+			bo.Locked = locked
 		}
 	}
 
+	attests, err := attestations.Parse(clientBuildOpts.Opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse attestation options")
+	}
+
+	_, bo.Provenance = attests[attestations.KeyTypeProvenance]
+
 	return &bo, nil
 }
@@ -48,3 +48,25 @@ func TestBadRunVariantEnv(t *testing.T) {
 
 	require.Error(t, err)
 }
+
+func TestProvenanceRequested(t *testing.T) {
+	t.Run("requested", func(t *testing.T) {
+		buildOpts, err := buildkit.ParseBuildOptions(
+			client.BuildOpts{
+				Opts: map[string]string{
+					"attest:provenance": "mode=max",
+				},
+			},
+		)
+
+		require.NoError(t, err)
+		require.True(t, buildOpts.Provenance)
+	})
+
+	t.Run("not requested", func(t *testing.T) {
+		buildOpts, err := buildkit.ParseBuildOptions(client.BuildOpts{})
+
+		require.NoError(t, err)
+		require.False(t, buildOpts.Provenance)
+	})
+}
@@ -31,6 +31,7 @@ func Compile(
 
 	targets := build.TargetGroup{}
 	vcfgs := make(map[string]*config.VariantConfig, len(variants))
+	deferred := make(map[string][]config.VariableReference, len(variants))
 
 	var finalTarget *build.Target
 
@@ -41,9 +42,44 @@ func Compile(
 			return nil, errors.Wrapf(err, "failed to get variant %s", variant)
 		}
 
-		vcfgs[variant] = vcfg
-
 		finalTarget = targets.NewTarget(variant, vcfg.Base, platform, bo.Options)
+		vcfg.MergePlatform(finalTarget.Platform())
+
+		variables := config.InterpolationVariables(
+			vcfg.Arguments,
+			bo.Options.BuildArgs,
+			finalTarget.BuildEnv(),
+		)
+
+		// References to variables other than arguments, build arguments and
+		// platform variables are expanded using the build environment
+		deferred[variant], err = vcfg.InterpolateDeferred(variables)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to interpolate variant %s", variant)
+		}
+
+		err = config.ValidateVariant(*cfg, *vcfg)
+
+		if err != nil {
+			if config.IsValidationError(err) {
+				err = errors.New(config.HumanizeValidationError(err))
+			}
+
+			return nil, errors.Wrapf(err, "invalid variant %s after interpolation", variant)
+		}
+
+		// Templates are read from the build context as filtered for the variant
+		finalTarget.FilterContext(vcfg.Context.Include, vcfg.Context.Exclude)
+		vcfg.Templates, err = vcfg.Templates.Render(ctx, finalTarget.ReadContextFile, variables)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to render templates of variant %s", variant)
+		}
+
+		// The base image reference may differ by platform or be interpolated
+		finalTarget.Base = vcfg.Base
+		vcfgs[variant] = vcfg
 	}
 
 	err = targets.InitializeAll(ctx)
@@ -52,20 +88,56 @@ func Compile(
 		return nil, errors.Wrap(err, "failed to fetch base images for some targets")
 	}
 
+	// External references used by copies and mounts may name build contexts
+	// given by the client. Otherwise they are images that are only resolved up
+	// front when locking, so they can be pinned to their locked digests.
+	for _, target := range targets {
+		for _, image := range config.GetExternalImages(cfg, vcfgs[target.Name]) {
+			ok, err := target.ResolveNamedContext(ctx, image)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if ok || bo.Lock == nil {
+				continue
+			}
+
+			err = target.LockImage(ctx, image)
+
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to lock image %s", image)
+			}
+		}
+	}
+
 	for _, target := range targets {
 		for _, phase := range build.Phases() {
-			for _, instruction := range vcfgs[target.Name].InstructionsForPhase(phase) {
-				err := instruction.Compile(target)
-
-				if err != nil {
-					// TODO if the build.Instruction interface were expanded to include a
-					// method that returned the config from which it originated (and even
-					// file/line from the source config), we could provide the user with a
-					// nicer error message here
-					return nil, errors.Wrap(err, "failed to compile instruction")
+			for _, section := range vcfgs[target.Name].SectionsForPhase(phase) {
+				origin := phase.String() + "/" + section.Name
+
+				for _, instruction := range section.Instructions {
+					err := target.Compile(instruction, origin)
+
+					if err != nil {
+						return nil, errors.Wrapf(
+							err,
+							"failed to compile instruction for variant %q (%s)",
+							target.Name, origin,
+						)
+					}
 				}
 			}
 		}
+
+		for _, ref := range deferred[target.Name] {
+			if !target.HasEnv(ref.Name) {
+				return nil, errors.Errorf(
+					"failed to interpolate variant %s: %s: variable %q is not defined",
+					target.Name, ref.Path, ref.Name,
+				)
+			}
+		}
 	}
 
 	if bo != nil && bo.RunEntrypoint {
@@ -0,0 +1,182 @@
+package buildkit_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/buildkit"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+	"gitlab.wikimedia.org/repos/releng/blubber/util/testmetaresolver"
+)
+
+func compileVariant(t *testing.T, cfgData []byte, variant string) (*build.Target, error) {
+	t.Helper()
+
+	cfg, err := config.ReadYAMLConfig(cfgData)
+	require.NoError(t, err)
+	require.NoError(t, config.ExpandIncludesAndCopies(cfg, variant))
+
+	bo := &buildkit.BuildOptions{Options: build.NewOptions()}
+	bo.Variant = variant
+	bo.MetaResolver = testmetaresolver.New("foo", oci.Image{
+		Config: oci.ImageConfig{
+			Env: []string{"PATH=/usr/local/bin:/usr/bin:/bin"},
+		},
+	})
+
+	return buildkit.Compile(context.Background(), bo, cfg, &oci.Platform{OS: "linux", Architecture: "amd64"})
+}
+
+func TestCompileDefersEnvironmentReferences(t *testing.T) {
+	t.Run("pipeline config", func(t *testing.T) {
+		req := require.New(t)
+
+		cfgData, err := os.ReadFile("../.pipeline/blubber.yaml")
+		req.NoError(err)
+
+		target, err := compileVariant(t, cfgData, "make")
+		req.NoError(err)
+
+		req.Equal("/opt/lib/go/bin:/usr/local/bin:/usr/bin:/bin", target.ExpandEnv("$PATH"))
+
+		def, _, err := target.Marshal(context.Background())
+		req.NoError(err)
+
+		destinations := []string{}
+
+		for _, dt := range def.Def {
+			var op pb.Op
+			req.NoError(op.UnmarshalVT(dt))
+
+			if exec := op.GetExec(); exec != nil {
+				for _, mount := range exec.Mounts {
+					if mount.MountType == pb.MountType_CACHE {
+						destinations = append(destinations, mount.Dest)
+					}
+				}
+			}
+		}
+
+		req.Contains(destinations, "/opt/lib/go/cache")
+		req.Contains(destinations, "/opt/lib/go/pkg")
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		req := require.New(t)
+
+		target, err := compileVariant(t, []byte(`---
+    version: v4
+    base: foo
+    variants:
+      test:
+        runs:
+          environment:
+            FOO: "${UNDEFINED:-foo}"
+            BAR: "${PATH:-bar}"`), "test")
+
+		req.NoError(err)
+		req.Equal("foo", target.ExpandEnv("$FOO"))
+		req.Equal("/usr/local/bin:/usr/bin:/bin", target.ExpandEnv("$BAR"))
+	})
+
+	t.Run("undefined", func(t *testing.T) {
+		req := require.New(t)
+
+		_, err := compileVariant(t, []byte(`---
+    version: v4
+    base: foo
+    variants:
+      test:
+        builder:
+          command: [make]
+          caches: ["${UNDEFINED}/cache"]`), "test")
+
+		req.Error(err)
+		req.Equal(
+			`failed to interpolate variant test: builder.caches[0].destination: variable "UNDEFINED" is not defined`,
+			err.Error(),
+		)
+	})
+
+	t.Run("base image default", func(t *testing.T) {
+		req := require.New(t)
+
+		target, err := compileVariant(t, []byte(`---
+    version: v4
+    base: docker-registry.wikimedia.org/bookworm:${BASE_TAG:-latest}
+    variants:
+      test: {}`), "test")
+
+		req.NoError(err)
+		req.Contains(target.Base, "docker-registry.wikimedia.org/bookworm:latest@sha256:")
+	})
+
+	t.Run("runtime references", func(t *testing.T) {
+		req := require.New(t)
+
+		target, err := compileVariant(t, []byte(`---
+    version: v4
+    base: foo
+    variants:
+      test:
+        entrypoint: [sh, -c, "exec app --port ${PORT}"]
+        runs:
+          environment:
+            URL: "http://localhost:${PORT}"`), "test")
+
+		req.NoError(err)
+
+		_, image, err := target.Marshal(context.Background())
+		req.NoError(err)
+
+		req.Equal([]string{"sh", "-c", "exec app --port ${PORT}"}, image.Config.Entrypoint)
+		req.Equal("http://localhost:", target.ExpandEnv("$URL"))
+	})
+}
+
+func TestCompileReadsTemplatesFromFilteredContext(t *testing.T) {
+	req := require.New(t)
+
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    variants:
+      test:
+        context:
+          exclude: [secrets]
+        templates:
+          - source: secrets/foo.tmpl
+            destination: /srv/foo`))
+	req.NoError(err)
+	req.NoError(config.ExpandIncludesAndCopies(cfg, "test"))
+
+	bo := &buildkit.BuildOptions{Options: build.NewOptions()}
+	bo.Variant = "test"
+	bo.MetaResolver = testmetaresolver.New("foo", oci.Image{})
+
+	bo.ReadContextFile = func(_ context.Context, path string, opts ...llb.LocalOption) ([]byte, error) {
+		included, err := build.ContextIncludes(path, opts...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !included {
+			return nil, errors.Errorf("%s is excluded", path)
+		}
+
+		return []byte("foo"), nil
+	}
+
+	_, err = buildkit.Compile(context.Background(), bo, cfg, &oci.Platform{OS: "linux", Architecture: "amd64"})
+
+	req.EqualError(err, "failed to render templates of variant test: failed to read template secrets/foo.tmpl: secrets/foo.tmpl is excluded")
+}
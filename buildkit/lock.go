@@ -0,0 +1,134 @@
+package buildkit
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+// Lock compiles each of the given variants of the config at the given path
+// for each of the given platforms, recording the digests of all resolved base
+// images and external images in the [build.Lock] of the given build options.
+// If no variants are given, all variants are locked. The config and any
+// imported config files are read using the given reader.
+func Lock(
+	ctx context.Context,
+	bo *BuildOptions,
+	cfgPath string,
+	readFile config.ImportReader,
+	variants []string,
+	targetPlatforms []oci.Platform,
+) error {
+	if bo.Lock == nil {
+		return errors.New("no lock was given")
+	}
+
+	if len(variants) == 0 {
+		cfg, err := readConfig(ctx, cfgPath, readFile)
+
+		if err != nil {
+			return errors.Wrap(err, "failed to read config")
+		}
+
+		variants = slices.Sorted(maps.Keys(cfg.Variants))
+	}
+
+	for _, variant := range variants {
+		// Expansion of includes and copies alters the config, so each variant
+		// is compiled from a freshly read config
+		cfg, err := readConfig(ctx, cfgPath, readFile)
+
+		if err != nil {
+			return errors.Wrap(err, "failed to read config")
+		}
+
+		if _, ok := cfg.Variants[variant]; !ok {
+			return errors.Errorf("unknown variant %q", variant)
+		}
+
+		err = config.ExpandIncludesAndCopies(cfg, variant)
+
+		if err != nil {
+			return errors.Wrapf(err, "failed to expand variant %s", variant)
+		}
+
+		for _, platform := range targetPlatforms {
+			options := *bo.Options
+			options.Variant = variant
+			options.TargetPlatforms = []oci.Platform{platform}
+
+			variantOptions := *bo
+			variantOptions.Options = &options
+
+			_, err := Compile(ctx, &variantOptions, cfg, &platform)
+
+			if err != nil {
+				return errors.Wrapf(err, "failed to lock variant %s", variant)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckLock checks that the [build.Lock] of the given build options is not
+// stale by compiling all variants of the config at the given path for each
+// platform recorded in the lock, and failing if the lock records digests for
+// images or platforms that the config no longer uses. The config and any
+// imported config files are read using the given reader.
+func CheckLock(ctx context.Context, bo *BuildOptions, cfgPath string, readFile config.ImportReader) error {
+	if bo.Lock == nil {
+		return errors.New("no lock was given")
+	}
+
+	lockPlatforms, err := bo.Lock.Platforms()
+
+	if err != nil {
+		return err
+	}
+
+	// Variants that were not locked are resolved as usual, so the check is
+	// done against a copy of the lock
+	options := *bo.Options
+	options.Lock = bo.Lock.Copy()
+	options.Locked = false
+
+	checkOptions := *bo
+	checkOptions.Options = &options
+
+	err = Lock(ctx, &checkOptions, cfgPath, readFile, nil, lockPlatforms)
+
+	if err != nil {
+		return errors.Wrapf(err, "failed to check %s", build.LockFilename)
+	}
+
+	unused := options.Lock.Unused()
+
+	if len(unused) > 0 {
+		return errors.Errorf(
+			"%s is stale, it records digests for images not used by the config: %s; run `blubber lock` to update it",
+			build.LockFilename, strings.Join(unused, ", "),
+		)
+	}
+
+	return nil
+}
+
+// readConfig reads and parses the config at the given path, resolving its
+// imports using the given reader.
+func readConfig(ctx context.Context, cfgPath string, readFile config.ImportReader) (*config.Config, error) {
+	cfgData, err := readFile(ctx, "", cfgPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return config.ReadYAMLConfigWithImports(ctx, cfgData, cfgPath, readFile)
+}
@@ -0,0 +1,227 @@
+package buildkit_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/buildkit"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+	"gitlab.wikimedia.org/repos/releng/blubber/util/testmetaresolver"
+)
+
+// testImportReader reads files from the given map keyed by "context:path",
+// or just the path for the main context.
+func testImportReader(files map[string]string) config.ImportReader {
+	return func(_ context.Context, buildContext string, path string) ([]byte, error) {
+		key := path
+
+		if buildContext != "" {
+			key = buildContext + ":" + path
+		}
+
+		if data, ok := files[key]; ok {
+			return []byte(data), nil
+		}
+
+		return nil, errors.Errorf("no such file %s", key)
+	}
+}
+
+func TestLock(t *testing.T) {
+	req := require.New(t)
+
+	amd64 := oci.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := oci.Platform{OS: "linux", Architecture: "arm64"}
+
+	bo := &buildkit.BuildOptions{Options: build.NewOptions()}
+	bo.MetaResolver = testmetaresolver.New("foo", oci.Image{})
+	bo.Lock = build.NewLock()
+
+	err := buildkit.Lock(
+		context.Background(),
+		bo,
+		"blubber.yaml",
+		testImportReader(map[string]string{
+			"blubber.yaml": `---
+    version: v4
+    variants:
+      build:
+        base: docker-registry.wikimedia.org/foo:1.0
+      production:
+        base: docker-registry.wikimedia.org/bar:2.0
+        copies:
+          - from: build
+          - from: docker-registry.wikimedia.org/baz:3.0
+            source: /baz`,
+		}),
+		nil,
+		[]oci.Platform{amd64, arm64},
+	)
+
+	req.NoError(err)
+
+	for _, ref := range []string{
+		"docker-registry.wikimedia.org/foo:1.0",
+		"docker-registry.wikimedia.org/bar:2.0",
+		"docker-registry.wikimedia.org/baz:3.0",
+	} {
+		for _, platform := range []oci.Platform{amd64, arm64} {
+			_, ok := bo.Lock.Get(ref, platform)
+			req.Truef(ok, "%s is not locked for %s/%s", ref, platform.OS, platform.Architecture)
+		}
+	}
+
+	req.Len(bo.Lock.Images, 3)
+}
+
+func TestLockWithImports(t *testing.T) {
+	req := require.New(t)
+
+	amd64 := oci.Platform{OS: "linux", Architecture: "amd64"}
+
+	bo := &buildkit.BuildOptions{Options: build.NewOptions()}
+	bo.MetaResolver = testmetaresolver.New("foo", oci.Image{})
+	bo.Lock = build.NewLock()
+
+	err := buildkit.Lock(
+		context.Background(),
+		bo,
+		".pipeline/blubber.yaml",
+		testImportReader(map[string]string{
+			".pipeline/blubber.yaml": `---
+    version: v4
+    imports:
+      - path: shared.yaml
+        as: shared
+    variants:
+      production:
+        includes: [shared/base]`,
+
+			".pipeline/shared.yaml": `---
+    version: v4
+    variants:
+      base:
+        base: docker-registry.wikimedia.org/foo:1.0`,
+		}),
+		[]string{"production"},
+		[]oci.Platform{amd64},
+	)
+
+	req.NoError(err)
+
+	_, ok := bo.Lock.Get("docker-registry.wikimedia.org/foo:1.0", amd64)
+	req.True(ok)
+	req.Len(bo.Lock.Images, 1)
+}
+
+func TestLockWithTemplates(t *testing.T) {
+	req := require.New(t)
+
+	amd64 := oci.Platform{OS: "linux", Architecture: "amd64"}
+
+	bo := &buildkit.BuildOptions{Options: build.NewOptions()}
+	bo.MetaResolver = testmetaresolver.New("foo", oci.Image{})
+	bo.Lock = build.NewLock()
+
+	// Templates are rendered when compiling, so the context reader must be
+	// passed on to each variant
+	bo.ReadContextFile = func(_ context.Context, path string, _ ...llb.LocalOption) ([]byte, error) {
+		return []byte("foo"), nil
+	}
+
+	err := buildkit.Lock(
+		context.Background(),
+		bo,
+		"blubber.yaml",
+		testImportReader(map[string]string{
+			"blubber.yaml": `---
+    version: v4
+    variants:
+      production:
+        base: docker-registry.wikimedia.org/foo:1.0
+        templates:
+          - source: foo.tmpl
+            destination: /srv/foo`,
+		}),
+		nil,
+		[]oci.Platform{amd64},
+	)
+
+	req.NoError(err)
+	req.Len(bo.Lock.Images, 1)
+}
+
+func TestCheckLock(t *testing.T) {
+	amd64 := oci.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := oci.Platform{OS: "linux", Architecture: "arm64"}
+
+	readFile := testImportReader(map[string]string{
+		"blubber.yaml": `---
+    version: v4
+    variants:
+      build:
+        base: docker-registry.wikimedia.org/foo:1.0
+      production:
+        base: docker-registry.wikimedia.org/bar:2.0
+        copies:
+          - from: build
+          - from: docker-registry.wikimedia.org/baz:3.0
+            source: /baz`,
+	})
+
+	lock := func(t *testing.T, variants []string, targetPlatforms ...oci.Platform) *buildkit.BuildOptions {
+		bo := &buildkit.BuildOptions{Options: build.NewOptions()}
+		bo.MetaResolver = testmetaresolver.New("foo", oci.Image{})
+		bo.Lock = build.NewLock()
+
+		err := buildkit.Lock(context.Background(), bo, "blubber.yaml", readFile, variants, targetPlatforms)
+		require.NoError(t, err)
+
+		bo.Locked = true
+
+		return bo
+	}
+
+	t.Run("up to date", func(t *testing.T) {
+		bo := lock(t, nil, amd64, arm64)
+
+		require.NoError(t, buildkit.CheckLock(context.Background(), bo, "blubber.yaml", readFile))
+		require.Len(t, bo.Lock.Images, 3)
+	})
+
+	t.Run("some variants locked", func(t *testing.T) {
+		bo := lock(t, []string{"build"}, amd64)
+
+		require.NoError(t, buildkit.CheckLock(context.Background(), bo, "blubber.yaml", readFile))
+		require.Len(t, bo.Lock.Images, 1)
+	})
+
+	t.Run("extra image", func(t *testing.T) {
+		bo := lock(t, nil, amd64)
+		bo.Lock.Set("docker-registry.wikimedia.org/qux:4.0", amd64, "sha256:abc")
+
+		err := buildkit.CheckLock(context.Background(), bo, "blubber.yaml", readFile)
+
+		require.EqualError(
+			t, err,
+			"blubber.lock is stale, it records digests for images not used by the config: "+
+				"docker-registry.wikimedia.org/qux:4.0 (linux/amd64); run `blubber lock` to update it",
+		)
+	})
+
+	t.Run("mismatched platform", func(t *testing.T) {
+		bo := lock(t, nil, amd64)
+		bo.Lock.Set("docker-registry.wikimedia.org/qux:4.0", arm64, "sha256:abc")
+
+		err := buildkit.CheckLock(context.Background(), bo, "blubber.yaml", readFile)
+
+		require.ErrorContains(t, err, "docker-registry.wikimedia.org/qux:4.0 (linux/arm64)")
+		require.NotContains(t, err.Error(), "docker-registry.wikimedia.org/foo:1.0")
+	})
+}
@@ -0,0 +1,305 @@
+package buildkit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+// ImageUpdate describes whether the base image of a variant is outdated,
+// i.e. whether its tag now points to a different digest than the one that
+// was pinned in the config or recorded in the lock.
+type ImageUpdate struct {
+	Variant  string
+	Image    string
+	Platform oci.Platform
+
+	// Digest pinned in the config or recorded in the lock. Empty if the
+	// image is neither pinned nor locked.
+	Current digest.Digest
+
+	// Digest to which the image tag currently points
+	Latest digest.Digest
+
+	// Whether the current digest is pinned in the config itself as opposed
+	// to recorded in the lock
+	Pinned bool
+
+	// Whether the image is pinned to a digest without a tag, in which case
+	// there is no latest digest to compare against
+	Untagged bool
+}
+
+// Outdated returns whether the image tag points to a newer digest.
+func (update ImageUpdate) Outdated() bool {
+	return !update.Untagged && update.Current != "" && update.Current != update.Latest
+}
+
+// Outdated resolves the base image of each of the given variants (or all
+// variants) of the config at the given path for each of the given platforms,
+// and reports whether the digests pinned in the config or recorded in the
+// [build.Lock] of the given build options are outdated. The config and any
+// imported config files are read using the given reader.
+func Outdated(
+	ctx context.Context,
+	bo *BuildOptions,
+	cfgPath string,
+	readFile config.ImportReader,
+	variants []string,
+	targetPlatforms []oci.Platform,
+) ([]ImageUpdate, error) {
+	if len(variants) == 0 {
+		cfg, err := readConfig(ctx, cfgPath, readFile)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read config")
+		}
+
+		variants = slices.Sorted(maps.Keys(cfg.Variants))
+	}
+
+	updates := []ImageUpdate{}
+	latest := map[string]digest.Digest{}
+
+	for _, variant := range variants {
+		// Expansion of includes and copies alters the config, so each variant
+		// is expanded from a freshly read config
+		cfg, err := readConfig(ctx, cfgPath, readFile)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read config")
+		}
+
+		if _, ok := cfg.Variants[variant]; !ok {
+			return nil, errors.Errorf("unknown variant %q", variant)
+		}
+
+		err = config.ExpandIncludesAndCopies(cfg, variant)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to expand variant %s", variant)
+		}
+
+		for _, platform := range targetPlatforms {
+			// The base image reference may differ by platform or be
+			// interpolated differently for each platform, so each platform
+			// gets its own copy of the variant
+			vcfg, err := config.GetVariant(cfg, variant)
+
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get variant %s", variant)
+			}
+
+			target := build.NewTarget(variant, vcfg.Base, &platform, bo.Options)
+			vcfg.MergePlatform(target.Platform())
+
+			_, err = vcfg.InterpolateDeferred(config.InterpolationVariables(
+				vcfg.Arguments,
+				bo.Options.BuildArgs,
+				target.BuildEnv(),
+			))
+
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to interpolate variant %s", variant)
+			}
+
+			if vcfg.IsScratch() {
+				continue
+			}
+
+			update := ImageUpdate{
+				Variant:  variant,
+				Image:    vcfg.Base,
+				Platform: platform,
+			}
+
+			if pinned, ok := build.PinnedDigest(vcfg.Base); ok {
+				update.Current = pinned
+				update.Pinned = true
+			} else if bo.Lock != nil {
+				update.Current, _ = bo.Lock.Get(vcfg.Base, platform)
+			}
+
+			if build.Untagged(vcfg.Base) {
+				update.Untagged = true
+				updates = append(updates, update)
+				continue
+			}
+
+			key := vcfg.Base + " " + platforms.Format(platform)
+
+			if _, ok := latest[key]; !ok {
+				latest[key], err = build.ResolveLatest(ctx, bo.MetaResolver, vcfg.Base, platform)
+
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			update.Latest = latest[key]
+			updates = append(updates, update)
+		}
+	}
+
+	return updates, nil
+}
+
+// UpdatePinnedDigests rewrites the digests pinned in the given YAML config
+// for all outdated images to their latest digests. Only the base image field
+// from which each image reference was taken is rewritten, and formatting of
+// the config, including comments, is otherwise preserved. Pinned digests that
+// cannot be rewritten, i.e. those of interpolated base images, platform
+// overrides, or imported config files, are left as they are and reported by
+// the error returned along with the otherwise updated config.
+func UpdatePinnedDigests(cfgData []byte, updates []ImageUpdate) ([]byte, error) {
+	var root yaml.Node
+
+	err := yaml.Unmarshal(cfgData, &root)
+
+	if err != nil {
+		return cfgData, errors.Wrap(err, "failed to parse config")
+	}
+
+	if len(root.Content) > 0 {
+		root = *root.Content[0]
+	}
+
+	lines := bytes.SplitAfter(cfgData, []byte("\n"))
+	failures := []string{}
+
+	for _, update := range updates {
+		if !update.Pinned || !update.Outdated() {
+			continue
+		}
+
+		node, err := pinnedBaseNode(&root, update)
+
+		if err == nil && node.Value != update.Image {
+			err = errors.Errorf("base is given as %q", node.Value)
+		}
+
+		if err != nil {
+			failures = append(failures, fmt.Sprintf(
+				"%s (variant %s, %s): %v",
+				update.Image, update.Variant, platforms.Format(update.Platform), err,
+			))
+
+			continue
+		}
+
+		// The value is found on the line of the node and rewritten in place.
+		// Updates for the same node on other platforms find it already
+		// rewritten, which is harmless.
+		line := lines[node.Line-1]
+		updated := strings.Replace(update.Image, update.Current.String(), update.Latest.String(), 1)
+		lines[node.Line-1] = bytes.Replace(line, []byte(update.Image), []byte(updated), 1)
+	}
+
+	cfgData = bytes.Join(lines, nil)
+
+	if len(failures) > 0 {
+		return cfgData, errors.Errorf(
+			"failed to update pinned digests of:\n%s",
+			strings.Join(failures, "\n"),
+		)
+	}
+
+	return cfgData, nil
+}
+
+// pinnedBaseNode returns the node of the base image field of the given config
+// root from which the image of the given update was taken, mirroring the
+// order in which the variant and its includes are merged. An error is
+// returned if the image is taken from platform overrides or from an imported
+// config file.
+func pinnedBaseNode(root *yaml.Node, update ImageUpdate) (*yaml.Node, error) {
+	variants := mappingValue(root, "variants")
+
+	// Imported variants are represented by nil nodes
+	chain := []*yaml.Node{root}
+	seen := map[string]bool{}
+
+	var visit func(name string)
+
+	visit = func(name string) {
+		if seen[name] {
+			return
+		}
+
+		seen[name] = true
+		vnode := mappingValue(variants, name)
+
+		if vnode != nil {
+			if includes := mappingValue(vnode, "includes"); includes != nil {
+				for _, include := range includes.Content {
+					visit(include.Value)
+				}
+			}
+		}
+
+		chain = append(chain, vnode)
+	}
+
+	visit(update.Variant)
+
+	for _, node := range chain {
+		overrides := mappingValue(node, "platforms")
+
+		if overrides == nil {
+			continue
+		}
+
+		for i := 0; i+1 < len(overrides.Content); i += 2 {
+			name := overrides.Content[i].Value
+			specifier, err := platforms.Parse(name)
+
+			if err != nil || !platforms.NewMatcher(specifier).Match(platforms.Normalize(update.Platform)) {
+				continue
+			}
+
+			if mappingValue(overrides.Content[i+1], "base") != nil {
+				return nil, errors.Errorf("base is overridden for platform %s", name)
+			}
+		}
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i] == nil {
+			break
+		}
+
+		if base := mappingValue(chain[i], "base"); base != nil && base.Kind == yaml.ScalarNode {
+			return base, nil
+		}
+	}
+
+	return nil, errors.New("base is imported")
+}
+
+// mappingValue returns the value node of the given key of the given mapping
+// node, or nil if the node is not a mapping or has no such key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
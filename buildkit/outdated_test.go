@@ -0,0 +1,221 @@
+package buildkit_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/buildkit"
+	"gitlab.wikimedia.org/repos/releng/blubber/util/testmetaresolver"
+)
+
+func TestOutdated(t *testing.T) {
+	amd64 := oci.Platform{OS: "linux", Architecture: "amd64"}
+	latest := digest.FromBytes([]byte("latest"))
+	old := digest.FromBytes([]byte("old"))
+
+	cfgData := `---
+    version: v4
+    variants:
+      pinned:
+        base: docker-registry.wikimedia.org/foo:1.0@` + old.String() + `
+      locked:
+        base: docker-registry.wikimedia.org/bar:2.0
+      current:
+        base: docker-registry.wikimedia.org/baz:3.0
+      unpinned:
+        base: docker-registry.wikimedia.org/qux:4.0
+      untagged:
+        base: docker-registry.wikimedia.org/quux@` + old.String() + `
+      scratch: {}`
+
+	readCfg := testImportReader(map[string]string{"blubber.yaml": cfgData})
+
+	bo := &buildkit.BuildOptions{Options: build.NewOptions()}
+
+	// The test resolver resolves any ref to the digest of the given ref
+	bo.MetaResolver = testmetaresolver.New("latest", oci.Image{})
+	bo.Lock = build.NewLock()
+	bo.Lock.Set("docker-registry.wikimedia.org/bar:2.0", amd64, old)
+	bo.Lock.Set("docker-registry.wikimedia.org/baz:3.0", amd64, latest)
+
+	t.Run("reports outdated images", func(t *testing.T) {
+		req := require.New(t)
+
+		updates, err := buildkit.Outdated(context.Background(), bo, "blubber.yaml", readCfg, nil, []oci.Platform{amd64})
+		req.NoError(err)
+
+		req.Equal(
+			[]buildkit.ImageUpdate{
+				{
+					Variant:  "current",
+					Image:    "docker-registry.wikimedia.org/baz:3.0",
+					Platform: amd64,
+					Current:  latest,
+					Latest:   latest,
+				},
+				{
+					Variant:  "locked",
+					Image:    "docker-registry.wikimedia.org/bar:2.0",
+					Platform: amd64,
+					Current:  old,
+					Latest:   latest,
+				},
+				{
+					Variant:  "pinned",
+					Image:    "docker-registry.wikimedia.org/foo:1.0@" + old.String(),
+					Platform: amd64,
+					Current:  old,
+					Latest:   latest,
+					Pinned:   true,
+				},
+				{
+					Variant:  "unpinned",
+					Image:    "docker-registry.wikimedia.org/qux:4.0",
+					Platform: amd64,
+					Latest:   latest,
+				},
+				{
+					Variant:  "untagged",
+					Image:    "docker-registry.wikimedia.org/quux@" + old.String(),
+					Platform: amd64,
+					Current:  old,
+					Pinned:   true,
+					Untagged: true,
+				},
+			},
+			updates,
+		)
+
+		req.False(updates[0].Outdated())
+		req.True(updates[1].Outdated())
+		req.True(updates[2].Outdated())
+		req.False(updates[3].Outdated())
+		req.False(updates[4].Outdated())
+	})
+
+	t.Run("rewrites pinned digests", func(t *testing.T) {
+		req := require.New(t)
+
+		updates, err := buildkit.Outdated(context.Background(), bo, "blubber.yaml", readCfg, []string{"pinned"}, []oci.Platform{amd64})
+		req.NoError(err)
+
+		updated, err := buildkit.UpdatePinnedDigests([]byte(cfgData), updates)
+		req.NoError(err)
+
+		req.Equal(
+			strings.Replace(cfgData, "foo:1.0@"+old.String(), "foo:1.0@"+latest.String(), 1),
+			string(updated),
+		)
+	})
+}
+
+func TestUpdatePinnedDigests(t *testing.T) {
+	amd64 := oci.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := oci.Platform{OS: "linux", Architecture: "arm64"}
+	latest := digest.FromBytes([]byte("latest"))
+	old := digest.FromBytes([]byte("old"))
+	image := "docker-registry.wikimedia.org/foo:1.0@" + old.String()
+
+	update := func(variant string, platform oci.Platform) buildkit.ImageUpdate {
+		return buildkit.ImageUpdate{
+			Variant:  variant,
+			Image:    image,
+			Platform: platform,
+			Current:  old,
+			Latest:   latest,
+			Pinned:   true,
+		}
+	}
+
+	t.Run("rewrites only the base of the variant", func(t *testing.T) {
+		req := require.New(t)
+
+		cfgData := `---
+    version: v4
+    # previously docker-registry.wikimedia.org/foo:1.0@` + old.String() + `
+    variants:
+      build:
+        base: docker-registry.wikimedia.org/foo:1.0@` + old.String() + `
+        runs:
+          environment:
+            IMAGE: docker-registry.wikimedia.org/foo:1.0@` + old.String() + `
+      production:
+        includes: [build]
+      test:
+        base: "docker-registry.wikimedia.org/foo:1.0@` + old.String() + `" # test
+`
+
+		updated, err := buildkit.UpdatePinnedDigests(
+			[]byte(cfgData),
+			[]buildkit.ImageUpdate{update("production", amd64), update("production", arm64)},
+		)
+		req.NoError(err)
+
+		req.Equal(
+			strings.Replace(cfgData, "base: "+image, "base: docker-registry.wikimedia.org/foo:1.0@"+latest.String(), 1),
+			string(updated),
+		)
+
+		updated, err = buildkit.UpdatePinnedDigests([]byte(cfgData), []buildkit.ImageUpdate{update("test", amd64)})
+		req.NoError(err)
+
+		req.Equal(
+			strings.Replace(cfgData, `"`+image+`" # test`, `"docker-registry.wikimedia.org/foo:1.0@`+latest.String()+`" # test`, 1),
+			string(updated),
+		)
+	})
+
+	t.Run("reports digests that cannot be rewritten", func(t *testing.T) {
+		req := require.New(t)
+
+		cfgData := `---
+    version: v4
+    imports:
+      - path: shared.yaml
+        as: shared
+    variants:
+      interpolated:
+        arguments:
+          TAG: 1.0@` + old.String() + `
+        base: docker-registry.wikimedia.org/foo:${TAG}
+      platforms:
+        base: docker-registry.wikimedia.org/bar:2.0
+        platforms:
+          linux/arm64:
+            base: docker-registry.wikimedia.org/foo:1.0@` + old.String() + `
+      imported:
+        includes: [shared/foo]
+      pinned:
+        base: docker-registry.wikimedia.org/foo:1.0@` + old.String() + `
+`
+
+		updated, err := buildkit.UpdatePinnedDigests(
+			[]byte(cfgData),
+			[]buildkit.ImageUpdate{
+				update("interpolated", amd64),
+				update("platforms", arm64),
+				update("imported", amd64),
+				update("pinned", amd64),
+			},
+		)
+
+		req.EqualError(
+			err,
+			"failed to update pinned digests of:\n"+
+				image+" (variant interpolated, linux/amd64): base is given as \"docker-registry.wikimedia.org/foo:${TAG}\"\n"+
+				image+" (variant platforms, linux/arm64): base is overridden for platform linux/arm64\n"+
+				image+" (variant imported, linux/amd64): base is imported",
+		)
+
+		req.Equal(
+			strings.Replace(cfgData, "pinned:\n        base: "+image, "pinned:\n        base: docker-registry.wikimedia.org/foo:1.0@"+latest.String(), 1),
+			string(updated),
+		)
+	})
+}
@@ -0,0 +1,210 @@
+package buildkit
+
+import (
+	"encoding/json"
+	"net/url"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	slsa02 "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
+	"github.com/moby/buildkit/client/llb"
+	gatewaypb "github.com/moby/buildkit/frontend/gateway/pb"
+	"github.com/moby/buildkit/solver/result"
+	"github.com/moby/buildkit/util/urlutil"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/meta"
+)
+
+const (
+	provenanceBuilderID = "https://gitlab.wikimedia.org/repos/releng/blubber"
+	provenanceBuildType = "https://gitlab.wikimedia.org/repos/releng/blubber/buildkit@v1"
+	provenanceFilename  = "/provenance.json"
+
+	// Reason under which the provenance attestation is attached. It differs
+	// from that of BuildKit's own provenance so that the two are kept apart.
+	provenanceReason = "blubber-provenance"
+)
+
+// Provenance describes the inputs to a Blubber build and is used to generate
+// a SLSA provenance attestation for each built image.
+//
+// See https://slsa.dev/spec/v0.2/provenance
+type Provenance struct {
+	// Name of the Blubber config file
+	ConfigFilename string
+
+	// Digest of the raw Blubber config
+	ConfigDigest digest.Digest
+
+	// The target variant
+	Variant string
+
+	// Build-time arguments given by the client. Proxy arguments are omitted
+	// and credentials in URLs are redacted when recorded.
+	BuildArgs map[string]string
+
+	// Result of validating the config against a policy, if one was given
+	Policy *PolicyResult
+
+	// Whether the build was performed with a fixed SOURCE_DATE_EPOCH
+	Reproducible bool
+}
+
+// PolicyResult records that the config was validated against the policy
+// found at the given URI. Builds of configs that fail validation are aborted
+// so there is no result recorded for them.
+type PolicyResult struct {
+	URI string `json:"uri"`
+}
+
+type provenanceParameters struct {
+	Variant   string            `json:"variant"`
+	BuildArgs map[string]string `json:"buildArgs,omitempty"`
+}
+
+type provenanceBuildConfig struct {
+	Version  string        `json:"blubberVersion"`
+	Platform string        `json:"platform"`
+	Policy   *PolicyResult `json:"policy,omitempty"`
+}
+
+// Predicate returns a SLSA provenance predicate for the given target. The
+// resolved base images of all targets in the target's group are included as
+// materials. Base images given by named build contexts are left to BuildKit's
+// own provenance.
+func (prov *Provenance) Predicate(target *build.Target) (*slsa02.ProvenancePredicate, error) {
+	materials := []common.ProvenanceMaterial{}
+
+	for _, dep := range target.Dependencies() {
+		if dep.Base == "" || dep.HasNamedBase() {
+			continue
+		}
+
+		material, err := baseImageMaterial(dep)
+
+		if err != nil {
+			return nil, err
+		}
+
+		materials = append(materials, material)
+	}
+
+	configDigest := common.DigestSet{}
+
+	if prov.ConfigDigest != "" {
+		configDigest[prov.ConfigDigest.Algorithm().String()] = prov.ConfigDigest.Encoded()
+	}
+
+	return &slsa02.ProvenancePredicate{
+		Builder:   common.ProvenanceBuilder{ID: provenanceBuilderID},
+		BuildType: provenanceBuildType,
+		Invocation: slsa02.ProvenanceInvocation{
+			ConfigSource: slsa02.ConfigSource{
+				URI:        prov.ConfigFilename,
+				Digest:     configDigest,
+				EntryPoint: prov.Variant,
+			},
+			Parameters: provenanceParameters{
+				Variant:   prov.Variant,
+				BuildArgs: filterBuildArgs(prov.BuildArgs),
+			},
+		},
+		BuildConfig: provenanceBuildConfig{
+			Version:  meta.FullVersion(),
+			Platform: platforms.Format(target.Platform()),
+			Policy:   prov.Policy,
+		},
+		Metadata: &slsa02.ProvenanceMetadata{
+			Completeness: slsa02.ProvenanceComplete{
+				Parameters: true,
+				Materials:  true,
+			},
+			Reproducible: prov.Reproducible,
+		},
+		Materials: materials,
+	}, nil
+}
+
+// Attestation returns an in-toto attestation containing the provenance
+// predicate for the given target.
+func (prov *Provenance) Attestation(target *build.Target) (result.Attestation[*llb.State], error) {
+	var att result.Attestation[*llb.State]
+
+	predicate, err := prov.Predicate(target)
+
+	if err != nil {
+		return att, err
+	}
+
+	data, err := json.Marshal(predicate)
+
+	if err != nil {
+		return att, errors.Wrap(err, "failed to marshal provenance predicate")
+	}
+
+	st := llb.Scratch().File(
+		llb.Mkfile(provenanceFilename, 0o444, data),
+		llb.WithCustomName("[internal] generating provenance"),
+	)
+
+	return result.Attestation[*llb.State]{
+		Kind: gatewaypb.AttestationKind_InToto,
+		Metadata: map[string][]byte{
+			result.AttestationReasonKey: []byte(provenanceReason),
+		},
+		Ref:  &st,
+		Path: provenanceFilename,
+		InToto: result.InTotoAttestation{
+			PredicateType: slsa02.PredicateSLSAProvenance,
+		},
+	}, nil
+}
+
+// filterBuildArgs returns the given build arguments without proxy arguments
+// and with credentials in URL values redacted, as done by BuildKit for its
+// own provenance.
+func filterBuildArgs(args map[string]string) map[string]string {
+	filtered := map[string]string{}
+
+	for name, value := range args {
+		if build.IsProxyArg(name) {
+			continue
+		}
+
+		filtered[name] = urlutil.RedactCredentials(value)
+	}
+
+	return filtered
+}
+
+// baseImageMaterial returns a material describing the resolved base image of
+// the given target in the form of a package URL.
+func baseImageMaterial(target *build.Target) (common.ProvenanceMaterial, error) {
+	material := common.ProvenanceMaterial{Digest: common.DigestSet{}}
+
+	ref, err := reference.ParseNormalizedNamed(target.Base)
+
+	if err != nil {
+		return material, errors.Wrapf(err, "failed to parse base image %q", target.Base)
+	}
+
+	version := "latest"
+
+	if tagged, ok := ref.(reference.Tagged); ok {
+		version = tagged.Tag()
+	}
+
+	if digested, ok := ref.(reference.Digested); ok {
+		dgst := digested.Digest()
+		material.Digest[dgst.Algorithm().String()] = dgst.Encoded()
+	}
+
+	material.URI = "pkg:docker/" + reference.FamiliarName(ref) + "@" + version +
+		"?platform=" + url.QueryEscape(platforms.Format(target.Platform()))
+
+	return material, nil
+}
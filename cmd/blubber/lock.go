@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/platforms"
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/buildkit"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+// lockMain resolves the base and external images of the given variants (or
+// all variants) of the given config and writes their digests to a lock file
+// alongside the config.
+func lockMain(ctx context.Context, cfgPath string, variants []string) {
+	targetPlatforms := parsePlatforms()
+
+	opts := buildkit.BuildOptions{
+		Options: build.NewOptions(),
+	}
+	opts.Lock = build.NewLock()
+
+	err := buildkit.Lock(ctx, &opts, cfgPath, config.ReadImportFile, variants, targetPlatforms)
+
+	if err != nil {
+		log.Printf("Error locking %s: %v\n", cfgPath, err)
+		os.Exit(3)
+	}
+
+	lockPath := filepath.Join(filepath.Dir(cfgPath), build.LockFilename)
+
+	err = opts.Lock.WriteFile(lockPath)
+
+	if err != nil {
+		log.Printf("Error writing %s: %v\n", lockPath, err)
+		os.Exit(2)
+	}
+}
+
+// parsePlatforms returns the platforms given by the --platform option, or the
+// host platform if none were given.
+func parsePlatforms() []oci.Platform {
+	if len(*platformArg) == 0 {
+		return []oci.Platform{platforms.DefaultSpec()}
+	}
+
+	targetPlatforms := make([]oci.Platform, len(*platformArg))
+
+	for i, p := range *platformArg {
+		platform, err := platforms.Parse(p)
+
+		if err != nil {
+			log.Printf("Error parsing platform %q: %v\n", p, err)
+			os.Exit(1)
+		}
+
+		targetPlatforms[i] = platform
+	}
+
+	return targetPlatforms
+}
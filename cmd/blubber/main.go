@@ -3,11 +3,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 
+	"github.com/moby/buildkit/client/llb"
 	"github.com/pborman/getopt/v2"
 
 	"gitlab.wikimedia.org/repos/releng/blubber/build"
@@ -16,12 +20,15 @@ import (
 	"gitlab.wikimedia.org/repos/releng/blubber/meta"
 )
 
-const parameters = "config.yaml variant"
+const parameters = "config.yaml variant | lock config.yaml [variant ...] | outdated config.yaml [variant ...]"
 
 var (
 	showHelp    = getopt.BoolLong("help", 'h', "show help/usage")
 	policyURI   = getopt.StringLong("policy", 'p', "", "policy file URI", "uri")
 	showVersion = getopt.BoolLong("version", 'v', "show version information")
+	locked      = getopt.BoolLong("locked", 0, "fail if "+build.LockFilename+" is missing, stale, or has no digest for an image")
+	platformArg = getopt.ListLong("platform", 0, "platforms for which to lock or check images (default: host platform)", "os/arch,...")
+	update      = getopt.BoolLong("update", 0, "rewrite outdated digests pinned in the config or recorded in "+build.LockFilename)
 )
 
 func main() {
@@ -45,6 +52,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	ctx, cancel := newContext()
+	defer cancel()
+
+	switch args[0] {
+	case "lock":
+		lockMain(ctx, args[1], args[2:])
+		return
+	case "outdated":
+		outdatedMain(ctx, args[1], args[2:])
+		return
+	}
+
 	cfgPath, variant := args[0], args[1]
 
 	cfg, err := config.ReadConfigFile(cfgPath)
@@ -86,27 +105,71 @@ func main() {
 		}
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	opts := buildkit.BuildOptions{
+		Options: build.NewOptions(),
+	}
+	opts.Variant = variant
+	opts.Locked = *locked
 
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
-	defer func() {
-		signal.Stop(ch)
-		cancel()
-	}()
+	// Exclude files listed by the ignore file of the local build context
+	ignoreData, err := os.ReadFile(build.IgnoreFilename)
 
-	go func() {
-		select {
-		case <-ch:
-			cancel()
-		case <-ctx.Done():
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Error reading %s: %v\n", build.IgnoreFilename, err)
+		os.Exit(2)
+	}
+
+	ignored, err := build.ReadIgnorePatterns(ignoreData)
+
+	if err != nil {
+		log.Printf("Error parsing %s: %v\n", build.IgnoreFilename, err)
+		os.Exit(2)
+	}
+
+	buildContext := opts.BuildContext
+	opts.BuildContext = func(ctx context.Context, localOpts ...llb.LocalOption) (*llb.State, error) {
+		return buildContext(ctx, append([]llb.LocalOption{build.ExcludePatterns(ignored)}, localOpts...)...)
+	}
+
+	// Files of the local build context, i.e. the current directory, are read
+	// as filtered by the ignore file and the given options
+	opts.ReadContextFile = func(_ context.Context, path string, localOpts ...llb.LocalOption) ([]byte, error) {
+		included, err := build.ContextIncludes(path, append([]llb.LocalOption{build.ExcludePatterns(ignored)}, localOpts...)...)
+
+		if err != nil {
+			return nil, err
 		}
-	}()
 
-	opts := buildkit.BuildOptions{
-		Options: build.NewOptions(),
+		if !included {
+			return nil, fmt.Errorf("%s is excluded from the build context: %w", path, fs.ErrNotExist)
+		}
+
+		return os.ReadFile(path)
+	}
+
+	lockPath := filepath.Join(filepath.Dir(cfgPath), build.LockFilename)
+	opts.Lock, err = build.ReadLockFile(lockPath)
+
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Error reading %s: %v\n", lockPath, err)
+			os.Exit(2)
+		}
+
+		if *locked {
+			log.Printf("Error: %s is required but does not exist\n", lockPath)
+			os.Exit(7)
+		}
+	}
+
+	if *locked && opts.Lock != nil {
+		err = buildkit.CheckLock(ctx, &opts, cfgPath, config.ReadImportFile)
+
+		if err != nil {
+			log.Printf("Error: %v\n", err)
+			os.Exit(7)
+		}
 	}
-	opts.Variant = variant
 
 	target, err := buildkit.Compile(ctx, &opts, cfg, nil)
 
@@ -122,3 +185,24 @@ func main() {
 		os.Exit(3)
 	}
 }
+
+// newContext returns a context that is canceled upon interrupt.
+func newContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+
+	go func() {
+		select {
+		case <-ch:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(ch)
+		cancel()
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/platforms"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/buildkit"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+// outdatedMain reports whether the base images of the given variants (or all
+// variants) of the given config point to newer digests than those pinned in
+// the config or recorded in the lock file, optionally rewriting them. Exits
+// with status 8 if outdated images remain.
+func outdatedMain(ctx context.Context, cfgPath string, variants []string) {
+	cfgData, err := os.ReadFile(cfgPath)
+
+	if err != nil {
+		log.Printf("Error reading %s: %v\n", cfgPath, err)
+		os.Exit(2)
+	}
+
+	opts := buildkit.BuildOptions{
+		Options: build.NewOptions(),
+	}
+
+	lockPath := filepath.Join(filepath.Dir(cfgPath), build.LockFilename)
+	opts.Lock, err = build.ReadLockFile(lockPath)
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Error reading %s: %v\n", lockPath, err)
+		os.Exit(2)
+	}
+
+	updates, err := buildkit.Outdated(ctx, &opts, cfgPath, config.ReadImportFile, variants, parsePlatforms())
+
+	if err != nil {
+		log.Printf("Error checking %s: %v\n", cfgPath, err)
+		os.Exit(3)
+	}
+
+	outdated := false
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VARIANT\tIMAGE\tPLATFORM\tCURRENT\tLATEST\tSTATUS")
+
+	for _, update := range updates {
+		status := "up to date"
+
+		switch {
+		case update.Untagged:
+			status = "untagged"
+		case update.Current == "":
+			status = "unpinned"
+		case update.Outdated():
+			status = "outdated"
+			outdated = true
+		}
+
+		fmt.Fprintf(
+			tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			update.Variant, update.Image, platforms.Format(update.Platform),
+			update.Current, update.Latest, status,
+		)
+	}
+
+	tw.Flush()
+
+	if !outdated {
+		return
+	}
+
+	if !*update {
+		os.Exit(8)
+	}
+
+	// Digests that cannot be rewritten are reported after all others have
+	// been written
+	cfgData, updateErr := buildkit.UpdatePinnedDigests(cfgData, updates)
+	err = os.WriteFile(cfgPath, cfgData, 0o644)
+
+	if err != nil {
+		log.Printf("Error writing %s: %v\n", cfgPath, err)
+		os.Exit(2)
+	}
+
+	if opts.Lock != nil {
+		for _, update := range updates {
+			if !update.Pinned && update.Outdated() {
+				opts.Lock.Set(update.Image, update.Platform, update.Latest)
+			}
+		}
+
+		err = opts.Lock.WriteFile(lockPath)
+
+		if err != nil {
+			log.Printf("Error writing %s: %v\n", lockPath, err)
+			os.Exit(2)
+		}
+	}
+
+	if updateErr != nil {
+		log.Printf("Error updating %s: %v\n", cfgPath, updateErr)
+		os.Exit(8)
+	}
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+)
+
+// ApkConfig represents configuration pertaining to package installation on
+// Alpine based images using apk.
+type ApkConfig struct {
+	// Packages is a list of the desired packages, optionally constrained to
+	// a version (e.g. "curl=8.5.0-r0" or "curl~8.5") and/or a tagged
+	// repository (e.g. "curl@edge")
+	Packages []string `json:"packages" validate:"dive,alpinepackage"`
+
+	// Repositories provides additional apk repositories for packages
+	Repositories []ApkRepository `json:"repositories" validate:"dive"`
+
+	// Keys maps file names of public keys (e.g. "builder@example.org-1.rsa.pub")
+	// to their PEM encoded content. The file name must match the name of the
+	// key used to sign the repository index.
+	Keys map[string]string `json:"keys" validate:"dive,keys,apkkeyname,endkeys,required" interpolate:"false"`
+
+	// Proxy is an HTTP/HTTPS proxy to use during package installation
+	Proxy string `json:"proxy" validate:"omitempty,httpurl"`
+}
+
+const (
+	// ApkRepositoriesPath is the file that lists the repositories apk uses.
+	ApkRepositoriesPath = "/etc/apk/repositories"
+
+	// ApkRepositoriesConfigurationPath is the file that configuration will be
+	// written to for each defined repository prior to being appended to
+	// [ApkRepositoriesPath].
+	ApkRepositoriesConfigurationPath = "/etc/apk/99blubber-repositories"
+
+	// ApkKeysDir is the directory where [ApkConfig.Keys] will be written.
+	ApkKeysDir = "/etc/apk/keys"
+
+	// ApkFileMode is the default file mode of apk configuration files.
+	ApkFileMode = os.FileMode(0o644)
+)
+
+// Merge takes another ApkConfig and combines the packages, repositories and
+// keys declared within with those of this ApkConfig.
+func (apk *ApkConfig) Merge(apk2 ApkConfig) {
+	if apk2.Packages != nil {
+		apk.Packages = append(apk.Packages, apk2.Packages...)
+	}
+
+	if apk2.Repositories != nil {
+		apk.Repositories = append(apk.Repositories, apk2.Repositories...)
+	}
+
+	if apk2.Keys != nil {
+		if apk.Keys == nil {
+			apk.Keys = map[string]string{}
+		}
+
+		maps.Copy(apk.Keys, apk2.Keys)
+	}
+
+	if apk2.Proxy != "" {
+		apk.Proxy = apk2.Proxy
+	}
+}
+
+// InstructionsForPhase injects build instructions that will install the
+// declared packages during the privileged phase.
+//
+// # PhasePrivileged
+//
+// Writes signing keys, appends repositories, and installs configured packages
+// without retaining the package index cache.
+func (apk ApkConfig) InstructionsForPhase(phase build.Phase) []build.Instruction {
+	ins := []build.Instruction{}
+
+	if len(apk.Packages) > 0 || len(apk.Repositories) > 0 || len(apk.Keys) > 0 {
+		switch phase {
+		case build.PhasePrivileged:
+			var runAll []build.Run
+
+			for _, name := range slices.Sorted(maps.Keys(apk.Keys)) {
+				ins = append(ins, build.File{
+					Path:    path.Join(ApkKeysDir, name),
+					Content: []byte(apk.Keys[name]),
+					Mode:    ApkFileMode,
+				})
+			}
+
+			// Repositories are sorted and deduplicated so that the generated
+			// configuration is the same regardless of the order in which
+			// variants were merged
+			var repositories []string
+			for _, repo := range apk.Repositories {
+				repositories = append(repositories, repo.Configuration())
+			}
+
+			slices.Sort(repositories)
+			repositories = slices.Compact(repositories)
+
+			if len(repositories) > 0 {
+				ins = append(ins,
+					build.File{
+						Path:    ApkRepositoriesConfigurationPath,
+						Content: []byte(strings.Join(repositories, "\n") + "\n"),
+						Mode:    ApkFileMode,
+					},
+					build.RunAll{[]build.Run{
+						{"cat %s >> %s", []string{ApkRepositoriesConfigurationPath, ApkRepositoriesPath}},
+						{"rm -f", []string{ApkRepositoriesConfigurationPath}},
+					}},
+				)
+			}
+
+			if len(apk.Packages) > 0 {
+				if apk.Proxy != "" {
+					runAll = append(runAll, build.Run{
+						"export http_proxy=%s https_proxy=%s",
+						[]string{apk.Proxy, apk.Proxy},
+					})
+				}
+
+				runAll = append(runAll, build.Run{"apk add --no-cache", apk.Packages})
+				ins = append(ins, build.RunAll{runAll})
+			}
+		}
+	}
+
+	return ins
+}
+
+// ApkRepository represents an apk repository to set up prior to package
+// installation.
+type ApkRepository struct {
+	// URL of the repository, e.g.
+	// "https://dl-cdn.alpinelinux.org/alpine/edge/testing"
+	URL string `json:"url" validate:"required,httpurl"`
+
+	// Tag is an optional name for the repository. Packages are only
+	// installed from tagged repositories when explicitly requested (e.g.
+	// "curl@edge").
+	Tag string `json:"tag" validate:"omitempty,alpinetag"`
+}
+
+// Configuration returns the apk repositories line for this repository.
+func (ar ApkRepository) Configuration() string {
+	if ar.Tag != "" {
+		return "@" + ar.Tag + " " + ar.URL
+	}
+
+	return ar.URL
+}
@@ -0,0 +1,199 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+func TestApkConfigYAML(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    apk:
+      packages: [libfoo, libbar]
+      repositories:
+        - url: https://dl-cdn.alpinelinux.org/alpine/edge/testing
+          tag: testing
+      keys:
+        builder@example.org-1.rsa.pub: foo
+      proxy: http://proxy.example:8080
+    variants:
+      build:
+        apk:
+          packages: [libfoo-dev]`))
+
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"libfoo", "libbar"}, cfg.Apk.Packages)
+	assert.Equal(t,
+		[]config.ApkRepository{
+			{URL: "https://dl-cdn.alpinelinux.org/alpine/edge/testing", Tag: "testing"},
+		},
+		cfg.Apk.Repositories,
+	)
+	assert.Equal(t, map[string]string{"builder@example.org-1.rsa.pub": "foo"}, cfg.Apk.Keys)
+	assert.Equal(t, "http://proxy.example:8080", cfg.Apk.Proxy)
+
+	require.NoError(t, config.ExpandIncludesAndCopies(cfg, "build"))
+
+	variant, err := config.GetVariant(cfg, "build")
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"libfoo", "libbar", "libfoo-dev"}, variant.Apk.Packages)
+}
+
+func TestApkConfigMerge(t *testing.T) {
+	cfg := config.ApkConfig{
+		Packages: []string{"foo"},
+		Keys:     map[string]string{"a.rsa.pub": "a"},
+	}
+
+	cfg.Merge(config.ApkConfig{
+		Packages:     []string{"bar"},
+		Repositories: []config.ApkRepository{{URL: "https://repo.example"}},
+		Keys:         map[string]string{"b.rsa.pub": "b"},
+		Proxy:        "http://proxy.example:8080",
+	})
+
+	assert.Equal(t, config.ApkConfig{
+		Packages:     []string{"foo", "bar"},
+		Repositories: []config.ApkRepository{{URL: "https://repo.example"}},
+		Keys:         map[string]string{"a.rsa.pub": "a", "b.rsa.pub": "b"},
+		Proxy:        "http://proxy.example:8080",
+	}, cfg)
+}
+
+func TestApkConfigInstructions(t *testing.T) {
+	cfg := config.ApkConfig{
+		Packages: []string{"libfoo", "libbar@testing"},
+		Repositories: []config.ApkRepository{
+			{URL: "https://dl-cdn.alpinelinux.org/alpine/edge/testing", Tag: "testing"},
+			{URL: "https://apk.example"},
+		},
+		Keys:  map[string]string{"builder@example.org-1.rsa.pub": "foo"},
+		Proxy: "http://proxy.example:8080",
+	}
+
+	t.Run("PhasePrivileged", func(t *testing.T) {
+		assert.Equal(t,
+			[]build.Instruction{
+				build.File{
+					Path:    "/etc/apk/keys/builder@example.org-1.rsa.pub",
+					Content: []byte("foo"),
+					Mode:    config.ApkFileMode,
+				},
+				build.File{
+					Path: "/etc/apk/99blubber-repositories",
+					Content: []byte(strings.Join([]string{
+						"@testing https://dl-cdn.alpinelinux.org/alpine/edge/testing\n",
+						"https://apk.example\n",
+					}, "")),
+					Mode: config.ApkFileMode,
+				},
+				build.RunAll{[]build.Run{
+					{"cat %s >> %s", []string{"/etc/apk/99blubber-repositories", "/etc/apk/repositories"}},
+					{"rm -f", []string{"/etc/apk/99blubber-repositories"}},
+				}},
+				build.RunAll{[]build.Run{
+					{"export http_proxy=%s https_proxy=%s", []string{"http://proxy.example:8080", "http://proxy.example:8080"}},
+					{"apk add --no-cache", []string{"libfoo", "libbar@testing"}},
+				}},
+			},
+			cfg.InstructionsForPhase(build.PhasePrivileged),
+		)
+	})
+
+	t.Run("PhasePrivilegeDropped", func(t *testing.T) {
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhasePrivilegeDropped))
+	})
+
+	t.Run("PhasePreInstall", func(t *testing.T) {
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhasePreInstall))
+	})
+
+	t.Run("PhasePostInstall", func(t *testing.T) {
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhasePostInstall))
+	})
+}
+
+func TestApkConfigValidation(t *testing.T) {
+	t.Run("packages", func(t *testing.T) {
+		t.Run("ok", func(t *testing.T) {
+			err := config.Validate(config.ApkConfig{
+				Packages: []string{
+					"f1",
+					"py3-ruamel.yaml",
+					"curl=8.5.0-r0",
+					"curl~8.5",
+					"curl>=8",
+					"curl@edge",
+					"curl@edge=8.5.0-r0",
+				},
+			})
+
+			assert.False(t, config.IsValidationError(err))
+		})
+
+		t.Run("bad", func(t *testing.T) {
+			err := config.Validate(config.ApkConfig{
+				Packages: []string{
+					"f1",
+					"foo fighter",
+					"curl=bad version",
+					"curl@",
+				},
+			})
+
+			if assert.True(t, config.IsValidationError(err)) {
+				msg := config.HumanizeValidationError(err)
+
+				assert.Equal(t, strings.Join([]string{
+					`packages[1]: "foo fighter" is not a valid Alpine package name`,
+					`packages[2]: "curl=bad version" is not a valid Alpine package name`,
+					`packages[3]: "curl@" is not a valid Alpine package name`,
+				}, "\n"), msg)
+			}
+		})
+	})
+
+	t.Run("repositories", func(t *testing.T) {
+		err := config.Validate(config.ApkConfig{
+			Repositories: []config.ApkRepository{
+				{URL: "https://apk.example", Tag: "ok"},
+				{URL: "ftp://apk.example", Tag: "bad tag"},
+			},
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, strings.Join([]string{
+				`url: "ftp://apk.example" is not a valid HTTP/HTTPS URL`,
+				`tag: "bad tag" is not a valid Alpine repository tag`,
+			}, "\n"), msg)
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		err := config.Validate(config.ApkConfig{
+			Keys: map[string]string{
+				"builder@example.org-1.rsa.pub": "foo",
+				"../key.rsa.pub":                "foo",
+			},
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t,
+				`keys[../key.rsa.pub]: "../key.rsa.pub" is not a valid apk public key file name`,
+				msg,
+			)
+		}
+	})
+}
@@ -4,12 +4,17 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"maps"
 	"net/url"
 	"os"
 	"path"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 
+	digest "github.com/opencontainers/go-digest"
+
 	"gitlab.wikimedia.org/repos/releng/blubber/build"
 )
 
@@ -26,6 +31,23 @@ type AptConfig struct {
 
 	// Sources provides APT sources for packages
 	Sources []AptSource `json:"sources" validate:"dive,omitempty"`
+
+	// SourcesFormat is the format in which sources are written, either
+	// "list" (default) for one-line entries in a single file or "deb822"
+	// for one ".sources" file per source
+	SourcesFormat string `json:"sources-format" validate:"omitempty,oneof=list deb822"`
+
+	// Cache enables persistent BuildKit cache mounts for package lists and
+	// archives (default true)
+	Cache Flag `json:"cache"`
+
+	// Pins provides APT preferences that control from which release, origin
+	// or version packages are installed
+	Pins []AptPin `json:"pins" validate:"dive"`
+
+	// Lock records the versions of the installed packages in a manifest
+	// within the image (see [AptLockManifestPath])
+	Lock Flag `json:"lock"`
 }
 
 const (
@@ -37,6 +59,14 @@ const (
 	// written to for each defined source.
 	AptSourceConfigurationPath = "/etc/apt/sources.list.d/99blubber.list"
 
+	// AptSourcesDir is the directory where deb822-style source configuration
+	// will be written, one file per defined source.
+	AptSourcesDir = "/etc/apt/sources.list.d"
+
+	// AptSourcesFormatDeb822 is the [AptConfig.SourcesFormat] for
+	// deb822-style source configuration.
+	AptSourcesFormatDeb822 = "deb822"
+
 	// AptKeyringDir is the directory where [AptSource.SignedBy] key data will
 	// be written.
 	AptKeyringDir = "/etc/apt/keyrings"
@@ -45,8 +75,40 @@ const (
 	// to for each defined proxy.
 	AptProxyConfigurationPath = "/etc/apt/apt.conf.d/99blubber-proxies"
 
+	// AptPreferencesConfigurationPath is the file that preferences will be
+	// written to for each defined pin.
+	AptPreferencesConfigurationPath = "/etc/apt/preferences.d/99blubber"
+
+	// AptLockManifestPath is the file to which the installed versions of
+	// the configured packages are written when locking is enabled, one
+	// "package=version" per line.
+	AptLockManifestPath = "/usr/share/blubber/apt.lock"
+
+	// AptKeepCacheConfigurationPath is the file at which configuration is
+	// mounted when caching is enabled, instructing APT to retain downloaded
+	// package archives in its (mounted) cache directory.
+	AptKeepCacheConfigurationPath = "/etc/apt/apt.conf.d/99blubber-keep-cache"
+
+	// AptDockerCleanConfigurationPath is the configuration installed by
+	// official Debian images that removes downloaded package archives after
+	// each installation. It is masked by an empty file mount when caching is
+	// enabled.
+	AptDockerCleanConfigurationPath = "/etc/apt/apt.conf.d/docker-clean"
+
+	// AptCacheDir is the directory in which APT stores downloaded package
+	// archives.
+	AptCacheDir = "/var/cache/apt"
+
+	// AptListsDir is the directory in which APT stores package lists.
+	AptListsDir = "/var/lib/apt/lists"
+
 	// AptFileMode is the default file mode of APT configuration files.
 	AptFileMode = os.FileMode(0o644)
+
+	// AptLogFiles are removed after package installation during reproducible
+	// builds as they contain installation timestamps and would otherwise make
+	// image layers non-reproducible.
+	AptLogFiles = "/var/log/apt/* /var/log/dpkg.log /var/log/alternatives.log /var/cache/debconf/*-old"
 )
 
 // Merge takes another AptConfig and combines the packages declared within
@@ -70,6 +132,24 @@ func (apt *AptConfig) Merge(apt2 AptConfig) {
 	if apt2.Sources != nil {
 		apt.Sources = append(apt.Sources, apt2.Sources...)
 	}
+
+	if apt2.Pins != nil {
+		apt.Pins = append(apt.Pins, apt2.Pins...)
+	}
+
+	if apt2.SourcesFormat != "" {
+		apt.SourcesFormat = apt2.SourcesFormat
+	}
+
+	apt.Cache.Merge(apt2.Cache)
+	apt.Lock.Merge(apt2.Lock)
+}
+
+// CacheEnabled returns whether package lists and archives should be kept in
+// persistent cache mounts rather than removed after installation. Caching is
+// enabled unless explicitly disabled.
+func (apt AptConfig) CacheEnabled() bool {
+	return !apt.Cache.Set || apt.Cache.True
 }
 
 // InstructionsForPhase injects build instructions that will install the
@@ -77,11 +157,15 @@ func (apt *AptConfig) Merge(apt2 AptConfig) {
 //
 // # PhasePrivileged
 //
-// Updates the APT cache, installs configured packages, and cleans up.
+// Updates the APT cache, installs configured packages, and cleans up. If
+// caching is enabled, package lists and archives are kept in locked cache
+// mounts that persist between builds instead of being removed. If locking is
+// enabled, the installed versions of the configured packages are written to
+// [AptLockManifestPath].
 func (apt AptConfig) InstructionsForPhase(phase build.Phase) []build.Instruction {
 	ins := []build.Instruction{}
 
-	if len(apt.Packages) > 0 || len(apt.Sources) > 0 || len(apt.Proxies) > 0 {
+	if len(apt.Packages) > 0 || len(apt.Sources) > 0 || len(apt.Proxies) > 0 || len(apt.Pins) > 0 {
 		switch phase {
 		case build.PhasePrivileged:
 			var (
@@ -93,12 +177,15 @@ func (apt AptConfig) InstructionsForPhase(phase build.Phase) []build.Instruction
 				"DEBIAN_FRONTEND": "noninteractive",
 			}})
 
-			// Configure proxies
+			// Configure proxies. Duplicate lines resulting from merged variants
+			// are removed but the configured order is kept.
 			var proxies []string
 			for _, proxy := range apt.Proxies {
 				proxies = append(proxies, proxy.Configuration())
 			}
 
+			proxies = uniqueValues(proxies)
+
 			if len(proxies) > 0 {
 				ins = append(ins, build.File{
 					Path:    AptProxyConfigurationPath,
@@ -107,35 +194,65 @@ func (apt AptConfig) InstructionsForPhase(phase build.Phase) []build.Instruction
 				})
 			}
 
-			// Configure sources
-			var sources []string
+			// Configure pins, deduplicated in the same way as proxies. Order
+			// matters here as APT uses the first matching pin.
+			var pins []string
+			for _, pin := range apt.Pins {
+				pins = append(pins, pin.Configuration())
+			}
+
+			pins = uniqueValues(pins)
+
+			if len(pins) > 0 {
+				ins = append(ins, build.File{
+					Path:    AptPreferencesConfigurationPath,
+					Content: []byte(strings.Join(pins, "\n")),
+					Mode:    os.FileMode(AptFileMode),
+				})
+			}
+
+			// Configure sources, deduplicated in the same way as proxies
+			var lines []string
+			sources := map[string]string{}
+			keyrings := map[string]build.Instruction{}
 			for _, source := range apt.Sources {
-				sources = append(sources, source.Configuration())
+				if apt.SourcesFormat == AptSourcesFormatDeb822 {
+					sources[source.SourcesPath()] = source.Deb822()
+				} else {
+					lines = append(lines, source.Configuration())
+				}
 
-				if source.SignedBy != "" {
-					ins = append(ins, build.File{
-						Path:    source.KeyringPath(),
-						Content: []byte(source.SignedBy),
-						Mode:    os.FileMode(AptFileMode),
-					})
+				if source.IsSigned() {
+					keyrings[source.KeyringPath()] = source.keyringInstruction()
 				}
 			}
 
+			if len(lines) > 0 {
+				sources[AptSourceConfigurationPath] = strings.Join(uniqueValues(lines), "\n") + "\n"
+			}
+
+			for _, keyringPath := range slices.Sorted(maps.Keys(keyrings)) {
+				ins = append(ins, keyrings[keyringPath])
+			}
+
 			if len(sources) > 0 {
 				// If we're configuring any additional sources, install
 				// ca-certificates first to ensure successful fetching of third-party
 				// package lists over https
 				ins = append(ins,
-					build.RunAll{[]build.Run{
+					apt.runAll([]build.Run{
 						{"apt-get update", []string{}},
 						{"apt-get install -y", []string{"ca-certificates"}},
-					}},
-					build.File{
-						Path:    AptSourceConfigurationPath,
-						Content: []byte(strings.Join(sources, "\n") + "\n"),
-						Mode:    os.FileMode(AptFileMode),
-					},
+					}),
 				)
+
+				for _, sourcesPath := range slices.Sorted(maps.Keys(sources)) {
+					ins = append(ins, build.File{
+						Path:    sourcesPath,
+						Content: []byte(sources[sourcesPath]),
+						Mode:    os.FileMode(AptFileMode),
+					})
+				}
 			}
 
 			// order the targets for the same result each run
@@ -158,19 +275,100 @@ func (apt AptConfig) InstructionsForPhase(phase build.Phase) []build.Instruction
 				}
 			}
 
-			runAll = append(runAll, build.Run{"rm -rf /var/lib/apt/lists/*", []string{}})
+			if !apt.CacheEnabled() {
+				runAll = append(runAll, build.Run{"rm -rf " + AptListsDir + "/*", []string{}})
+			}
+
+			// SOURCE_DATE_EPOCH is only set for reproducible builds
+			runAll = append(runAll, build.Run{`[ -z "$SOURCE_DATE_EPOCH" ] || rm -rf ` + AptLogFiles, []string{}})
 
 			if len(apt.Proxies) > 0 {
 				runAll = append(runAll, build.Run{"rm -f", []string{AptProxyConfigurationPath}})
 			}
 
-			ins = append(ins, build.RunAll{runAll})
+			ins = append(ins, apt.runAll(runAll))
+
+			// The manifest is written by a script as the dpkg-query format
+			// would otherwise be subject to the expansion of environment
+			// variables in run commands
+			if apt.Lock.True && len(targets) > 0 {
+				ins = append(ins, build.RunScript{Script: []byte(apt.lockScript())})
+			}
 		}
 	}
 
 	return ins
 }
 
+// runAll returns a [build.RunAll] for the given runs or, if caching is
+// enabled, a [build.RunAllWithOptions] that mounts the package lists and
+// archives directories as locked persistent caches specific to the base image
+// and platform. For the duration of the runs, the docker-clean configuration
+// of official Debian images is masked and APT is configured to keep
+// downloaded archives, so that archives are retained in the cache without
+// altering the APT configuration of the image.
+func (apt AptConfig) runAll(runs []build.Run) build.Instruction {
+	if !apt.CacheEnabled() {
+		return build.RunAll{runs}
+	}
+
+	return build.RunAllWithOptions{
+		Runs: runs,
+		Options: []build.RunOption{
+			build.CacheMount{Destination: AptCacheDir, Access: "locked", PerBase: true},
+			build.CacheMount{Destination: AptListsDir, Access: "locked", PerBase: true},
+			build.FileMount{Destination: AptDockerCleanConfigurationPath},
+			build.FileMount{
+				Destination: AptKeepCacheConfigurationPath,
+				Content:     []byte(`Binary::apt::APT::Keep-Downloaded-Packages "true";` + "\n"),
+			},
+		},
+	}
+}
+
+// lockScript returns a shell script that writes the installed versions of
+// the configured packages to [AptLockManifestPath].
+func (apt AptConfig) lockScript() string {
+	return "set -e\n" +
+		"mkdir -p " + path.Dir(AptLockManifestPath) + "\n" +
+		"dpkg-query -W -f '${Package}=${Version}\\n' " +
+		strings.Join(apt.packageNames(), " ") + " > " + AptLockManifestPath + "\n"
+}
+
+// packageNames returns the sorted and deduplicated names of all configured
+// packages without any version or release suffix.
+func (apt AptConfig) packageNames() []string {
+	names := []string{}
+
+	for _, pkgs := range apt.Packages {
+		for _, pkg := range pkgs {
+			name, _, _ := strings.Cut(pkg, "=")
+			name, _, _ = strings.Cut(name, "/")
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	return slices.Compact(names)
+}
+
+// uniqueValues returns the given values without duplicates, keeping the
+// order of first occurrence.
+func uniqueValues[T comparable](values []T) []T {
+	seen := map[T]bool{}
+	unique := []T{}
+
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+
+	return unique
+}
+
 // AptPackages represents lists of packages to install. Each entry is keyed by
 // the release that should be targetted during installation, i.e. `apt-get
 // install -t release package`.
@@ -277,36 +475,173 @@ func (ap AptProxy) Configuration() string {
 	return cfg
 }
 
+// AptPin represents an APT preference that pins the given packages to a
+// release, origin or version with the given priority. See apt_preferences(5).
+type AptPin struct {
+	// Package is a package name, glob or regular expression (e.g. "nodejs",
+	// "*" or "/^php/")
+	Package string `json:"package" validate:"required"`
+
+	// Pin is the criteria by which package versions are selected (e.g.
+	// "release n=bookworm-backports", "origin apt.wikimedia.org" or
+	// "version 18.*")
+	Pin string `json:"pin" validate:"required,aptpin"`
+
+	// Priority is the pin priority (e.g. 1001 to allow downgrades, or -1 to
+	// prevent installation)
+	Priority int `json:"priority"`
+}
+
+// Configuration returns the APT preferences entry for this pin.
+func (ap AptPin) Configuration() string {
+	return "Package: " + ap.Package + "\n" +
+		"Pin: " + ap.Pin + "\n" +
+		"Pin-Priority: " + strconv.Itoa(ap.Priority) + "\n"
+}
+
 // AptSource represents an APT source to set up prior to package installation.
 type AptSource struct {
 	// URL of the APT source, e.g. "http://apt.wikimedia.org"
 	URL string `json:"url" validate:"required,httpurl"`
 
+	// Types is a list of archive types to index, "deb" and/or "deb-src"
+	// (default "deb")
+	Types []string `json:"types" validate:"dive,oneof=deb deb-src"`
+
 	// Distribution is the Debian distribution/release name (e.g. buster)
 	Distribution string `json:"distribution" validate:"required,debianrelease"`
 
 	// Components is a list of the source components to index (e.g. main, contrib)
 	Components []string `json:"components" validate:"dive,omitempty,debiancomponent"`
 
+	// Architectures restricts the source to the given architectures (e.g.
+	// amd64, arm64)
+	Architectures []string `json:"architectures" validate:"dive,alphanum"`
+
 	// SignedBy is an encoded set of public keys used to verify the source
-	SignedBy string `json:"signed-by" validate:"omitempty"`
+	SignedBy string `json:"signed-by" validate:"omitempty" interpolate:"false"`
+
+	// SignedByURL is the URL of a keyring used to verify the source. The
+	// keyring is downloaded at build time and verified against SHA256.
+	SignedByURL string `json:"signed-by-url" validate:"omitempty,httpurl,notallowedwith=signed-by"`
+
+	// SHA256 is the hex encoded SHA-256 checksum of the keyring at
+	// SignedByURL
+	SHA256 string `json:"sha256" validate:"requiredwith=signed-by-url,omitempty,sha256"`
 }
 
-// Configuration returns the APT list configuration for this source.
+// Configuration returns the APT list configuration for this source, one line
+// for each of its types.
 func (as AptSource) Configuration() string {
-	cfg := "deb"
+	var options []string
+
+	if len(as.Architectures) > 0 {
+		options = append(options, "arch="+strings.Join(as.Architectures, ","))
+	}
+
+	if as.IsSigned() {
+		options = append(options, "signed-by="+as.KeyringPath())
+	}
+
+	var lines []string
 
-	if as.SignedBy != "" {
-		cfg += " [signed-by=" + as.KeyringPath() + "]"
+	for _, typ := range as.types() {
+		line := typ
+
+		if len(options) > 0 {
+			line += " [" + strings.Join(options, " ") + "]"
+		}
 
+		lines = append(lines, line+" "+strings.Join(append([]string{as.URL, as.Distribution}, as.Components...), " "))
 	}
 
-	return cfg + " " + strings.Join(append([]string{as.URL, as.Distribution}, as.Components...), " ")
+	return strings.Join(lines, "\n")
 }
 
-// KeyringPath returns a unique filename for the [SignedBy] key(s).
+// Deb822 returns the deb822-style configuration for this source. See
+// sources.list(5).
+func (as AptSource) Deb822() string {
+	fields := [][2]string{
+		{"Types", strings.Join(as.types(), " ")},
+		{"URIs", as.URL},
+		{"Suites", as.Distribution},
+		{"Components", strings.Join(as.Components, " ")},
+		{"Architectures", strings.Join(as.Architectures, " ")},
+	}
+
+	if as.IsSigned() {
+		fields = append(fields, [2]string{"Signed-By", as.KeyringPath()})
+	}
+
+	cfg := ""
+
+	for _, field := range fields {
+		if field[1] != "" {
+			cfg += field[0] + ": " + field[1] + "\n"
+		}
+	}
+
+	return cfg
+}
+
+// SourcesPath returns a unique filename for the deb822-style configuration
+// of this source.
+func (as AptSource) SourcesPath() string {
+	sha := sha256.New()
+	sha.Write([]byte(as.Deb822()))
+	return path.Join(AptSourcesDir, "blubber-"+hex.EncodeToString(sha.Sum(nil))[0:12]+".sources")
+}
+
+// IsSigned returns whether keys with which to verify the source were given,
+// either inline or by URL.
+func (as AptSource) IsSigned() bool {
+	return as.SignedBy != "" || as.SignedByURL != ""
+}
+
+// KeyringPath returns a unique filename for the [SignedBy] key(s) or the
+// keyring downloaded from [SignedByURL]. Downloaded keyrings retain the
+// ".gpg" extension of binary keyrings so that APT can tell them apart from
+// ASCII armored ones.
 func (as AptSource) KeyringPath() string {
+	if as.SignedByURL != "" {
+		ext := ".asc"
+
+		if u, err := url.Parse(as.SignedByURL); err == nil && path.Ext(u.Path) == ".gpg" {
+			ext = ".gpg"
+		}
+
+		return path.Join(AptKeyringDir, strings.ToLower(as.SHA256)+ext)
+	}
+
 	sha := sha256.New()
 	sha.Write([]byte(as.SignedBy))
 	return path.Join(AptKeyringDir, hex.EncodeToString(sha.Sum(nil))+".asc")
 }
+
+// keyringInstruction returns an instruction that installs the keyring of
+// this source, writing inline key data or downloading and verifying the
+// keyring from its URL.
+func (as AptSource) keyringInstruction() build.Instruction {
+	if as.SignedByURL != "" {
+		return build.Download{
+			URL:         as.SignedByURL,
+			Checksum:    digest.NewDigestFromEncoded(digest.SHA256, strings.ToLower(as.SHA256)),
+			Destination: as.KeyringPath(),
+			Mode:        os.FileMode(AptFileMode),
+		}
+	}
+
+	return build.File{
+		Path:    as.KeyringPath(),
+		Content: []byte(as.SignedBy),
+		Mode:    os.FileMode(AptFileMode),
+	}
+}
+
+func (as AptSource) types() []string {
+	if len(as.Types) == 0 {
+		return []string{"deb"}
+	}
+
+	return as.Types
+}
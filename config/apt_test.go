@@ -5,11 +5,14 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"gitlab.wikimedia.org/repos/releng/blubber/build"
 	"gitlab.wikimedia.org/repos/releng/blubber/config"
+	"gitlab.wikimedia.org/repos/releng/blubber/util/testtarget"
 )
 
 func TestAptConfigYAML(t *testing.T) {
@@ -196,6 +199,7 @@ func TestAptConfigInstructions(t *testing.T) {
 			URL:    "http://proxy.example:8080",
 			Source: "http://security.debian.org",
 		}},
+		Cache: config.Flag{True: false, Set: true},
 	}
 
 	t.Run("PhasePrivileged", func(t *testing.T) {
@@ -231,6 +235,7 @@ func TestAptConfigInstructions(t *testing.T) {
 					build.Run{"apt-get install -y -t", []string{"baz-backports", "libbaz"}},
 					build.Run{"apt-get install -y", []string{"libfoo", "libbar"}},
 					build.Run{"rm -rf /var/lib/apt/lists/*", []string{}},
+					build.Run{`[ -z "$SOURCE_DATE_EPOCH" ] || rm -rf /var/log/apt/* /var/log/dpkg.log /var/log/alternatives.log /var/cache/debconf/*-old`, []string{}},
 					build.Run{"rm -f", []string{"/etc/apt/apt.conf.d/99blubber-proxies"}},
 				}}},
 			cfg.InstructionsForPhase(build.PhasePrivileged),
@@ -250,6 +255,232 @@ func TestAptConfigInstructions(t *testing.T) {
 	})
 }
 
+func TestAptConfigInstructionsWithCache(t *testing.T) {
+	cfg := config.AptConfig{
+		Packages: config.AptPackages{
+			"default": {"libfoo"},
+		},
+	}
+
+	cacheMounts := []build.RunOption{
+		build.CacheMount{Destination: "/var/cache/apt", Access: "locked", PerBase: true},
+		build.CacheMount{Destination: "/var/lib/apt/lists", Access: "locked", PerBase: true},
+		build.FileMount{Destination: "/etc/apt/apt.conf.d/docker-clean"},
+		build.FileMount{
+			Destination: "/etc/apt/apt.conf.d/99blubber-keep-cache",
+			Content:     []byte(`Binary::apt::APT::Keep-Downloaded-Packages "true";` + "\n"),
+		},
+	}
+
+	assert.True(t, cfg.CacheEnabled())
+
+	assert.Equal(t,
+		[]build.Instruction{
+			build.Env{map[string]string{
+				"DEBIAN_FRONTEND": "noninteractive",
+			}},
+			build.RunAllWithOptions{
+				Runs: []build.Run{
+					{"apt-get update", []string{}},
+					{"apt-get install -y", []string{"libfoo"}},
+					{`[ -z "$SOURCE_DATE_EPOCH" ] || rm -rf /var/log/apt/* /var/log/dpkg.log /var/log/alternatives.log /var/cache/debconf/*-old`, []string{}},
+				},
+				Options: cacheMounts,
+			},
+		},
+		cfg.InstructionsForPhase(build.PhasePrivileged),
+	)
+}
+
+func TestAptConfigInstructionsWithPinsAndLock(t *testing.T) {
+	cfg := config.AptConfig{
+		Packages: config.AptPackages{
+			"default":            {"libfoo=1.0-1", "libbar"},
+			"bookworm-backports": {"libfoo", "libbaz"},
+		},
+		Pins: []config.AptPin{
+			{Package: "nodejs", Pin: "version 18.*", Priority: 1001},
+			{Package: "*", Pin: "release n=bookworm-backports", Priority: 500},
+		},
+		Cache: config.Flag{True: false, Set: true},
+		Lock:  config.Flag{True: true, Set: true},
+	}
+
+	assert.Equal(t,
+		[]build.Instruction{
+			build.Env{map[string]string{
+				"DEBIAN_FRONTEND": "noninteractive",
+			}},
+			build.File{
+				Path: "/etc/apt/preferences.d/99blubber",
+				Content: []byte(strings.Join([]string{
+					"Package: nodejs\nPin: version 18.*\nPin-Priority: 1001\n",
+					"Package: *\nPin: release n=bookworm-backports\nPin-Priority: 500\n",
+				}, "\n")),
+				Mode: os.FileMode(config.AptFileMode),
+			},
+			build.RunAll{[]build.Run{
+				{"apt-get update", []string{}},
+				{"apt-get install -y -t", []string{"bookworm-backports", "libfoo", "libbaz"}},
+				{"apt-get install -y", []string{"libfoo=1.0-1", "libbar"}},
+				{"rm -rf /var/lib/apt/lists/*", []string{}},
+				{`[ -z "$SOURCE_DATE_EPOCH" ] || rm -rf /var/log/apt/* /var/log/dpkg.log /var/log/alternatives.log /var/cache/debconf/*-old`, []string{}},
+			}},
+			build.RunScript{Script: []byte(
+				"set -e\n" +
+					"mkdir -p /usr/share/blubber\n" +
+					"dpkg-query -W -f '${Package}=${Version}\\n' libbar libbaz libfoo > /usr/share/blubber/apt.lock\n",
+			)},
+		},
+		cfg.InstructionsForPhase(build.PhasePrivileged),
+	)
+
+	t.Run("compiles the manifest format verbatim", func(t *testing.T) {
+		_, req := testtarget.Setup(t,
+			testtarget.NewTargets("foo"),
+			func(target *build.Target) {
+				for _, ins := range cfg.InstructionsForPhase(build.PhasePrivileged) {
+					require.NoError(t, ins.Compile(target))
+				}
+			},
+		)
+
+		ops, _ := req.ContainsNExecOps(2)
+		inputs := req.HasValidInputs(ops[1])
+		req.Len(inputs, 2)
+
+		fop, ok := inputs[1].Op.(*pb.Op_File)
+		req.True(ok)
+
+		_, mkfiles := req.ContainsNMkfileActions(fop.File, 1)
+		req.Contains(
+			string(mkfiles[0].Mkfile.Data),
+			"dpkg-query -W -f '${Package}=${Version}\\n' libbar libbaz libfoo > /usr/share/blubber/apt.lock",
+		)
+	})
+}
+
+func TestAptConfigInstructionsWithDeb822Sources(t *testing.T) {
+	sha := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+	cfg := config.AptConfig{
+		SourcesFormat: "deb822",
+		Sources: []config.AptSource{
+			{
+				URL:           "https://packages.example",
+				Types:         []string{"deb", "deb-src"},
+				Distribution:  "bookworm",
+				Components:    []string{"main", "contrib"},
+				Architectures: []string{"amd64", "arm64"},
+				SignedByURL:   "https://packages.example/key.gpg",
+				SHA256:        sha,
+			},
+			{
+				URL:          "http://apt.wikimedia.org",
+				Distribution: "bookworm-wikimedia",
+				Components:   []string{"main"},
+			},
+		},
+		Cache: config.Flag{True: false, Set: true},
+	}
+
+	wikimedia := "Types: deb\n" +
+		"URIs: http://apt.wikimedia.org\n" +
+		"Suites: bookworm-wikimedia\n" +
+		"Components: main\n"
+
+	example := "Types: deb deb-src\n" +
+		"URIs: https://packages.example\n" +
+		"Suites: bookworm\n" +
+		"Components: main contrib\n" +
+		"Architectures: amd64 arm64\n" +
+		"Signed-By: /etc/apt/keyrings/" + sha + ".gpg\n"
+
+	assert.Equal(t, example, cfg.Sources[0].Deb822())
+	assert.Equal(t, wikimedia, cfg.Sources[1].Deb822())
+
+	assert.Equal(t,
+		[]build.Instruction{
+			build.Env{map[string]string{
+				"DEBIAN_FRONTEND": "noninteractive",
+			}},
+			build.Download{
+				URL:         "https://packages.example/key.gpg",
+				Checksum:    digest.Digest("sha256:" + sha),
+				Destination: "/etc/apt/keyrings/" + sha + ".gpg",
+				Mode:        os.FileMode(config.AptFileMode),
+			},
+			build.RunAll{[]build.Run{
+				{"apt-get update", []string{}},
+				{"apt-get install -y", []string{"ca-certificates"}},
+			}},
+			build.File{
+				Path:    "/etc/apt/sources.list.d/blubber-dd812a7d51fb.sources",
+				Content: []byte(example),
+				Mode:    os.FileMode(config.AptFileMode),
+			},
+			build.File{
+				Path:    "/etc/apt/sources.list.d/blubber-ec2588c6534e.sources",
+				Content: []byte(wikimedia),
+				Mode:    os.FileMode(config.AptFileMode),
+			},
+			build.RunAll{[]build.Run{
+				{"rm -rf /var/lib/apt/lists/*", []string{}},
+				{`[ -z "$SOURCE_DATE_EPOCH" ] || rm -rf /var/log/apt/* /var/log/dpkg.log /var/log/alternatives.log /var/cache/debconf/*-old`, []string{}},
+			}},
+		},
+		cfg.InstructionsForPhase(build.PhasePrivileged),
+	)
+}
+
+func TestAptConfigCacheMerge(t *testing.T) {
+	cfg := config.AptConfig{}
+	cfg.Merge(config.AptConfig{Cache: config.Flag{True: false, Set: true}})
+	assert.False(t, cfg.CacheEnabled())
+
+	cfg.Merge(config.AptConfig{})
+	assert.False(t, cfg.CacheEnabled())
+
+	cfg.Merge(config.AptConfig{Cache: config.Flag{True: true, Set: true}})
+	assert.True(t, cfg.CacheEnabled())
+}
+
+func TestAptConfigInstructionsKeepOrder(t *testing.T) {
+	source1 := config.AptSource{
+		URL:          "https://packages.microsoft.com",
+		Distribution: "buster",
+		Components:   []string{"main"},
+	}
+
+	source2 := config.AptSource{
+		URL:          "http://apt.wikimedia.org",
+		Distribution: "buster-wikimedia",
+		Components:   []string{"main"},
+	}
+
+	proxy1 := config.AptProxy{URL: "https://proxy.example:8081"}
+	proxy2 := config.AptProxy{URL: "http://proxy.example:8080"}
+
+	cfg := config.AptConfig{
+		Sources: []config.AptSource{source1, source2, source1},
+		Proxies: []config.AptProxy{proxy1, proxy2, proxy1},
+	}
+
+	ins := cfg.InstructionsForPhase(build.PhasePrivileged)
+
+	assert.Contains(t, ins, build.File{
+		Path:    "/etc/apt/apt.conf.d/99blubber-proxies",
+		Content: []byte("Acquire::https::Proxy \"https://proxy.example:8081\";\nAcquire::http::Proxy \"http://proxy.example:8080\";\n"),
+		Mode:    config.AptFileMode,
+	})
+
+	assert.Contains(t, ins, build.File{
+		Path:    "/etc/apt/sources.list.d/99blubber.list",
+		Content: []byte("deb https://packages.microsoft.com buster main\ndeb http://apt.wikimedia.org buster-wikimedia main\n"),
+		Mode:    config.AptFileMode,
+	})
+}
+
 func TestAptConfigValidation(t *testing.T) {
 	t.Run("packages", func(t *testing.T) {
 		t.Run("ok", func(t *testing.T) {
@@ -428,6 +659,102 @@ func TestAptProxyConfiguration(t *testing.T) {
 	})
 }
 
+func TestAptPinValidation(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		for _, priority := range []int{-1, 0, 1001} {
+			err := config.Validate(config.AptPin{
+				Package:  "*",
+				Pin:      "origin apt.wikimedia.org",
+				Priority: priority,
+			})
+
+			assert.False(t, config.IsValidationError(err))
+		}
+	})
+
+	t.Run("bad", func(t *testing.T) {
+		err := config.Validate(config.AptPin{
+			Package: "*",
+			Pin:     "n=bookworm",
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, strings.Join([]string{
+				`pin: "n=bookworm" is not a valid APT pin (e.g. "release n=bookworm")`,
+			}, "\n"), msg)
+		}
+	})
+}
+
+func TestAptSourceConfiguration(t *testing.T) {
+	source := config.AptSource{
+		URL:           "https://packages.example",
+		Types:         []string{"deb", "deb-src"},
+		Distribution:  "bookworm",
+		Components:    []string{"main"},
+		Architectures: []string{"amd64", "arm64"},
+		SignedBy:      "foo",
+	}
+
+	assert.Equal(t,
+		"deb [arch=amd64,arm64 signed-by=/etc/apt/keyrings/2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae.asc] https://packages.example bookworm main\n"+
+			"deb-src [arch=amd64,arm64 signed-by=/etc/apt/keyrings/2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae.asc] https://packages.example bookworm main",
+		source.Configuration(),
+	)
+}
+
+func TestAptSourceValidation(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		err := config.Validate(config.AptSource{
+			URL:          "https://packages.example",
+			Types:        []string{"deb-src"},
+			Distribution: "bookworm",
+			SignedByURL:  "https://packages.example/key.asc",
+			SHA256:       "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		})
+
+		assert.False(t, config.IsValidationError(err))
+	})
+
+	t.Run("bad", func(t *testing.T) {
+		err := config.Validate(config.AptSource{
+			URL:          "https://packages.example",
+			Types:        []string{"rpm"},
+			Distribution: "bookworm",
+			SignedBy:     "foo",
+			SignedByURL:  "https://packages.example/key.asc",
+			SHA256:       "abc",
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, strings.Join([]string{
+				`types[0]: "rpm" is not one of: deb deb-src`,
+				`signed-by-url: is not allowed if any of field(s) "signed-by" is declared/included`,
+				`sha256: "abc" is not a valid hex encoded SHA-256 checksum`,
+			}, "\n"), msg)
+		}
+	})
+
+	t.Run("missing sha256", func(t *testing.T) {
+		err := config.Validate(config.AptSource{
+			URL:          "https://packages.example",
+			Distribution: "bookworm",
+			SignedByURL:  "https://packages.example/key.asc",
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			assert.Equal(t,
+				`sha256: is required if "signed-by-url" is also set`,
+				config.HumanizeValidationError(err),
+			)
+		}
+	})
+}
+
 func TestAptSourceKeyringPath(t *testing.T) {
 	req := require.New(t)
 
@@ -437,3 +764,19 @@ func TestAptSourceKeyringPath(t *testing.T) {
 
 	req.Equal("/etc/apt/keyrings/2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae.asc", source.KeyringPath())
 }
+
+func TestAptSourceKeyringPathFromURL(t *testing.T) {
+	sha := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+	for url, ext := range map[string]string{
+		"https://packages.example/key.gpg":             ".gpg",
+		"https://packages.example/key.gpg?download=1":  ".gpg",
+		"https://packages.example/key.asc":             ".asc",
+		"https://packages.example/key?format=.gpg":     ".asc",
+		"https://packages.example/key.gpg#fingerprint": ".gpg",
+	} {
+		source := config.AptSource{SignedByURL: url, SHA256: sha}
+
+		assert.Equal(t, "/etc/apt/keyrings/"+sha+ext, source.KeyringPath(), url)
+	}
+}
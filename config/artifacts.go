@@ -1,7 +1,13 @@
 package config
 
 import (
+	"os"
 	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
 
 	"gitlab.wikimedia.org/repos/releng/blubber/build"
 )
@@ -16,10 +22,15 @@ import (
 // binaries or production only source files over into a smaller image that
 // contains only production dependencies.
 type ArtifactsConfig struct {
-	From        string   `json:"from" validate:"required,artifactfrom"`
-	Source      string   `json:"source" validate:"requiredwith=destination,relativelocal"`
-	Destination string   `json:"destination"`
-	Exclude     []string `json:"exclude"`
+	From           string   `json:"from" validate:"required,artifactfrom"`
+	Source         string   `json:"source" validate:"requiredwith=destination,relativelocal"`
+	Destination    string   `json:"destination"`
+	Exclude        []string `json:"exclude"`
+	Include        []string `json:"include"`
+	Mode           string   `json:"mode" validate:"omitempty,filemode"`
+	Owner          string   `json:"owner" validate:"omitempty,owner"`
+	FollowSymlinks Flag     `json:"follow-symlinks"`
+	Parents        bool     `json:"parents"`
 }
 
 // NewArtifactsConfigFromSource creates an local ArtifactsConfig from the
@@ -41,65 +52,45 @@ func (ac ArtifactsConfig) Dependencies() []string {
 
 // Equal returns whether the given ArtifactsConfig is equal to this one.
 func (ac ArtifactsConfig) Equal(other ArtifactsConfig) bool {
-	if ac.From != other.From ||
-		ac.Source != other.Source ||
-		ac.Destination != other.Destination ||
-		len(ac.Exclude) != len(other.Exclude) {
-		return false
-	}
-
-	for i, v := range other.Exclude {
-		if ac.Exclude[i] != v {
-			return false
-		}
-	}
-
-	return true
+	return ac.From == other.From &&
+		ac.Source == other.Source &&
+		ac.Destination == other.Destination &&
+		slices.Equal(ac.Exclude, other.Exclude) &&
+		slices.Equal(ac.Include, other.Include) &&
+		ac.Mode == other.Mode &&
+		ac.Owner == other.Owner &&
+		ac.FollowSymlinks == other.FollowSymlinks &&
+		ac.Parents == other.Parents
 }
 
 // Expand returns the longhand configured artifact and/or the default
 // artifacts for any configured by shorthand notation (i.e. on the `From`
 // field).
 func (ac ArtifactsConfig) Expand(appDirectory string) []ArtifactsConfig {
+	// expanded returns a copy of this artifact with the given source and
+	// destination, retaining all other options
+	expanded := func(source string, destination string) ArtifactsConfig {
+		artifact := ac
+		artifact.Source = source
+		artifact.Destination = destination
+		return artifact
+	}
+
 	// check for shorthand configuration and return its expanded form
 	if ac.From != "" && ac.Source == "" && ac.Destination == "" {
 		if ac.From == LocalArtifactKeyword {
-			return []ArtifactsConfig{
-				{
-					From:        ac.From,
-					Source:      ".",
-					Destination: ".",
-					Exclude:     ac.Exclude,
-				},
-			}
+			return []ArtifactsConfig{expanded(".", ".")}
 		}
 
 		return []ArtifactsConfig{
-			{
-				From:        ac.From,
-				Source:      appDirectory,
-				Destination: appDirectory,
-				Exclude:     ac.Exclude,
-			},
-			{
-				From:        ac.From,
-				Source:      LocalLibPrefix,
-				Destination: LocalLibPrefix,
-				Exclude:     ac.Exclude,
-			},
+			expanded(appDirectory, appDirectory),
+			expanded(LocalLibPrefix, LocalLibPrefix),
 		}
 	}
 
 	// if destination is empty, use the source value
 	if ac.Destination == "" {
-		return []ArtifactsConfig{
-			{
-				From:        ac.From,
-				Source:      ac.Source,
-				Destination: ac.Source,
-				Exclude:     ac.Exclude,
-			},
-		}
+		return []ArtifactsConfig{expanded(ac.Source, ac.Source)}
 	}
 
 	return []ArtifactsConfig{ac}
@@ -113,24 +104,49 @@ func (ac ArtifactsConfig) Expand(appDirectory string) []ArtifactsConfig {
 // In the case of a "local" build context copy, simply return a build.Copy
 // with the configured source and destination. In the case of a variant copy,
 // return a build.CopyFrom instruction for the variant name, source and
-// destination paths.
+// destination paths. Copies with further options are wrapped in a
+// build.CopyWith, and copies with an explicit owner in a build.CopyAs.
 func (ac ArtifactsConfig) InstructionsForPhase(phase build.Phase) []build.Instruction {
 	switch phase {
 	case build.PhaseInstall:
-		copy := build.Copy{[]string{ac.Source}, ac.Destination, ac.Exclude}
+		var ins build.Instruction = build.Copy{[]string{ac.Source}, ac.Destination, ac.Exclude}
 
-		if ac.From == LocalArtifactKeyword {
-			return []build.Instruction{copy}
+		if ac.From != LocalArtifactKeyword {
+			ins = build.CopyFrom{ac.From, ins.(build.Copy)}
+		}
+
+		if options, ok := ac.copyOptions(); ok {
+			ins = build.CopyWith{options, ins}
 		}
 
-		return []build.Instruction{
-			build.CopyFrom{ac.From, copy},
+		// Without a group, the primary group of the user is used
+		if ac.Owner != "" {
+			uid, gid, _ := strings.Cut(ac.Owner, ":")
+			ins = build.CopyAs{uid, gid, ins}
 		}
+
+		return []build.Instruction{ins}
 	}
 
 	return []build.Instruction{}
 }
 
+// copyOptions returns the build options of this artifact beyond its source,
+// destination and exclusions, and whether any are set.
+func (ac ArtifactsConfig) copyOptions() (build.CopyOptions, bool) {
+	mode, _ := parseFileMode(ac.Mode)
+
+	options := build.CopyOptions{
+		Include:          ac.Include,
+		Mode:             mode,
+		Parents:          ac.Parents,
+		PreserveSymlinks: ac.FollowSymlinks.Set && !ac.FollowSymlinks.True,
+	}
+
+	return options, len(options.Include) > 0 || options.Mode != 0 ||
+		options.Parents || options.PreserveSymlinks
+}
+
 // EffectiveDestination returns the destination as a file path that amounts to
 // the location of the artifact after a copy is performed.
 //
@@ -195,3 +211,19 @@ func (ac ArtifactsConfig) NormalizedSource() string {
 func isDir(aPath string) bool {
 	return path.Clean(aPath) == "." || aPath[len(aPath)-1:] == "/"
 }
+
+// parseFileMode parses the given octal file mode (e.g. "0755"). An empty
+// mode is parsed as zero.
+func parseFileMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+
+	if err != nil || parsed > 0o7777 {
+		return 0, errors.Errorf("invalid file mode %q", mode)
+	}
+
+	return os.FileMode(parsed), nil
+}
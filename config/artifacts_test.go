@@ -1,6 +1,7 @@
 package config_test
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -180,6 +181,108 @@ func TestArtifactsConfigInstructions(t *testing.T) {
 	})
 }
 
+func TestArtifactsConfigCopyOptions(t *testing.T) {
+	t.Run("mode and owner", func(t *testing.T) {
+		cfg := config.ArtifactsConfig{
+			From:        "local",
+			Source:      "config/app.conf",
+			Destination: "/etc/app.conf",
+			Mode:        "0640",
+			Owner:       "root:www-data",
+		}
+
+		assert.Equal(t,
+			[]build.Instruction{build.CopyAs{
+				"root", "www-data",
+				build.CopyWith{
+					build.CopyOptions{Mode: os.FileMode(0o640)},
+					build.Copy{[]string{"config/app.conf"}, "/etc/app.conf", nil},
+				},
+			}},
+			cfg.InstructionsForPhase(build.PhaseInstall),
+		)
+	})
+
+	t.Run("owner without group", func(t *testing.T) {
+		cfg := config.ArtifactsConfig{
+			From:        "foo",
+			Source:      "/bin/foo",
+			Destination: "/usr/bin/foo",
+			Owner:       "0",
+		}
+
+		assert.Equal(t,
+			[]build.Instruction{build.CopyAs{
+				"0", "",
+				build.CopyFrom{"foo", build.Copy{[]string{"/bin/foo"}, "/usr/bin/foo", nil}},
+			}},
+			cfg.InstructionsForPhase(build.PhaseInstall),
+		)
+	})
+
+	t.Run("include, symlinks and parents", func(t *testing.T) {
+		cfg := config.ArtifactsConfig{
+			From:           "foo",
+			Source:         "/srv/app/lib",
+			Destination:    "/srv/app/",
+			Include:        []string{"**/*.so"},
+			FollowSymlinks: config.Flag{True: false, Set: true},
+			Parents:        true,
+		}
+
+		assert.Equal(t,
+			[]build.Instruction{build.CopyWith{
+				build.CopyOptions{
+					Include:          []string{"**/*.so"},
+					Parents:          true,
+					PreserveSymlinks: true,
+				},
+				build.CopyFrom{"foo", build.Copy{[]string{"/srv/app/lib"}, "/srv/app/", nil}},
+			}},
+			cfg.InstructionsForPhase(build.PhaseInstall),
+		)
+	})
+
+	t.Run("owner overrides the lives user", func(t *testing.T) {
+		cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    variants:
+      production:
+        copies:
+          - from: local
+            source: entrypoint.sh
+            destination: /usr/local/bin/entrypoint.sh
+            mode: "0755"
+            owner: root
+          - from: local
+            source: app.py`))
+
+		if !assert.NoError(t, err) || !assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "production")) {
+			return
+		}
+
+		variant, err := config.GetVariant(cfg, "production")
+
+		if assert.NoError(t, err) {
+			ins := variant.InstructionsForPhase(build.PhaseInstall)
+
+			assert.Contains(t, ins, build.CopyAs{
+				"root", "",
+				build.CopyWith{
+					build.CopyOptions{Mode: os.FileMode(0o755)},
+					build.Copy{[]string{"entrypoint.sh"}, "/usr/local/bin/entrypoint.sh", nil},
+				},
+			})
+
+			assert.Contains(t, ins, build.CopyAs{
+				"$LIVES_UID", "$LIVES_GID",
+				build.Copy{[]string{"app.py"}, "app.py", nil},
+			})
+		}
+	})
+}
+
 func TestArtifactsConfigValidation(t *testing.T) {
 	t.Run("from", func(t *testing.T) {
 		t.Run("ok", func(t *testing.T) {
@@ -369,6 +472,72 @@ func TestArtifactsConfigValidation(t *testing.T) {
 			assert.Equal(t, `copies: cannot contain duplicates`, msg)
 		}
 	})
+
+	t.Run("mode", func(t *testing.T) {
+		t.Run("ok", func(t *testing.T) {
+			_, err := config.ReadYAMLConfig([]byte(`---
+        version: v4
+        variants:
+          foo:
+            copies:
+              - from: local
+                source: foo
+                mode: "0755"`))
+
+			assert.False(t, config.IsValidationError(err))
+		})
+
+		t.Run("bad", func(t *testing.T) {
+			_, err := config.ReadYAMLConfig([]byte(`---
+        version: v4
+        variants:
+          foo:
+            copies:
+              - from: local
+                source: foo
+                mode: "0999"`))
+
+			if assert.True(t, config.IsValidationError(err)) {
+				msg := config.HumanizeValidationError(err)
+
+				assert.Equal(t, `mode: "0999" is not a valid octal file mode (e.g. "0755")`, msg)
+			}
+		})
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		t.Run("ok", func(t *testing.T) {
+			for _, owner := range []string{"root", "root:root", "123:456", "www-data"} {
+				_, err := config.ReadYAMLConfig([]byte(`---
+        version: v4
+        variants:
+          foo:
+            copies:
+              - from: local
+                source: foo
+                owner: "` + owner + `"`))
+
+				assert.False(t, config.IsValidationError(err), owner)
+			}
+		})
+
+		t.Run("bad", func(t *testing.T) {
+			_, err := config.ReadYAMLConfig([]byte(`---
+        version: v4
+        variants:
+          foo:
+            copies:
+              - from: local
+                source: foo
+                owner: "root:"`))
+
+			if assert.True(t, config.IsValidationError(err)) {
+				msg := config.HumanizeValidationError(err)
+
+				assert.Equal(t, `owner: "root:" is not a valid owner (e.g. "root" or "root:root")`, msg)
+			}
+		})
+	})
 }
 
 func TestArtifactsEffectiveDestination(t *testing.T) {
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+)
+
+// AssembleConfig holds configuration for assembling a minimal
+// (distroless-style) root filesystem from another variant rather than
+// inheriting a full operating system from a base image.
+type AssembleConfig struct {
+	// From is the variant from which files are assembled. It must provide a
+	// shell and ldd.
+	From string `json:"from" validate:"omitempty,variantref"`
+
+	// Paths is a list of additional absolute paths to copy. The application
+	// directory and LocalLibPrefix are always copied.
+	Paths []string `json:"paths" validate:"dive,abspath"`
+
+	// Binaries is a list of absolute paths of binaries to copy along with the
+	// shared libraries they link against
+	Binaries []string `json:"binaries" validate:"dive,abspath"`
+
+	// CACertificates copies the CA certificates bundle (default true)
+	CACertificates Flag `json:"ca-certificates"`
+
+	appDirectory string
+	users        []string
+}
+
+// AssembleCACertificatePaths are the locations of the CA certificates bundle
+// on Debian, Alpine and RPM based distributions, respectively.
+var AssembleCACertificatePaths = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/ssl/cert.pem",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+}
+
+// Dependencies returns variant dependencies.
+func (ac AssembleConfig) Dependencies() []string {
+	if ac.From == "" {
+		return []string{}
+	}
+
+	return []string{ac.From}
+}
+
+// Merge takes another AssembleConfig and merges its fields into this one's.
+func (ac *AssembleConfig) Merge(ac2 AssembleConfig) {
+	if ac2.From != "" {
+		ac.From = ac2.From
+	}
+
+	if ac2.Paths != nil {
+		ac.Paths = append(ac.Paths, ac2.Paths...)
+	}
+
+	if ac2.Binaries != nil {
+		ac.Binaries = append(ac.Binaries, ac2.Binaries...)
+	}
+
+	ac.CACertificates.Merge(ac2.CACertificates)
+}
+
+// Expand returns a version of this AssembleConfig that also copies the given
+// application directory and the passwd and group entries of the given users.
+func (ac AssembleConfig) Expand(appDirectory string, users ...string) AssembleConfig {
+	ac.appDirectory = appDirectory
+	ac.users = users
+	return ac
+}
+
+// InstructionsForPhase injects build instructions that assemble the root
+// filesystem.
+//
+// # PhaseInstall
+//
+// Copies the application directory, LocalLibPrefix, configured paths and
+// binaries along with their shared libraries, CA certificates, and the
+// passwd and group entries of the users given to [AssembleConfig.Expand]
+// (i.e. the lives and runs users of the assembling variant) from the variant
+// given by From.
+func (ac AssembleConfig) InstructionsForPhase(phase build.Phase) []build.Instruction {
+	if ac.From == "" {
+		return []build.Instruction{}
+	}
+
+	switch phase {
+	case build.PhaseInstall:
+		paths := []string{}
+
+		if ac.appDirectory != "" {
+			paths = append(paths, ac.appDirectory)
+		}
+
+		paths = append(paths, LocalLibPrefix)
+		paths = append(paths, ac.Paths...)
+
+		optional := []string{}
+
+		if ac.CACertificatesEnabled() {
+			optional = append(optional, AssembleCACertificatePaths...)
+		}
+
+		return []build.Instruction{
+			build.Assemble{
+				From:     ac.From,
+				Paths:    paths,
+				Optional: optional,
+				Binaries: ac.Binaries,
+				Users:    ac.users,
+			},
+		}
+	}
+
+	return []build.Instruction{}
+}
+
+// CACertificatesEnabled returns whether the CA certificates bundle should be
+// copied. Copying is enabled unless explicitly disabled.
+func (ac AssembleConfig) CACertificatesEnabled() bool {
+	return !ac.CACertificates.Set || ac.CACertificates.True
+}
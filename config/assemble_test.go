@@ -0,0 +1,131 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+func TestAssembleConfigYAML(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    variants:
+      build:
+        base: foo
+        apt: { packages: [libfoo] }
+      production:
+        assemble:
+          from: build
+          paths: [/etc/foo]
+          binaries: [/usr/bin/foo]
+          ca-certificates: false`))
+
+	require.NoError(t, err)
+
+	require.NoError(t, config.ExpandIncludesAndCopies(cfg, "production"))
+
+	variant, err := config.GetVariant(cfg, "production")
+	require.NoError(t, err)
+
+	assert.Equal(t,
+		config.AssembleConfig{
+			From:           "build",
+			Paths:          []string{"/etc/foo"},
+			Binaries:       []string{"/usr/bin/foo"},
+			CACertificates: config.Flag{True: false, Set: true},
+		},
+		variant.Assemble,
+	)
+
+	assert.Equal(t, []string{"build"}, variant.Dependencies())
+}
+
+func TestAssembleConfigInstructions(t *testing.T) {
+	cfg := config.AssembleConfig{
+		From:     "build",
+		Paths:    []string{"/etc/foo"},
+		Binaries: []string{"/usr/bin/foo"},
+	}
+
+	t.Run("PhaseInstall", func(t *testing.T) {
+		assert.Equal(t,
+			[]build.Instruction{
+				build.Assemble{
+					From:  "build",
+					Paths: []string{"/srv/app", "/opt/lib", "/etc/foo"},
+					Optional: []string{
+						"/etc/ssl/certs/ca-certificates.crt",
+						"/etc/ssl/cert.pem",
+						"/etc/pki/tls/certs/ca-bundle.crt",
+					},
+					Binaries: []string{"/usr/bin/foo"},
+					Users:    []string{"somebody", "runuser"},
+				},
+			},
+			cfg.Expand("/srv/app", "somebody", "runuser").InstructionsForPhase(build.PhaseInstall),
+		)
+	})
+
+	t.Run("PhaseInstall without CA certificates", func(t *testing.T) {
+		cfg := cfg
+		cfg.CACertificates = config.Flag{True: false, Set: true}
+
+		ins := cfg.Expand("/srv/app").InstructionsForPhase(build.PhaseInstall)
+
+		require.Len(t, ins, 1)
+		assert.Empty(t, ins[0].(build.Assemble).Optional)
+	})
+
+	t.Run("without from", func(t *testing.T) {
+		assert.Empty(t, config.AssembleConfig{}.InstructionsForPhase(build.PhaseInstall))
+	})
+
+	t.Run("other phases", func(t *testing.T) {
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhasePrivileged))
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhasePrivilegeDropped))
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhasePreInstall))
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhasePostInstall))
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhaseFinal))
+	})
+}
+
+func TestAssembleConfigValidation(t *testing.T) {
+	t.Run("from", func(t *testing.T) {
+		_, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    variants:
+      build: {}
+      production:
+        assemble:
+          from: nonexistent`))
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, `from: references an unknown variant "nonexistent"`, msg)
+		}
+	})
+
+	t.Run("paths", func(t *testing.T) {
+		_, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    variants:
+      build: {}
+      production:
+        assemble:
+          from: build
+          paths: [foo/bar]`))
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, `paths[0]: "foo/bar" is not a valid absolute non-root path`, msg)
+		}
+	})
+}
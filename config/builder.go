@@ -9,8 +9,8 @@ import (
 // BuilderConfig contains configuration for the definition of an arbitrary
 // build command and the files required to successfully execute the command.
 type BuilderConfig struct {
-	Command      BuilderCommand     `json:"command" validate:"notallowedwith=script"`
-	Script       string             `json:"script" validate:"notallowedwith=command"`
+	Command      BuilderCommand     `json:"command" validate:"notallowedwith=script" interpolate:"false"`
+	Script       string             `json:"script" validate:"notallowedwith=command" interpolate:"false"`
 	Requirements RequirementsConfig `json:"requirements" validate:"omitempty,uniqueartifacts,dive"`
 	Mounts       MountsConfig       `json:"mounts" validate:"omitempty,unique,dive"`
 	Caches       CachesConfig       `json:"caches" validate:"omitempty,unique,dive"`
@@ -38,7 +38,7 @@ func (ccs *CachesConfig) UnmarshalJSON(unmarshal []byte) error {
 // CacheConfig holds configuration for a single cache mount to be added to a
 // [BuilderConfig] during execution.
 type CacheConfig struct {
-	Destination string `json:"destination" validate:"required"`
+	Destination string `json:"destination" validate:"required" interpolate:"deferred"`
 	ID          string `json:"id"`
 	Access      string `json:"access"`
 }
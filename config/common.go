@@ -8,8 +8,10 @@ import (
 // and each configured variant.
 type CommonConfig struct {
 	Base       string          `json:"base" validate:"omitempty,imageref"`
-	Arguments  ArgumentsConfig `json:"arguments" validate:"envvars"`
+	Arguments  ArgumentsConfig `json:"arguments" validate:"envvars" interpolate:"false"`
 	Apt        AptConfig       `json:"apt"`
+	Apk        ApkConfig       `json:"apk"`
+	Dnf        DnfConfig       `json:"dnf"`
 	Builders   BuildersConfig  `json:"builders" validate:"uniquetypesexcept=config.BuilderConfig,notallowedwith=node php python builder,dive"`
 	Node       NodeConfig      `json:"node"`
 	Php        PhpConfig       `json:"php"`
@@ -17,7 +19,10 @@ type CommonConfig struct {
 	Builder    BuilderConfig   `json:"builder"`
 	Lives      LivesConfig     `json:"lives"`
 	Runs       RunsConfig      `json:"runs"`
-	EntryPoint []string        `json:"entrypoint"`
+	Slim       SlimConfig      `json:"slim"`
+	EntryPoint []string        `json:"entrypoint" interpolate:"runtime"`
+	Platforms  PlatformsConfig `json:"platforms" validate:"dive,keys,platform,endkeys,omitempty"`
+	Context    ContextConfig   `json:"context"`
 }
 
 // Dependencies returns variant dependencies.
@@ -28,6 +33,7 @@ func (cc *CommonConfig) Dependencies() []string {
 		cc.Python,
 		cc.Builder,
 		cc.Builders,
+		cc.Platforms,
 	}
 
 	deps := []string{}
@@ -46,6 +52,8 @@ func (cc *CommonConfig) Merge(cc2 CommonConfig) {
 
 	cc.Arguments.Merge(cc2.Arguments)
 	cc.Apt.Merge(cc2.Apt)
+	cc.Apk.Merge(cc2.Apk)
+	cc.Dnf.Merge(cc2.Dnf)
 	cc.Builders.Merge(cc2.Builders)
 	cc.Node.Merge(cc2.Node)
 	cc.Php.Merge(cc2.Php)
@@ -53,41 +61,49 @@ func (cc *CommonConfig) Merge(cc2 CommonConfig) {
 	cc.Builder.Merge(cc2.Builder)
 	cc.Lives.Merge(cc2.Lives)
 	cc.Runs.Merge(cc2.Runs)
+	cc.Slim.Merge(cc2.Slim)
 
 	if cc2.EntryPoint != nil {
 		cc.EntryPoint = cc2.EntryPoint
 	}
+
+	cc.Platforms.Merge(cc2.Platforms)
+	cc.Context.Merge(cc2.Context)
 }
 
 // PhaseCompileableConfig returns all fields that implement
 // build.PhaseCompileable in the order that their instructions should be
 // injected.
 func (cc *CommonConfig) PhaseCompileableConfig() []build.PhaseCompileable {
-	return []build.PhaseCompileable{
-		cc.Arguments,
-		cc.Apt,
-		cc.Builders,
-		cc.Node,
-		cc.Php,
-		cc.Python,
-		cc.Builder,
-		cc.Lives,
-		cc.Runs,
+	fields := cc.phaseCompileableFields()
+	compileables := make([]build.PhaseCompileable, len(fields))
+
+	for i, field := range fields {
+		compileables[i] = field.PhaseCompileable
 	}
+
+	return compileables
 }
 
 // InstructionsForPhase injects instructions into the given build phase for
 // each member field that supports it.
 func (cc *CommonConfig) InstructionsForPhase(phase build.Phase) []build.Instruction {
-	instructions := []build.Instruction{}
+	return cc.SectionsForPhase(phase).Instructions()
+}
+
+// SectionsForPhase returns the instructions for the given build phase of each
+// member field that supports it, named after the field from which they
+// originate.
+func (cc *CommonConfig) SectionsForPhase(phase build.Phase) Sections {
+	sections := Sections{}
 
 	if !cc.IsScratch() {
-		for _, phaseCompileable := range cc.PhaseCompileableConfig() {
-			instructions = append(instructions, phaseCompileable.InstructionsForPhase(phase)...)
+		for _, field := range cc.phaseCompileableFields() {
+			sections = sections.appendSection(field.name, field.InstructionsForPhase(phase)...)
 		}
 	}
 
-	return instructions
+	return sections
 }
 
 // IsScratch returns whether this is configuration for a scratch image (no
@@ -95,3 +111,25 @@ func (cc *CommonConfig) InstructionsForPhase(phase build.Phase) []build.Instruct
 func (cc *CommonConfig) IsScratch() bool {
 	return cc.Base == ""
 }
+
+type namedPhaseCompileable struct {
+	name string
+	build.PhaseCompileable
+}
+
+func (cc *CommonConfig) phaseCompileableFields() []namedPhaseCompileable {
+	return []namedPhaseCompileable{
+		{"arguments", cc.Arguments},
+		{"apt", cc.Apt},
+		{"apk", cc.Apk},
+		{"dnf", cc.Dnf},
+		{"builders", cc.Builders},
+		{"node", cc.Node},
+		{"php", cc.Php},
+		{"python", cc.Python},
+		{"builder", cc.Builder},
+		{"lives", cc.Lives},
+		{"runs", cc.Runs},
+		{"slim", cc.Slim},
+	}
+}
@@ -6,6 +6,7 @@ package config
 // Config holds the root fields of a Blubber configuration.
 type Config struct {
 	CommonConfig  `json:",inline"`
+	Imports       ImportsConfig            `json:"imports" validate:"dive"`
 	Variants      map[string]VariantConfig `json:"variants" validate:"variants,dive"`
 	VersionConfig `json:",inline"`
 
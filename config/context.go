@@ -0,0 +1,19 @@
+package config
+
+// ContextConfig holds configuration for filtering the files of the local
+// build context that are sent to BuildKit.
+type ContextConfig struct {
+	// Include is a list of patterns of files to include. If empty, all files
+	// not otherwise excluded are included.
+	Include []string `json:"include" validate:"dive,required"`
+
+	// Exclude is a list of patterns of files to exclude, using the same
+	// syntax as .dockerignore files
+	Exclude []string `json:"exclude" validate:"dive,required"`
+}
+
+// Merge takes another ContextConfig and appends its patterns to this one's.
+func (cc *ContextConfig) Merge(cc2 ContextConfig) {
+	cc.Include = append(cc.Include, cc2.Include...)
+	cc.Exclude = append(cc.Exclude, cc2.Exclude...)
+}
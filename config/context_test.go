@@ -0,0 +1,51 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+func TestContextConfigYAML(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    context:
+      exclude: ["**/node_modules"]
+    variants:
+      api:
+        context:
+          include: [services/api, lib]
+          exclude: [services/api/tests]`))
+
+	if assert.NoError(t, err) && assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "api")) {
+		variant, err := config.GetVariant(cfg, "api")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"services/api", "lib"}, variant.Context.Include)
+			assert.Equal(t, []string{"**/node_modules", "services/api/tests"}, variant.Context.Exclude)
+		}
+	}
+}
+
+func TestContextConfigValidation(t *testing.T) {
+	t.Run("include", func(t *testing.T) {
+		t.Run("ok", func(t *testing.T) {
+			err := config.Validate(config.ContextConfig{
+				Include: []string{"lib"},
+			})
+
+			assert.False(t, config.IsValidationError(err))
+		})
+
+		t.Run("empty pattern", func(t *testing.T) {
+			err := config.Validate(config.ContextConfig{
+				Include: []string{""},
+			})
+
+			assert.True(t, config.IsValidationError(err))
+		})
+	})
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// fieldByPath returns the field of the given struct value at the given
+// dot-separated path of JSON field names (e.g. "apt.packages").
+func fieldByPath(v reflect.Value, path string) (reflect.Value, bool) {
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+
+		field, ok := fieldByJSONName(v, name)
+
+		if !ok || !field.IsValid() {
+			return reflect.Value{}, false
+		}
+
+		v = field
+	}
+
+	return v, true
+}
+
+// isRemovable returns whether values can be removed from the given field,
+// which is the case for lists of strings and for maps of strings or lists of
+// strings.
+func isRemovable(field reflect.Value) bool {
+	t := field.Type()
+
+	isStrings := func(t reflect.Type) bool {
+		return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		return isStrings(t)
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String &&
+			(t.Elem().Kind() == reflect.String || isStrings(t.Elem()))
+	}
+
+	return false
+}
+
+// resetPaths sets the configuration at each of the given paths to its zero
+// value.
+func (vc *VariantConfig) resetPaths(paths []string) {
+	for _, path := range paths {
+		if field, ok := fieldByPath(reflect.ValueOf(vc).Elem(), path); ok {
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+}
+
+// removeValues removes the given values from the configuration at each of
+// the given paths. Values are removed from lists, and keys from maps of
+// strings. For maps of lists (e.g. "apt.packages"), values are removed from
+// each list and lists left empty are removed. The configuration is copied
+// rather than modified in place so that values shared with other
+// configuration are left untouched.
+func (vc *VariantConfig) removeValues(values map[string][]string) {
+	for path, remove := range values {
+		field, ok := fieldByPath(reflect.ValueOf(vc).Elem(), path)
+
+		if !ok || !isRemovable(field) || field.IsNil() {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.Slice:
+			field.Set(withoutValues(field, remove))
+
+		case reflect.Map:
+			copied := reflect.MakeMapWithSize(field.Type(), field.Len())
+			iter := field.MapRange()
+
+			for iter.Next() {
+				value := iter.Value()
+
+				if value.Kind() == reflect.String {
+					if slices.Contains(remove, iter.Key().String()) {
+						continue
+					}
+				} else if !value.IsNil() {
+					value = withoutValues(value, remove)
+
+					if value.Len() == 0 {
+						continue
+					}
+				}
+
+				copied.SetMapIndex(iter.Key(), value)
+			}
+
+			field.Set(copied)
+		}
+	}
+}
+
+// withoutValues returns a copy of the given slice of strings without the
+// given values.
+func withoutValues(slice reflect.Value, values []string) reflect.Value {
+	copied := reflect.MakeSlice(slice.Type(), 0, slice.Len())
+
+	for i := 0; i < slice.Len(); i++ {
+		if !slices.Contains(values, slice.Index(i).String()) {
+			copied = reflect.Append(copied, slice.Index(i))
+		}
+	}
+
+	return copied
+}
@@ -0,0 +1,129 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+func TestVariantConfigReset(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    apt:
+      packages: [gcc, curl]
+    runs:
+      insecurely: true
+      environment:
+        DEBUG: "1"
+    variants:
+      build:
+        builders:
+          - custom:
+              command: [make]
+        copies: [local]
+      production:
+        includes: [build]
+        reset: [apt.packages, builders, copies, runs.insecurely, runs.environment]
+        apt:
+          packages: [ca-certificates]
+        runs:
+          environment:
+            FOO: bar`))
+
+	if !assert.NoError(t, err) || !assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "production")) {
+		return
+	}
+
+	variant, err := config.GetVariant(cfg, "production")
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"ca-certificates"}, variant.Apt.Packages["default"])
+		assert.Empty(t, variant.Builders)
+		assert.Empty(t, variant.Copies)
+		assert.False(t, variant.Runs.Insecurely.Set)
+		assert.Equal(t, map[string]string{"FOO": "bar"}, variant.Runs.Environment)
+	}
+
+	assert.Equal(t, []string{"gcc", "curl"}, cfg.Apt.Packages["default"])
+}
+
+func TestVariantConfigRemove(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    apt:
+      packages:
+        default: [gcc, curl]
+        bookworm-backports: [gcc]
+    runs:
+      environment:
+        DEBUG: "1"
+        FOO: bar
+    variants:
+      build:
+        python:
+          requirements: [requirements.txt]
+        slim:
+          paths: [/tmp/foo, /tmp/bar]
+      production:
+        includes: [build]
+        remove:
+          apt.packages: [gcc]
+          runs.environment: [DEBUG]
+          slim.paths: [/tmp/foo]`))
+
+	if !assert.NoError(t, err) || !assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "production")) {
+		return
+	}
+
+	variant, err := config.GetVariant(cfg, "production")
+
+	if assert.NoError(t, err) {
+		assert.Equal(t,
+			config.AptPackages{"default": {"curl"}},
+			variant.Apt.Packages,
+		)
+		assert.Equal(t, map[string]string{"FOO": "bar"}, variant.Runs.Environment)
+		assert.Equal(t, []string{"/tmp/bar"}, variant.Slim.Paths)
+	}
+
+	assert.Equal(t, map[string]string{"DEBUG": "1", "FOO": "bar"}, cfg.Runs.Environment)
+}
+
+func TestVariantConfigDirectivesValidation(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		err := config.Validate(config.VariantConfig{
+			Reset:  []string{"apt.packages", "lives.as", "copies"},
+			Remove: map[string][]string{"runs.environment": {"FOO"}},
+		})
+
+		assert.False(t, config.IsValidationError(err))
+	})
+
+	t.Run("unknown reset path", func(t *testing.T) {
+		err := config.Validate(config.VariantConfig{
+			Reset: []string{"apt.foo"},
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, `reset[0]: "apt.foo" is not a known configuration path`, msg)
+		}
+	})
+
+	t.Run("unremovable path", func(t *testing.T) {
+		err := config.Validate(config.VariantConfig{
+			Remove: map[string][]string{"copies": {"local"}},
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, `remove[copies]: "copies" is not a known list or map configuration path`, msg)
+		}
+	})
+}
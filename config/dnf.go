@@ -0,0 +1,193 @@
+package config
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+)
+
+// DnfConfig represents configuration pertaining to package installation on
+// RPM based images (e.g. Fedora or UBI) using dnf or microdnf.
+type DnfConfig struct {
+	// Command is the package manager to use, either "dnf" (the default) or
+	// "microdnf"
+	Command string `json:"command" validate:"omitempty,oneof=dnf microdnf"`
+
+	// Packages is a list of the desired packages, optionally including a
+	// version (e.g. "nginx-1.20.1" or "nginx-1:1.20.1-14.el9")
+	Packages []string `json:"packages" validate:"dive,rpmpackage"`
+
+	// Repositories provides additional repositories for packages
+	Repositories []DnfRepository `json:"repositories" validate:"dive"`
+
+	// Proxy is an HTTP/HTTPS proxy to use during package installation
+	Proxy string `json:"proxy" validate:"omitempty,httpurl"`
+}
+
+const (
+	// DnfDefaultCommand is the package manager used when none is configured.
+	DnfDefaultCommand = "dnf"
+
+	// DnfRepositoryConfigurationPath is the file that configuration will be
+	// written to for each defined repository.
+	DnfRepositoryConfigurationPath = "/etc/yum.repos.d/99blubber.repo"
+
+	// DnfKeyringDir is the directory where [DnfRepository.SignedBy] key data
+	// will be written.
+	DnfKeyringDir = "/etc/pki/rpm-gpg"
+
+	// DnfFileMode is the default file mode of dnf configuration files.
+	DnfFileMode = os.FileMode(0o644)
+
+	// DnfCacheAndLogFiles are removed after package installation as they
+	// contain metadata caches and installation timestamps and would otherwise
+	// bloat the image and make image layers non-reproducible.
+	DnfCacheAndLogFiles = "/var/cache/dnf /var/cache/yum /var/log/dnf*.log /var/log/hawkey.log"
+)
+
+// Merge takes another DnfConfig and combines the packages and repositories
+// declared within with those of this DnfConfig.
+func (dnf *DnfConfig) Merge(dnf2 DnfConfig) {
+	if dnf2.Command != "" {
+		dnf.Command = dnf2.Command
+	}
+
+	if dnf2.Packages != nil {
+		dnf.Packages = append(dnf.Packages, dnf2.Packages...)
+	}
+
+	if dnf2.Repositories != nil {
+		dnf.Repositories = append(dnf.Repositories, dnf2.Repositories...)
+	}
+
+	if dnf2.Proxy != "" {
+		dnf.Proxy = dnf2.Proxy
+	}
+}
+
+// InstructionsForPhase injects build instructions that will install the
+// declared packages during the privileged phase.
+//
+// # PhasePrivileged
+//
+// Writes repository configuration and signing keys, installs configured
+// packages, and cleans up metadata caches and logs.
+func (dnf DnfConfig) InstructionsForPhase(phase build.Phase) []build.Instruction {
+	ins := []build.Instruction{}
+
+	if len(dnf.Packages) > 0 || len(dnf.Repositories) > 0 {
+		switch phase {
+		case build.PhasePrivileged:
+			// Configure repositories, sorted and deduplicated so that the
+			// generated file is the same regardless of the order in which
+			// variants were merged
+			var repositories []string
+			keyrings := map[string]string{}
+			for _, repo := range dnf.sortedRepositories() {
+				repositories = append(repositories, repo.Configuration())
+
+				if repo.SignedBy != "" {
+					keyrings[repo.KeyringPath()] = repo.SignedBy
+				}
+			}
+
+			repositories = slices.Compact(repositories)
+
+			for _, keyringPath := range slices.Sorted(maps.Keys(keyrings)) {
+				ins = append(ins, build.File{
+					Path:    keyringPath,
+					Content: []byte(keyrings[keyringPath]),
+					Mode:    DnfFileMode,
+				})
+			}
+
+			if len(repositories) > 0 {
+				ins = append(ins, build.File{
+					Path:    DnfRepositoryConfigurationPath,
+					Content: []byte(strings.Join(repositories, "\n")),
+					Mode:    DnfFileMode,
+				})
+			}
+
+			if len(dnf.Packages) > 0 {
+				command := cmp.Or(dnf.Command, DnfDefaultCommand)
+				install := build.Run{command + " install -y", dnf.Packages}
+
+				if dnf.Proxy != "" {
+					install = build.Run{
+						command + " install -y --setopt=proxy=%s",
+						append([]string{dnf.Proxy}, dnf.Packages...),
+					}
+				}
+
+				ins = append(ins, build.RunAll{[]build.Run{
+					install,
+					{command + " clean all", []string{}},
+					{"rm -rf " + DnfCacheAndLogFiles, []string{}},
+				}})
+			}
+		}
+	}
+
+	return ins
+}
+
+// sortedRepositories returns a copy of the configured repositories ordered
+// by name and URL.
+func (dnf DnfConfig) sortedRepositories() []DnfRepository {
+	return slices.SortedStableFunc(slices.Values(dnf.Repositories), func(a, b DnfRepository) int {
+		return cmp.Or(
+			cmp.Compare(a.Name, b.Name),
+			cmp.Compare(a.URL, b.URL),
+			cmp.Compare(a.Configuration(), b.Configuration()),
+		)
+	})
+}
+
+// DnfRepository represents a dnf repository to set up prior to package
+// installation.
+type DnfRepository struct {
+	// Name is the unique repository ID (e.g. "epel")
+	Name string `json:"name" validate:"required,rpmrepoid"`
+
+	// URL of the repository, i.e. its baseurl
+	URL string `json:"url" validate:"required,httpurl"`
+
+	// SignedBy is an ASCII armored set of public keys used to verify packages
+	// from the repository
+	SignedBy string `json:"signed-by" validate:"omitempty" interpolate:"false"`
+}
+
+// Configuration returns the dnf repository configuration for this
+// repository.
+func (dr DnfRepository) Configuration() string {
+	cfg := "[" + dr.Name + "]\n" +
+		"name=" + dr.Name + "\n" +
+		"baseurl=" + dr.URL + "\n" +
+		"enabled=1\n"
+
+	// Disable signature checks explicitly for unsigned repositories, as the
+	// default depends on the distribution's dnf configuration
+	if dr.SignedBy != "" {
+		cfg += "gpgcheck=1\n" +
+			"gpgkey=file://" + dr.KeyringPath() + "\n"
+	} else {
+		cfg += "gpgcheck=0\n"
+	}
+
+	return cfg
+}
+
+// KeyringPath returns a unique filename for the [SignedBy] key(s).
+func (dr DnfRepository) KeyringPath() string {
+	sha := sha256.New()
+	sha.Write([]byte(dr.SignedBy))
+	return path.Join(DnfKeyringDir, "RPM-GPG-KEY-blubber-"+hex.EncodeToString(sha.Sum(nil)))
+}
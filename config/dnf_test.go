@@ -0,0 +1,183 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+func TestDnfConfigYAML(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    dnf:
+      command: microdnf
+      packages: [libfoo, libbar]
+      repositories:
+        - name: epel
+          url: https://dl.fedoraproject.org/pub/epel/9/Everything/x86_64/
+          signed-by: foo
+      proxy: http://proxy.example:8080
+    variants:
+      build:
+        dnf:
+          packages: [libfoo-devel]`))
+
+	require.NoError(t, err)
+
+	assert.Equal(t, "microdnf", cfg.Dnf.Command)
+	assert.Equal(t, []string{"libfoo", "libbar"}, cfg.Dnf.Packages)
+	assert.Equal(t,
+		[]config.DnfRepository{{
+			Name:     "epel",
+			URL:      "https://dl.fedoraproject.org/pub/epel/9/Everything/x86_64/",
+			SignedBy: "foo",
+		}},
+		cfg.Dnf.Repositories,
+	)
+	assert.Equal(t, "http://proxy.example:8080", cfg.Dnf.Proxy)
+
+	require.NoError(t, config.ExpandIncludesAndCopies(cfg, "build"))
+
+	variant, err := config.GetVariant(cfg, "build")
+
+	require.NoError(t, err)
+	assert.Equal(t, "microdnf", variant.Dnf.Command)
+	assert.Equal(t, []string{"libfoo", "libbar", "libfoo-devel"}, variant.Dnf.Packages)
+}
+
+func TestDnfConfigInstructions(t *testing.T) {
+	cfg := config.DnfConfig{
+		Packages: []string{"libfoo", "libbar-1.2.3"},
+		Repositories: []config.DnfRepository{
+			{Name: "foo", URL: "https://rpm.example/foo", SignedBy: "foo"},
+			{Name: "bar", URL: "https://rpm.example/bar"},
+		},
+	}
+
+	keyringPath := "/etc/pki/rpm-gpg/RPM-GPG-KEY-blubber-2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+	t.Run("PhasePrivileged", func(t *testing.T) {
+		assert.Equal(t,
+			[]build.Instruction{
+				build.File{
+					Path:    keyringPath,
+					Content: []byte("foo"),
+					Mode:    config.DnfFileMode,
+				},
+				build.File{
+					Path: "/etc/yum.repos.d/99blubber.repo",
+					Content: []byte(strings.Join([]string{
+						"[bar]\nname=bar\nbaseurl=https://rpm.example/bar\nenabled=1\ngpgcheck=0\n",
+						"[foo]\nname=foo\nbaseurl=https://rpm.example/foo\nenabled=1\n" +
+							"gpgcheck=1\ngpgkey=file://" + keyringPath + "\n",
+					}, "\n")),
+					Mode: config.DnfFileMode,
+				},
+				build.RunAll{[]build.Run{
+					{"dnf install -y", []string{"libfoo", "libbar-1.2.3"}},
+					{"dnf clean all", []string{}},
+					{"rm -rf /var/cache/dnf /var/cache/yum /var/log/dnf*.log /var/log/hawkey.log", []string{}},
+				}},
+			},
+			cfg.InstructionsForPhase(build.PhasePrivileged),
+		)
+	})
+
+	t.Run("PhasePrivileged with microdnf and proxy", func(t *testing.T) {
+		cfg := config.DnfConfig{
+			Command:  "microdnf",
+			Packages: []string{"libfoo"},
+			Proxy:    "http://proxy.example:8080",
+		}
+
+		assert.Equal(t,
+			[]build.Instruction{
+				build.RunAll{[]build.Run{
+					{"microdnf install -y --setopt=proxy=%s", []string{"http://proxy.example:8080", "libfoo"}},
+					{"microdnf clean all", []string{}},
+					{"rm -rf /var/cache/dnf /var/cache/yum /var/log/dnf*.log /var/log/hawkey.log", []string{}},
+				}},
+			},
+			cfg.InstructionsForPhase(build.PhasePrivileged),
+		)
+	})
+
+	t.Run("PhasePrivilegeDropped", func(t *testing.T) {
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhasePrivilegeDropped))
+	})
+
+	t.Run("PhasePreInstall", func(t *testing.T) {
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhasePreInstall))
+	})
+
+	t.Run("PhasePostInstall", func(t *testing.T) {
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhasePostInstall))
+	})
+}
+
+func TestDnfConfigValidation(t *testing.T) {
+	t.Run("packages", func(t *testing.T) {
+		t.Run("ok", func(t *testing.T) {
+			err := config.Validate(config.DnfConfig{
+				Command: "microdnf",
+				Packages: []string{
+					"f1",
+					"python3.11",
+					"gcc-c++",
+					"nginx-1.20.1",
+					"nginx-1:1.20.1-14.el9",
+					"foo-1.0~rc1-1.el9",
+					"glibc-langpack-en.x86_64",
+				},
+			})
+
+			assert.False(t, config.IsValidationError(err))
+		})
+
+		t.Run("bad", func(t *testing.T) {
+			err := config.Validate(config.DnfConfig{
+				Command: "yum",
+				Packages: []string{
+					"f1",
+					"foo fighter",
+					"foo*",
+					"-foo",
+				},
+			})
+
+			if assert.True(t, config.IsValidationError(err)) {
+				msg := config.HumanizeValidationError(err)
+
+				assert.Equal(t, strings.Join([]string{
+					`command: "yum" is not one of: dnf microdnf`,
+					`packages[1]: "foo fighter" is not a valid RPM package name`,
+					`packages[2]: "foo*" is not a valid RPM package name`,
+					`packages[3]: "-foo" is not a valid RPM package name`,
+				}, "\n"), msg)
+			}
+		})
+	})
+
+	t.Run("repositories", func(t *testing.T) {
+		err := config.Validate(config.DnfConfig{
+			Repositories: []config.DnfRepository{
+				{Name: "ok", URL: "https://rpm.example"},
+				{Name: "bad name", URL: "ftp://rpm.example"},
+			},
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, strings.Join([]string{
+				`name: "bad name" is not a valid RPM repository ID`,
+				`url: "ftp://rpm.example" is not a valid HTTP/HTTPS URL`,
+			}, "\n"), msg)
+		}
+	})
+}
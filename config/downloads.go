@@ -0,0 +1,90 @@
+package config
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+)
+
+// DownloadFileMode is the default file mode of downloaded files.
+const DownloadFileMode = os.FileMode(0o644)
+
+// DownloadConfig declares a remote file to download into the image. The file
+// is verified against the given SHA-256 checksum.
+type DownloadConfig struct {
+	URL         string `json:"url" validate:"required,httpurl"`
+	SHA256      string `json:"sha256" validate:"required,sha256"`
+	Destination string `json:"destination" validate:"required"`
+	Mode        string `json:"mode" validate:"omitempty,filemode"`
+	Extract     bool   `json:"extract"`
+}
+
+// DownloadsConfig holds configuration for remote files downloaded into the
+// image.
+type DownloadsConfig []DownloadConfig
+
+// Merge appends the given downloads to these ones.
+func (dc *DownloadsConfig) Merge(dc2 DownloadsConfig) {
+	*dc = append(*dc, dc2...)
+}
+
+// Expand returns a version of this DownloadsConfig with relative destinations
+// resolved against the given application directory. Destinations of archives
+// to extract are always directories.
+func (dc DownloadsConfig) Expand(appDirectory string) DownloadsConfig {
+	expanded := make(DownloadsConfig, len(dc))
+
+	for i, download := range dc {
+		isDir := strings.HasSuffix(download.Destination, "/") || download.Extract
+
+		if !path.IsAbs(download.Destination) {
+			download.Destination = path.Join(appDirectory, download.Destination)
+		}
+
+		if isDir && !strings.HasSuffix(download.Destination, "/") {
+			download.Destination += "/"
+		}
+
+		expanded[i] = download
+	}
+
+	return expanded
+}
+
+// InstructionsForPhase injects instructions that download the configured
+// files.
+//
+// # PhaseInstall
+//
+// Downloads each file to its destination, verifying it against its checksum
+// and extracting it if configured to do so. Downloaded files are owned by
+// root.
+func (dc DownloadsConfig) InstructionsForPhase(phase build.Phase) []build.Instruction {
+	ins := []build.Instruction{}
+
+	if phase != build.PhaseInstall {
+		return ins
+	}
+
+	for _, download := range dc {
+		mode, _ := parseFileMode(download.Mode)
+
+		if mode == 0 {
+			mode = DownloadFileMode
+		}
+
+		ins = append(ins, build.Download{
+			URL:         download.URL,
+			Checksum:    digest.NewDigestFromEncoded(digest.SHA256, strings.ToLower(download.SHA256)),
+			Destination: download.Destination,
+			Mode:        mode,
+			Extract:     download.Extract,
+		})
+	}
+
+	return ins
+}
@@ -0,0 +1,157 @@
+package config_test
+
+import (
+	"os"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+const downloadChecksum = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+func TestDownloadsConfigYAML(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    variants:
+      base:
+        downloads:
+          - url: https://example.test/tool
+            sha256: ` + downloadChecksum + `
+            destination: /usr/local/bin/tool
+            mode: "0755"
+      production:
+        includes: [base]
+        downloads:
+          - url: https://example.test/assets.tar.gz
+            sha256: ` + downloadChecksum + `
+            destination: public
+            extract: true`))
+
+	if assert.NoError(t, err) && assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "production")) {
+		variant, err := config.GetVariant(cfg, "production")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t,
+				config.DownloadsConfig{
+					{
+						URL:         "https://example.test/tool",
+						SHA256:      downloadChecksum,
+						Destination: "/usr/local/bin/tool",
+						Mode:        "0755",
+					},
+					{
+						URL:         "https://example.test/assets.tar.gz",
+						SHA256:      downloadChecksum,
+						Destination: "public",
+						Extract:     true,
+					},
+				},
+				variant.Downloads,
+			)
+		}
+	}
+}
+
+func TestDownloadsConfigInstructions(t *testing.T) {
+	dc := config.DownloadsConfig{
+		{
+			URL:         "https://example.test/tool",
+			SHA256:      downloadChecksum,
+			Destination: "bin/",
+			Mode:        "0755",
+		},
+		{
+			URL:         "https://example.test/assets.tar.gz",
+			SHA256:      downloadChecksum,
+			Destination: "public",
+			Extract:     true,
+		},
+		{
+			URL:         "https://example.test/keyring.gpg",
+			SHA256:      downloadChecksum,
+			Destination: "/etc/keyring.gpg",
+		},
+	}
+
+	checksum := digest.NewDigestFromEncoded(digest.SHA256, downloadChecksum)
+
+	t.Run("PhaseInstall", func(t *testing.T) {
+		assert.Equal(t,
+			[]build.Instruction{
+				build.Download{
+					URL:         "https://example.test/tool",
+					Checksum:    checksum,
+					Destination: "/srv/app/bin/",
+					Mode:        os.FileMode(0o755),
+				},
+				build.Download{
+					URL:         "https://example.test/assets.tar.gz",
+					Checksum:    checksum,
+					Destination: "/srv/app/public/",
+					Mode:        config.DownloadFileMode,
+					Extract:     true,
+				},
+				build.Download{
+					URL:         "https://example.test/keyring.gpg",
+					Checksum:    checksum,
+					Destination: "/etc/keyring.gpg",
+					Mode:        config.DownloadFileMode,
+				},
+			},
+			dc.Expand("/srv/app").InstructionsForPhase(build.PhaseInstall),
+		)
+	})
+
+	t.Run("PhasePrivileged", func(t *testing.T) {
+		assert.Empty(t, dc.InstructionsForPhase(build.PhasePrivileged))
+	})
+
+	t.Run("PhasePostInstall", func(t *testing.T) {
+		assert.Empty(t, dc.InstructionsForPhase(build.PhasePostInstall))
+	})
+}
+
+func TestDownloadsConfigValidation(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		err := config.Validate(config.DownloadConfig{
+			URL:         "https://example.test/tool",
+			SHA256:      downloadChecksum,
+			Destination: "/usr/local/bin/tool",
+			Mode:        "0755",
+		})
+
+		assert.False(t, config.IsValidationError(err))
+	})
+
+	t.Run("bad url", func(t *testing.T) {
+		err := config.Validate(config.DownloadConfig{
+			URL:         "ftp://example.test/tool",
+			SHA256:      downloadChecksum,
+			Destination: "/usr/local/bin/tool",
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, `url: "ftp://example.test/tool" is not a valid HTTP/HTTPS URL`, msg)
+		}
+	})
+
+	t.Run("missing checksum", func(t *testing.T) {
+		err := config.Validate(config.DownloadConfig{
+			URL:         "https://example.test/tool",
+			Destination: "/usr/local/bin/tool",
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, `sha256: is required`, msg)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+)
+
+// HardeningConfig holds configuration for verifying security properties of
+// the final image filesystem.
+type HardeningConfig struct {
+	// Enabled runs the hardening checks at the end of the build
+	Enabled Flag `json:"enabled"`
+
+	// Writable is a list of absolute paths or globs that the runtime user may
+	// write to
+	Writable []string `json:"writable" validate:"dive,abspath"`
+
+	// Setuid is a list of absolute paths or globs of setuid/setgid binaries
+	// that are allowed in the image
+	Setuid []string `json:"setuid" validate:"dive,abspath"`
+}
+
+// Merge takes another HardeningConfig and merges its fields into this one's.
+func (hc *HardeningConfig) Merge(hc2 HardeningConfig) {
+	hc.Enabled.Merge(hc2.Enabled)
+
+	if hc2.Writable != nil {
+		hc.Writable = append(hc.Writable, hc2.Writable...)
+	}
+
+	if hc2.Setuid != nil {
+		hc.Setuid = append(hc.Setuid, hc2.Setuid...)
+	}
+}
+
+// Instructions returns a build instruction that runs the hardening checks as
+// root for the runtime user given by the uid and gid (which may reference
+// build arguments). The given writable paths, typically the runtime user's
+// home directory, are allowed in addition to Writable.
+//
+// The build fails, listing the offending paths, if the runtime user is root,
+// or if any file outside of the writable paths is writable by the runtime
+// user, or if any setuid/setgid binary outside of
+// Setuid is found. Directories that are world-writable but have the sticky
+// bit set (e.g. /tmp) are allowed. Other filesystems mounted during the build
+// (e.g. /proc) are not checked. The image must provide a shell and find.
+func (hc HardeningConfig) Instructions(uid string, gid string, writable []string) []build.Instruction {
+	if !hc.Enabled.True {
+		return []build.Instruction{}
+	}
+
+	writable = append(writable, hc.Writable...)
+
+	script := []string{
+		"set -eu",
+		"uid=" + strconv.Quote(uid),
+		"gid=" + strconv.Quote(gid),
+		`if [ "$uid" -eq 0 ]; then`,
+		`  echo "hardening: the runtime user must not be root (UID 0)" >&2`,
+		"  exit 1",
+		"fi",
+		"failed=",
+		"writable=$(find / -xdev" + findPrune(writable) +
+			` ! -type l \( -user "$uid" -perm -200 -o -group "$gid" -perm -020 -o -perm -002 ! \( -type d -perm -1000 \) \) -print)`,
+		`if [ -n "$writable" ]; then`,
+		`  echo "hardening: found files writable by the runtime user outside of writable paths:" >&2`,
+		`  echo "$writable" >&2`,
+		"  failed=1",
+		"fi",
+		"setuid=$(find / -xdev" + findPrune(hc.Setuid) +
+			` -type f \( -perm -4000 -o -perm -2000 \) -print)`,
+		`if [ -n "$setuid" ]; then`,
+		`  echo "hardening: found setuid/setgid binaries that are not allowed:" >&2`,
+		`  echo "$setuid" >&2`,
+		"  failed=1",
+		"fi",
+		`[ -z "$failed" ]`,
+	}
+
+	return []build.Instruction{
+		build.RunScript{
+			Script:  []byte(strings.Join(script, "\n") + "\n"),
+			Options: []build.RunOption{build.RunAs{User: "0"}},
+		},
+	}
+}
+
+// findPrune returns a find expression, with a leading space, that prunes the
+// given paths and is to be followed by another expression.
+func findPrune(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+
+	exprs := make([]string, len(paths))
+
+	for i, p := range paths {
+		exprs[i] = "-path " + strconv.Quote(p)
+	}
+
+	return ` \( ` + strings.Join(exprs, " -o ") + ` \) -prune -o`
+}
@@ -0,0 +1,121 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+func TestHardeningConfigYAML(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    runs:
+      hardening:
+        enabled: true
+        writable: [/srv/app/cache]
+    variants:
+      production:
+        runs:
+          hardening:
+            writable: [/srv/app/uploads/*]
+            setuid: [/usr/bin/ping]`))
+
+	require.NoError(t, err)
+
+	require.NoError(t, config.ExpandIncludesAndCopies(cfg, "production"))
+
+	variant, err := config.GetVariant(cfg, "production")
+	require.NoError(t, err)
+
+	assert.Equal(t,
+		config.HardeningConfig{
+			Enabled:  config.Flag{True: true, Set: true},
+			Writable: []string{"/srv/app/cache", "/srv/app/uploads/*"},
+			Setuid:   []string{"/usr/bin/ping"},
+		},
+		variant.Runs.Hardening,
+	)
+}
+
+func TestHardeningConfigInstructions(t *testing.T) {
+	cfg := config.HardeningConfig{
+		Enabled:  config.Flag{True: true, Set: true},
+		Writable: []string{"/srv/app/cache"},
+		Setuid:   []string{"/usr/bin/ping"},
+	}
+
+	ins := cfg.Instructions("$RUNS_UID", "$RUNS_GID", []string{"/home/$RUNS_AS"})
+
+	require.Len(t, ins, 1)
+	require.IsType(t, build.RunScript{}, ins[0])
+
+	run := ins[0].(build.RunScript)
+	script := string(run.Script)
+
+	assert.Equal(t, []build.RunOption{build.RunAs{User: "0"}}, run.Options)
+	assert.Contains(t, script, `uid="$RUNS_UID"`)
+	assert.Contains(t, script, `gid="$RUNS_GID"`)
+	assert.Contains(t, script,
+		`writable=$(find / -xdev \( -path "/home/$RUNS_AS" -o -path "/srv/app/cache" \) -prune -o ! -type l `+
+			`\( -user "$uid" -perm -200 -o -group "$gid" -perm -020 -o -perm -002 ! \( -type d -perm -1000 \) \) -print)`,
+	)
+	assert.Contains(t, script,
+		`setuid=$(find / -xdev \( -path "/usr/bin/ping" \) -prune -o -type f \( -perm -4000 -o -perm -2000 \) -print)`,
+	)
+
+	t.Run("without setuid allowlist", func(t *testing.T) {
+		cfg := cfg
+		cfg.Setuid = nil
+
+		script := string(cfg.Instructions("$RUNS_UID", "$RUNS_GID", []string{"/home/$RUNS_AS"})[0].(build.RunScript).Script)
+
+		assert.Contains(t, script, `setuid=$(find / -xdev -type f \( -perm -4000 -o -perm -2000 \) -print)`)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.Empty(t, config.HardeningConfig{}.Instructions("$RUNS_UID", "$RUNS_GID", []string{"/home/$RUNS_AS"}))
+	})
+}
+
+func TestRunsConfigHardening(t *testing.T) {
+	cfg := config.RunsConfig{
+		Hardening: config.HardeningConfig{Enabled: config.Flag{True: true, Set: true}},
+	}
+
+	t.Run("PhaseFinal", func(t *testing.T) {
+		ins := cfg.InstructionsForPhase(build.PhaseFinal)
+
+		require.Len(t, ins, 1)
+		assert.Contains(t, string(ins[0].(build.RunScript).Script), `uid="$RUNS_UID"`)
+		assert.Contains(t, string(ins[0].(build.RunScript).Script), `-path "$HOME"`)
+	})
+
+	t.Run("PhaseFinal running insecurely", func(t *testing.T) {
+		cfg := cfg
+		cfg.Insecurely = config.Flag{True: true, Set: true}
+
+		ins := cfg.InstructionsForPhase(build.PhaseFinal)
+
+		require.Len(t, ins, 1)
+		assert.Contains(t, string(ins[0].(build.RunScript).Script), `uid="$LIVES_UID"`)
+		assert.Contains(t, string(ins[0].(build.RunScript).Script), `-path "$HOME"`)
+	})
+}
+
+func TestHardeningConfigValidation(t *testing.T) {
+	err := config.Validate(config.HardeningConfig{
+		Writable: []string{"srv/app"},
+	})
+
+	if assert.True(t, config.IsValidationError(err)) {
+		assert.Equal(t,
+			`writable[0]: "srv/app" is not a valid absolute non-root path`,
+			config.HumanizeValidationError(err),
+		)
+	}
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var variantIncludeRegexp = regexp.MustCompile(`^includes\[\d+\]$`)
+
+// ImportConfig references a config file from which variants and common
+// configuration are imported.
+type ImportConfig struct {
+	// Path is the path of the config file. If no context is given, it is
+	// relative to the directory of the importing config file.
+	Path string `json:"path" validate:"required"`
+
+	// Context is the name of a build context (e.g. given by
+	// `--build-context`) from which to read the config file instead of the
+	// context of the importing config.
+	Context string `json:"context"`
+
+	// As is the namespace of imported variants, which are referenced by
+	// their name prefixed with the namespace and a slash (e.g.
+	// "shared/python-base").
+	As string `json:"as" validate:"required,namespace"`
+}
+
+// ImportsConfig holds the config files from which configuration is imported.
+type ImportsConfig []ImportConfig
+
+// ImportReader reads the file at the given path from the given named build
+// context, or from the context of the main config if the build context is
+// empty.
+type ImportReader func(ctx context.Context, buildContext string, path string) ([]byte, error)
+
+// importer resolves the imports of a config file.
+type importer struct {
+	read  ImportReader
+	graph *DepGraph
+
+	// build context and path of the config file being read
+	buildContext string
+	path         string
+}
+
+// key returns the key of the config file in the imports dependency graph.
+func (imp *importer) key() string {
+	if imp.buildContext == "" {
+		return imp.path
+	}
+
+	return imp.buildContext + ":" + imp.path
+}
+
+// child returns an importer for the given import of the current config file.
+func (imp *importer) child(ic ImportConfig) *importer {
+	child := &importer{
+		read:         imp.read,
+		graph:        imp.graph,
+		buildContext: imp.buildContext,
+		path:         path.Join(path.Dir(imp.path), ic.Path),
+	}
+
+	if ic.Context != "" {
+		child.buildContext = ic.Context
+		child.path = path.Clean(ic.Path)
+	}
+
+	return child
+}
+
+// resolve merges the common configuration and variants of all imports into
+// the given config, which was decoded from the given data. Common
+// configuration is merged in order of the imports, followed by that of the
+// config itself. Imported variants are namespaced, and references to them
+// by other imported variants are renamed accordingly.
+func (imp *importer) resolve(ctx context.Context, config *Config, data []byte) error {
+	if imp == nil {
+		return errors.New("imports are not supported when reading a config this way")
+	}
+
+	// Decode the config again without defaults so that its own configuration
+	// can be merged over that of its imports
+	var own Config
+
+	err := json.Unmarshal(data, &own)
+
+	if err != nil {
+		return err
+	}
+
+	resolved := Config{Variants: map[string]VariantConfig{}}
+	json.Unmarshal([]byte(DefaultConfig), &resolved)
+
+	imp.graph.EnsureNode(imp.key())
+
+	for _, ic := range config.Imports {
+		child := imp.child(ic)
+
+		imp.graph.AddDependency(imp.key(), child.key())
+
+		if _, err := imp.graph.GetDeps(child.key()); err != nil {
+			return errors.Wrapf(err, "failed to import %s", child.key())
+		}
+
+		imported, err := child.readConfig(ctx)
+
+		if err != nil {
+			return errors.Wrapf(err, "failed to import %s", child.key())
+		}
+
+		err = namespaceReferences(&imported.CommonConfig, ic.As, imported.Variants)
+
+		if err != nil {
+			return err
+		}
+
+		resolved.CommonConfig.Merge(imported.CommonConfig)
+
+		for name, vcfg := range imported.Variants {
+			namespaced := ic.As + "/" + name
+
+			if _, exists := resolved.Variants[namespaced]; exists {
+				return errors.Errorf("imported variant %q already exists", namespaced)
+			}
+
+			err := namespaceReferences(&vcfg, ic.As, imported.Variants)
+
+			if err != nil {
+				return err
+			}
+
+			resolved.Variants[namespaced] = vcfg
+		}
+	}
+
+	resolved.CommonConfig.Merge(own.CommonConfig)
+
+	for name, vcfg := range config.Variants {
+		if _, exists := resolved.Variants[name]; exists {
+			return errors.Errorf("variant %q conflicts with an imported variant", name)
+		}
+
+		resolved.Variants[name] = vcfg
+	}
+
+	resolved.Imports = config.Imports
+	resolved.VersionConfig = config.VersionConfig
+
+	*config = resolved
+
+	return nil
+}
+
+// readConfig reads and parses the config file, resolving its own imports.
+func (imp *importer) readConfig(ctx context.Context) (*Config, error) {
+	data, err := imp.read(ctx, imp.buildContext, imp.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return readYAMLConfig(ctx, data, imp)
+}
+
+// namespaceReferences prefixes all references to the given variants (i.e.
+// includes and the "from" of copies, requirements, mounts, etc.) within the
+// given config value with the given namespace.
+func namespaceReferences(cfg interface{}, namespace string, variants map[string]VariantConfig) error {
+	none := func(reflect.StructField) bool { return false }
+
+	return transformStrings(reflect.ValueOf(cfg).Elem(), none, func(path string, _ reflect.StructField, value string) (string, error) {
+		isReference := variantIncludeRegexp.MatchString(path) ||
+			path == "from" || strings.HasSuffix(path, ".from")
+
+		if _, exists := variants[value]; isReference && exists {
+			return namespace + "/" + value, nil
+		}
+
+		return value, nil
+	})
+}
@@ -0,0 +1,233 @@
+package config_test
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+// testImportReader reads files from the given map keyed by "context:path",
+// or just the path for the main context.
+func testImportReader(files map[string]string) config.ImportReader {
+	return func(_ context.Context, buildContext string, path string) ([]byte, error) {
+		key := path
+
+		if buildContext != "" {
+			key = buildContext + ":" + path
+		}
+
+		if data, ok := files[key]; ok {
+			return []byte(data), nil
+		}
+
+		return nil, errors.Errorf("no such file %s", key)
+	}
+}
+
+func TestImportsConfig(t *testing.T) {
+	reader := testImportReader(map[string]string{
+		"shared:blubber.yaml": `---
+    version: v4
+    imports:
+      - path: python.yaml
+        as: python
+    apt:
+      packages: [ca-certificates]
+    lives:
+      in: /app
+    variants:
+      base:
+        includes: [python/build]
+        copies: [local]
+      test:
+        includes: [base]
+        copies:
+          - from: base
+            source: /app
+            destination: .
+          - from: docker-registry.wikimedia.org/foo
+            source: /foo
+            destination: /foo`,
+
+		"shared:python.yaml": `---
+    version: v4
+    variants:
+      build:
+        python:
+          version: python3`,
+	})
+
+	cfg, err := config.ReadYAMLConfigWithImports(context.Background(), []byte(`---
+    version: v4
+    imports:
+      - context: shared
+        path: blubber.yaml
+        as: shared
+    base: foo
+    apt:
+      packages: [curl]
+    variants:
+      production:
+        includes: [shared/base]
+        copies: [shared/test]`), "blubber.yaml", reader)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t,
+		[]string{"production", "shared/base", "shared/python/build", "shared/test"},
+		slices.Sorted(maps.Keys(cfg.Variants)),
+	)
+
+	assert.Equal(t, "foo", cfg.Base)
+	assert.Equal(t, "/app", cfg.Lives.In)
+	assert.Equal(t, []string{"ca-certificates", "curl"}, cfg.Apt.Packages["default"])
+
+	assert.Equal(t, []string{"shared/python/build"}, cfg.Variants["shared/base"].Includes)
+	assert.Equal(t, []string{"shared/base"}, cfg.Variants["shared/test"].Includes)
+	assert.Equal(t, "shared/base", cfg.Variants["shared/test"].Copies[0].From)
+	assert.Equal(t, "docker-registry.wikimedia.org/foo", cfg.Variants["shared/test"].Copies[1].From)
+
+	if assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "production")) {
+		variant, err := config.GetVariant(cfg, "production")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "python3", variant.Python.Version)
+		}
+	}
+}
+
+func TestImportsConfigRelativePath(t *testing.T) {
+	reader := testImportReader(map[string]string{
+		"config/shared/blubber.yaml": `---
+    version: v4
+    variants:
+      base: {}`,
+	})
+
+	cfg, err := config.ReadYAMLConfigWithImports(context.Background(), []byte(`---
+    version: v4
+    imports:
+      - path: shared/blubber.yaml
+        as: shared
+    base: foo
+    variants:
+      production:
+        includes: [shared/base]`), "config/blubber.yaml", reader)
+
+	if assert.NoError(t, err) {
+		assert.Contains(t, cfg.Variants, "shared/base")
+	}
+}
+
+func TestImportsConfigCycle(t *testing.T) {
+	reader := testImportReader(map[string]string{
+		"a.yaml": `---
+    version: v4
+    imports:
+      - path: b.yaml
+        as: b`,
+		"b.yaml": `---
+    version: v4
+    imports:
+      - path: blubber.yaml
+        as: main`,
+		"blubber.yaml": `---
+    version: v4
+    imports:
+      - path: a.yaml
+        as: a`,
+	})
+
+	_, err := config.ReadYAMLConfigWithImports(context.Background(), []byte(`---
+    version: v4
+    imports:
+      - path: a.yaml
+        as: a`), "blubber.yaml", reader)
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cycle")
+	}
+}
+
+func TestImportsConfigConflict(t *testing.T) {
+	reader := testImportReader(map[string]string{
+		"shared.yaml": `---
+    version: v4
+    variants:
+      base: {}`,
+	})
+
+	_, err := config.ReadYAMLConfigWithImports(context.Background(), []byte(`---
+    version: v4
+    imports:
+      - path: shared.yaml
+        as: shared
+    variants:
+      shared/base: {}`), "blubber.yaml", reader)
+
+	if assert.Error(t, err) {
+		assert.Equal(t, `variant "shared/base" conflicts with an imported variant`, err.Error())
+	}
+}
+
+func TestImportsConfigUnsupported(t *testing.T) {
+	_, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    imports:
+      - path: shared.yaml
+        as: shared`))
+
+	assert.Error(t, err)
+}
+
+func TestImportsConfigFile(t *testing.T) {
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "shared.yaml"), []byte(`---
+    version: v4
+    variants:
+      base: {}`), 0o644)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "blubber.yaml"), []byte(`---
+    version: v4
+    imports:
+      - path: shared.yaml
+        as: shared
+    variants:
+      production:
+        includes: [shared/base]`), 0o644)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cfg, err := config.ReadConfigFile(filepath.Join(dir, "blubber.yaml"))
+
+	if assert.NoError(t, err) {
+		assert.Contains(t, cfg.Variants, "shared/base")
+	}
+}
+
+func TestImportsConfigValidation(t *testing.T) {
+	err := config.Validate(config.ImportConfig{Path: "shared.yaml", As: "not/a/namespace"})
+
+	if assert.True(t, config.IsValidationError(err)) {
+		msg := config.HumanizeValidationError(err)
+
+		assert.Equal(t, `as: "not/a/namespace" is not a valid namespace`, msg)
+	}
+}
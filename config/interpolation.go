@@ -0,0 +1,362 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var variableNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Interpolate replaces variable references of the form `${NAME}` in all
+// string fields of the variant with the values of the given variables.
+//
+// A default value may be given using `${NAME:-default}`, which is used if
+// the variable is undefined or empty, or `${NAME-default}`, which is used
+// only if it is undefined. Referencing an undefined variable without a
+// default is an error. A literal `${` may be written as `$${`. References of
+// the form `$NAME` are left untouched.
+//
+// Fields tagged with `interpolate:"false"` (i.e. those evaluated by a shell,
+// those holding key material or version specifiers, and arguments
+// themselves) are not interpolated. Fields tagged with
+// `interpolate:"runtime"` (i.e. the entry point and environment variables of
+// the image) are evaluated at runtime, so references to undefined variables
+// in them are left untouched along with their defaults.
+func (vc *VariantConfig) Interpolate(variables map[string]string) error {
+	return interpolator{variables: variables}.value(reflect.ValueOf(vc).Elem())
+}
+
+// VariableReference is a reference to a variable found at the given path of
+// the configuration (e.g. "copies[0].source").
+type VariableReference struct {
+	Path string
+	Name string
+}
+
+// InterpolateDeferred is like [VariantConfig.Interpolate] but, in fields that
+// are expanded using the environment of the build (those tagged with
+// `interpolate:"deferred"`, e.g. cache destinations), leaves references to
+// variables that are not given untouched so that they may be expanded using
+// variables of the base image or those defined by `runs.environment` (see
+// [build.Target.ExpandEnv]). Those references without a default value are
+// returned so that the caller may verify that they are defined by the
+// environment.
+func (vc *VariantConfig) InterpolateDeferred(variables map[string]string) ([]VariableReference, error) {
+	deferred := []VariableReference{}
+
+	err := interpolator{
+		variables: variables,
+		deferUndefined: func(path string, name string) {
+			deferred = append(deferred, VariableReference{Path: path, Name: name})
+		},
+	}.value(reflect.ValueOf(vc).Elem())
+
+	return deferred, err
+}
+
+// InterpolationVariables returns the variables available for interpolation
+// given the variant's arguments, the build arguments that override them, and
+// any additional variables (e.g. platform variables) which take precedence.
+func InterpolationVariables(arguments ArgumentsConfig, buildArgs map[string]string, additional map[string]string) map[string]string {
+	variables := map[string]string{}
+
+	for _, vars := range []map[string]string{arguments, buildArgs, additional} {
+		for name, value := range vars {
+			variables[name] = value
+		}
+	}
+
+	return variables
+}
+
+// interpolator replaces variable references in configuration values.
+type interpolator struct {
+	variables map[string]string
+
+	// partial leaves references to undefined variables and escaped references
+	// untouched so that they may be interpolated later with the remaining
+	// variables
+	partial bool
+
+	// deferUndefined, if set, causes references to undefined variables to be
+	// left untouched and is called with those that have no default value
+	deferUndefined func(path string, name string)
+
+	// runtime causes references to undefined variables to be left untouched
+	// along with their defaults so that they may be expanded at runtime
+	runtime bool
+}
+
+// value recursively interpolates all strings of the given value according
+// to the `interpolate` tag of the struct field that holds them. See
+// [transformStrings].
+func (in interpolator) value(v reflect.Value) error {
+	excluded := func(field reflect.StructField) bool {
+		return field.Tag.Get("interpolate") == "false"
+	}
+
+	return transformStrings(v, excluded, func(path string, field reflect.StructField, subject string) (string, error) {
+		in := in
+
+		switch field.Tag.Get("interpolate") {
+		case "runtime":
+			in.deferUndefined = nil
+			in.runtime = true
+		case "deferred":
+		default:
+			in.deferUndefined = nil
+		}
+
+		interpolated, err := in.stringAt(path, subject)
+
+		if err != nil {
+			return "", errors.Wrap(err, path)
+		}
+
+		return interpolated, nil
+	})
+}
+
+// transformStrings recursively replaces all strings of the given value with
+// the result of the given function, which is passed the JSON path of each
+// string (e.g. "copies[0].source") and the struct field that most closely
+// encloses it (e.g. the Source field of a CopiesConfig). Slices, maps, and
+// values referenced by pointers or interfaces are copied so that values
+// shared with other configuration are left untouched. Unexported fields and
+// those for which excluded returns true are skipped.
+func transformStrings(
+	v reflect.Value,
+	excluded func(reflect.StructField) bool,
+	transform func(path string, field reflect.StructField, value string) (string, error),
+) error {
+	return transformStringsAt(v, "", reflect.StructField{}, excluded, transform)
+}
+
+func transformStringsAt(
+	v reflect.Value,
+	path string,
+	field reflect.StructField,
+	excluded func(reflect.StructField) bool,
+	transform func(path string, field reflect.StructField, value string) (string, error),
+) error {
+	switch v.Kind() {
+	case reflect.String:
+		transformed, err := transform(strings.TrimPrefix(path, "."), field, v.String())
+
+		if err != nil {
+			return err
+		}
+
+		v.SetString(transformed)
+
+	case reflect.Struct:
+		t := v.Type()
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			if !field.IsExported() || excluded(field) {
+				continue
+			}
+
+			fieldPath := path
+
+			if !field.Anonymous {
+				fieldPath += "." + resolveJSONTagName(field)
+			}
+
+			if err := transformStringsAt(v.Field(i), fieldPath, field, excluded, transform); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(copied, v)
+
+		for i := 0; i < copied.Len(); i++ {
+			if err := transformStringsAt(copied.Index(i), fmt.Sprintf("%s[%d]", path, i), field, excluded, transform); err != nil {
+				return err
+			}
+		}
+
+		v.Set(copied)
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+
+		for iter.Next() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(iter.Value())
+
+			if err := transformStringsAt(value, fmt.Sprintf("%s[%v]", path, iter.Key()), field, excluded, transform); err != nil {
+				return err
+			}
+
+			copied.SetMapIndex(iter.Key(), value)
+		}
+
+		v.Set(copied)
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+
+		copied := reflect.New(v.Elem().Type())
+		copied.Elem().Set(v.Elem())
+
+		if err := transformStringsAt(copied.Elem(), path, field, excluded, transform); err != nil {
+			return err
+		}
+
+		v.Set(copied)
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+
+		copied := reflect.New(v.Elem().Type()).Elem()
+		copied.Set(v.Elem())
+
+		if err := transformStringsAt(copied, path, field, excluded, transform); err != nil {
+			return err
+		}
+
+		v.Set(copied)
+	}
+
+	return nil
+}
+
+// string replaces all variable references in the given string.
+func (in interpolator) string(subject string) (string, error) {
+	return in.stringAt("", subject)
+}
+
+// stringAt replaces all variable references in the given string found at the
+// given path.
+func (in interpolator) stringAt(path string, subject string) (string, error) {
+	var result strings.Builder
+
+	for {
+		i := strings.Index(subject, "${")
+
+		if i < 0 {
+			result.WriteString(subject)
+			return result.String(), nil
+		}
+
+		// An escaped reference
+		if i > 0 && subject[i-1] == '$' {
+			result.WriteString(subject[:i])
+
+			if in.partial {
+				result.WriteString("${")
+			} else {
+				result.WriteString("{")
+			}
+
+			subject = subject[i+2:]
+			continue
+		}
+
+		end := strings.Index(subject[i:], "}")
+
+		if end < 0 {
+			if in.partial {
+				result.WriteString(subject)
+				return result.String(), nil
+			}
+
+			return "", errors.Errorf("unterminated variable reference %q", subject[i:])
+		}
+
+		value, err := in.resolve(subject[i+2 : i+end])
+
+		if err != nil {
+			var undefined undefinedVariableError
+
+			switch {
+			case in.partial:
+			case in.runtime && errors.As(err, &undefined):
+			case in.deferUndefined != nil && errors.As(err, &undefined):
+				if !undefined.hasDefault {
+					in.deferUndefined(path, undefined.name)
+				}
+			default:
+				return "", err
+			}
+
+			value = subject[i : i+end+1]
+		}
+
+		result.WriteString(subject[:i])
+		result.WriteString(value)
+		subject = subject[i+end+1:]
+	}
+}
+
+// resolve returns the value of the given reference (the part between the
+// braces).
+func (in interpolator) resolve(ref string) (string, error) {
+	name, def, hasDefault := ref, "", false
+	emptyIsUnset := false
+
+	if i := strings.Index(ref, "-"); i >= 0 {
+		name, def, hasDefault = ref[:i], ref[i+1:], true
+
+		if strings.HasSuffix(name, ":") {
+			name, emptyIsUnset = strings.TrimSuffix(name, ":"), true
+		}
+	}
+
+	if !variableNameRegexp.MatchString(name) {
+		return "", errors.Errorf("invalid variable reference \"${%s}\"", ref)
+	}
+
+	value, ok := in.variables[name]
+
+	if ok && !(emptyIsUnset && value == "") {
+		return value, nil
+	}
+
+	// The default of an undefined variable whose resolution is deferred or
+	// left for runtime is applied when it is expanded
+	if hasDefault && !in.partial && (ok || (in.deferUndefined == nil && !in.runtime)) {
+		return def, nil
+	}
+
+	return "", undefinedVariableError{name: name, hasDefault: hasDefault}
+}
+
+// undefinedVariableError is returned when resolving a reference to an
+// undefined variable.
+type undefinedVariableError struct {
+	name       string
+	hasDefault bool
+}
+
+func (err undefinedVariableError) Error() string {
+	return fmt.Sprintf("variable %q is not defined", err.name)
+}
+
+// isInterpolated returns whether the given string contains a variable
+// reference to be interpolated.
+func isInterpolated(subject string) bool {
+	return strings.Contains(strings.ReplaceAll(subject, "$${", ""), "${")
+}
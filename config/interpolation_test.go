@@ -0,0 +1,286 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+func TestVariantConfigInterpolate(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: docker-registry.wikimedia.org/bookworm:${BASE_TAG:-latest}
+    arguments:
+      PKG_VERSION: "1.2"
+    apt:
+      packages: ["foo=${PKG_VERSION}"]
+    builder:
+      command: [echo, "${NOT_INTERPOLATED}"]
+    runs:
+      environment:
+        ARCH: ${TARGETARCH}
+        LITERAL: $${TARGETARCH}
+        RUNTIME: $PATH
+    variants:
+      test:
+        copies:
+          - from: local
+            source: ./build/${TARGETARCH}
+            destination: .
+        entrypoint: ["./app-${TARGETARCH}"]`))
+
+	if !assert.NoError(t, err) || !assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "test")) {
+		return
+	}
+
+	variables := config.InterpolationVariables(
+		cfg.Arguments,
+		map[string]string{"BASE_TAG": "20241201"},
+		map[string]string{"TARGETARCH": "arm64"},
+	)
+
+	t.Run("ok", func(t *testing.T) {
+		variant, err := config.GetVariant(cfg, "test")
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		if assert.NoError(t, variant.Interpolate(variables)) {
+			assert.Equal(t, "docker-registry.wikimedia.org/bookworm:20241201", variant.Base)
+			assert.Equal(t, []string{"foo=1.2"}, variant.Apt.Packages["default"])
+			assert.Equal(t, config.BuilderCommand{"echo", "${NOT_INTERPOLATED}"}, variant.Builder.Command)
+			assert.Equal(t, "arm64", variant.Runs.Environment["ARCH"])
+			assert.Equal(t, "${TARGETARCH}", variant.Runs.Environment["LITERAL"])
+			assert.Equal(t, "$PATH", variant.Runs.Environment["RUNTIME"])
+			assert.Equal(t, "./build/arm64", variant.Copies[0].Source)
+			assert.Equal(t, []string{"./app-arm64"}, variant.EntryPoint)
+
+			assert.NoError(t, config.ValidateVariant(*cfg, *variant))
+		}
+	})
+
+	t.Run("leaves the config untouched", func(t *testing.T) {
+		variant, err := config.GetVariant(cfg, "test")
+
+		if assert.NoError(t, err) && assert.NoError(t, variant.Interpolate(variables)) {
+			assert.Equal(t, "docker-registry.wikimedia.org/bookworm:${BASE_TAG:-latest}", cfg.Base)
+			assert.Equal(t, []string{"foo=${PKG_VERSION}"}, cfg.Apt.Packages["default"])
+			assert.Equal(t, "${TARGETARCH}", cfg.Runs.Environment["ARCH"])
+			assert.Equal(t, "./build/${TARGETARCH}", cfg.Variants["test"].Copies[0].Source)
+		}
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		variant, err := config.GetVariant(cfg, "test")
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		err = variant.Interpolate(config.InterpolationVariables(
+			cfg.Arguments,
+			nil,
+			map[string]string{"TARGETARCH": "amd64"},
+		))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "docker-registry.wikimedia.org/bookworm:latest", variant.Base)
+		}
+	})
+
+	t.Run("undefined", func(t *testing.T) {
+		variant, err := config.GetVariant(cfg, "test")
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		err = variant.Interpolate(config.InterpolationVariables(cfg.Arguments, nil, nil))
+
+		if assert.Error(t, err) {
+			assert.Equal(t, `copies[0].source: variable "TARGETARCH" is not defined`, err.Error())
+		}
+	})
+}
+
+func TestVariantConfigInterpolateDeferred(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		variant := config.NewVariantConfig("test")
+		variant.Base = "foo:${TAG}-${VARIANT:-slim}"
+		variant.Builder.Caches = config.CachesConfig{
+			{Destination: "${GOPATH}/pkg"},
+			{Destination: "${GOCACHE:-/tmp/cache}"},
+		}
+		variant.Runs.Environment = map[string]string{
+			"PATH":    "/opt/bin:${PATH}",
+			"DEFAULT": "${UNDEFINED:-foo}",
+			"EMPTY":   "${EMPTY:-foo}",
+			"LITERAL": "$${TAG}",
+		}
+
+		deferred, err := variant.InterpolateDeferred(map[string]string{"TAG": "1.0", "EMPTY": ""})
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "foo:1.0-slim", variant.Base)
+			assert.Equal(t, "${GOPATH}/pkg", variant.Builder.Caches[0].Destination)
+			assert.Equal(t, "${GOCACHE:-/tmp/cache}", variant.Builder.Caches[1].Destination)
+			assert.Equal(t, "/opt/bin:${PATH}", variant.Runs.Environment["PATH"])
+			assert.Equal(t, "${UNDEFINED:-foo}", variant.Runs.Environment["DEFAULT"])
+			assert.Equal(t, "foo", variant.Runs.Environment["EMPTY"])
+			assert.Equal(t, "${TAG}", variant.Runs.Environment["LITERAL"])
+
+			assert.Equal(t,
+				[]config.VariableReference{{Path: "builder.caches[0].destination", Name: "GOPATH"}},
+				deferred,
+			)
+		}
+	})
+
+	t.Run("undefined", func(t *testing.T) {
+		variant := config.NewVariantConfig("test")
+		variant.Base = "foo:${TAG}"
+
+		_, err := variant.InterpolateDeferred(map[string]string{})
+
+		if assert.Error(t, err) {
+			assert.Equal(t, `base: variable "TAG" is not defined`, err.Error())
+		}
+	})
+}
+
+func TestVariantConfigInterpolateRuntime(t *testing.T) {
+	variant := config.NewVariantConfig("test")
+	variant.EntryPoint = []string{"sh", "-c", "exec app --port ${PORT} --arch ${TARGETARCH} --log ${LOG:-info}"}
+	variant.Runs.Environment = map[string]string{"HOME_DIR": "${HOME}/app"}
+
+	err := variant.Interpolate(map[string]string{"TARGETARCH": "arm64"})
+
+	if assert.NoError(t, err) {
+		assert.Equal(t,
+			[]string{"sh", "-c", "exec app --port ${PORT} --arch arm64 --log ${LOG:-info}"},
+			variant.EntryPoint,
+		)
+		assert.Equal(t, "${HOME}/app", variant.Runs.Environment["HOME_DIR"])
+	}
+}
+
+func TestVariantConfigInterpolateExcludedFields(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    arguments:
+      KEY: foo
+    apt:
+      sources:
+        - url: http://apt.wikimedia.org/wikimedia
+          distribution: bookworm-wikimedia
+          signed-by: "${KEY}"
+    apk:
+      keys:
+        builder@example.org-1.rsa.pub: "${KEY}"
+    dnf:
+      repositories:
+        - name: foo
+          url: http://example.org/foo
+          signed-by: "${KEY}"
+    python:
+      version: python3
+      pip-version: "<${KEY}"
+      setuptools-version: "<${KEY}"
+      tox-version: "<${KEY}"
+      wheel-version: "<${KEY}"
+      poetry:
+        version: "==${KEY}"
+    builder:
+      command: [echo, "${KEY}"]
+    variants:
+      test:
+        templates:
+          - source: ${KEY}.tmpl
+            destination: /srv/${KEY}`))
+
+	if !assert.NoError(t, err) || !assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "test")) {
+		return
+	}
+
+	variant, err := config.GetVariant(cfg, "test")
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if assert.NoError(t, variant.Interpolate(config.InterpolationVariables(cfg.Arguments, nil, nil))) {
+		assert.Equal(t, "${KEY}", variant.Apt.Sources[0].SignedBy)
+		assert.Equal(t, "${KEY}", variant.Apk.Keys["builder@example.org-1.rsa.pub"])
+		assert.Equal(t, "${KEY}", variant.Dnf.Repositories[0].SignedBy)
+		assert.Equal(t, "<${KEY}", variant.Python.PipVersion)
+		assert.Equal(t, "<${KEY}", variant.Python.SetuptoolsVersion)
+		assert.Equal(t, "<${KEY}", variant.Python.ToxVersion)
+		assert.Equal(t, "<${KEY}", variant.Python.WheelVersion)
+		assert.Equal(t, "==${KEY}", variant.Python.Poetry.Version)
+		assert.Equal(t, config.BuilderCommand{"echo", "${KEY}"}, variant.Builder.Command)
+		assert.Equal(t, "foo.tmpl", variant.Templates[0].Source)
+		assert.Equal(t, "/srv/${KEY}", variant.Templates[0].Destination)
+	}
+}
+
+func TestVariantConfigInterpolateSyntax(t *testing.T) {
+	variables := map[string]string{
+		"FOO":   "foo",
+		"EMPTY": "",
+	}
+
+	examples := []struct {
+		value    string
+		expected string
+		err      string
+	}{
+		{"${FOO}", "foo", ""},
+		{"a${FOO}b${FOO}c", "afoobfooc", ""},
+		{"${BAR:-bar}", "bar", ""},
+		{"${EMPTY:-bar}", "bar", ""},
+		{"${EMPTY-bar}", "", ""},
+		{"${BAR-bar}", "bar", ""},
+		{"${BAR:-}", "", ""},
+		{"$${FOO}", "${FOO}", ""},
+		{"$FOO", "$FOO", ""},
+		{"${BAR}", "", `base: variable "BAR" is not defined`},
+		{"${FOO", "", `base: unterminated variable reference "${FOO"`},
+		{"${FOO BAR}", "", `base: invalid variable reference "${FOO BAR}"`},
+	}
+
+	for _, example := range examples {
+		t.Run(example.value, func(t *testing.T) {
+			variant := config.NewVariantConfig("test")
+			variant.Base = example.value
+
+			err := variant.Interpolate(variables)
+
+			if example.err == "" {
+				if assert.NoError(t, err) {
+					assert.Equal(t, example.expected, variant.Base)
+				}
+			} else {
+				if assert.Error(t, err) {
+					assert.Equal(t, example.err, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestInterpolatedValidation(t *testing.T) {
+	_, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo:${TAG}
+    apt:
+      packages: ["foo=${VERSION}"]
+    lives:
+      in: ${APP_DIR}
+    variants:
+      test: {}`))
+
+	assert.NoError(t, err)
+}
@@ -40,7 +40,7 @@ func (lives LivesConfig) InstructionsForPhase(phase build.Phase) []build.Instruc
 			build.NewUintArg("LIVES_UID", lives.UID),
 			build.NewUintArg("LIVES_GID", lives.GID),
 			build.RunAll{append(
-				build.CreateUser("$LIVES_AS", "$LIVES_UID", "$LIVES_GID"),
+				lives.setup("$LIVES_AS", "$LIVES_UID", "$LIVES_GID"),
 				build.CreateDirectory(lives.In),
 				build.Chown("$LIVES_UID", "$LIVES_GID", lives.In),
 				build.CreateDirectory(LocalLibPrefix),
@@ -69,20 +69,13 @@ func TestLivesConfigInstructions(t *testing.T) {
 				build.NewStringArg("LIVES_AS", "foouser"),
 				build.NewUintArg("LIVES_UID", 123),
 				build.NewUintArg("LIVES_GID", 223),
-				build.RunAll{[]build.Run{
-					{
-						"(getent group %s || groupadd -o -g %s -r %s)",
-						[]string{"$LIVES_GID", "$LIVES_GID", "$LIVES_AS"},
-					},
-					{
-						"(getent passwd %s || useradd -l -o -m -d %s -r -g %s -u %s %s)",
-						[]string{"$LIVES_UID", "/home/$LIVES_AS", "$LIVES_GID", "$LIVES_UID", "$LIVES_AS"},
-					},
-					{"mkdir -p", []string{"/some/directory"}},
-					{"chown %s:%s", []string{"$LIVES_UID", "$LIVES_GID", "/some/directory"}},
-					{"mkdir -p", []string{"/opt/lib"}},
-					{"chown %s:%s", []string{"$LIVES_UID", "$LIVES_GID", "/opt/lib"}},
-				}},
+				build.RunAll{append(
+					build.CreateUser("$LIVES_AS", "$LIVES_UID", "$LIVES_GID"),
+					build.Run{"mkdir -p", []string{"/some/directory"}},
+					build.Run{"chown %s:%s", []string{"$LIVES_UID", "$LIVES_GID", "/some/directory"}},
+					build.Run{"mkdir -p", []string{"/opt/lib"}},
+					build.Run{"chown %s:%s", []string{"$LIVES_UID", "$LIVES_GID", "/opt/lib"}},
+				)},
 			},
 			cfg.InstructionsForPhase(build.PhasePrivileged),
 		)
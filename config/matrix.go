@@ -0,0 +1,121 @@
+package config
+
+import (
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var matrixNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9.]+`)
+
+// MatrixConfig holds the values of variables over which a variant is
+// expanded. A variant is generated for each combination of values.
+type MatrixConfig map[string][]string
+
+// validate returns an error if a variable name is invalid or has no values,
+// which would otherwise silently remove the variant.
+func (mc MatrixConfig) validate() error {
+	for _, name := range slices.Sorted(maps.Keys(mc)) {
+		if !environmentVariableRegexp.MatchString(name) {
+			return errors.Errorf("%q is not a valid variable name", name)
+		}
+
+		if len(mc[name]) == 0 {
+			return errors.Errorf("variable %q has no values", name)
+		}
+	}
+
+	return nil
+}
+
+// combinations returns every combination of the matrix values, ordered by
+// variable name and then by the order of values.
+func (mc MatrixConfig) combinations() []map[string]string {
+	combinations := []map[string]string{{}}
+
+	for _, name := range slices.Sorted(maps.Keys(mc)) {
+		expanded := []map[string]string{}
+
+		for _, combination := range combinations {
+			for _, value := range mc[name] {
+				next := maps.Clone(combination)
+				next[name] = value
+				expanded = append(expanded, next)
+			}
+		}
+
+		combinations = expanded
+	}
+
+	return combinations
+}
+
+// variantName returns the name of the variant generated from the named
+// variant for the given combination of values. Values are appended in order
+// of variable name, with characters not allowed in variant names replaced by
+// dashes.
+func (mc MatrixConfig) variantName(name string, combination map[string]string) string {
+	parts := []string{name}
+
+	for _, variable := range slices.Sorted(maps.Keys(combination)) {
+		value := matrixNameRegexp.ReplaceAllString(combination[variable], "-")
+		parts = append(parts, strings.Trim(value, "-"))
+	}
+
+	return strings.Join(parts, "-")
+}
+
+// ExpandMatrices replaces each variant that defines a matrix with one variant
+// for each combination of its matrix values. References to matrix variables
+// in the configuration of the generated variants are interpolated (see
+// [VariantConfig.Interpolate]), and the variables are declared as arguments
+// so that they are also available to build processes.
+//
+// Expansion happens before includes and copies are resolved, so other
+// variants may reference the generated variants by name but not the original
+// one.
+func ExpandMatrices(config *Config) error {
+	for _, name := range slices.Sorted(maps.Keys(config.Variants)) {
+		vcfg := config.Variants[name]
+
+		if len(vcfg.Matrix) == 0 {
+			continue
+		}
+
+		if err := vcfg.Matrix.validate(); err != nil {
+			return errors.Wrapf(err, "invalid matrix of variant %q", name)
+		}
+
+		delete(config.Variants, name)
+
+		for _, combination := range vcfg.Matrix.combinations() {
+			generatedName := vcfg.Matrix.variantName(name, combination)
+
+			if _, exists := config.Variants[generatedName]; exists {
+				return errors.Errorf("variant %q generated by the matrix of %q already exists", generatedName, name)
+			}
+
+			generated := vcfg
+			generated.Matrix = nil
+
+			err := interpolator{variables: combination, partial: true}.value(reflect.ValueOf(&generated).Elem())
+
+			if err != nil {
+				return errors.Wrapf(err, "failed to expand the matrix of variant %q", name)
+			}
+
+			arguments := ArgumentsConfig{}
+			arguments.Merge(vcfg.Arguments)
+			arguments.Merge(combination)
+			generated.Arguments = arguments
+
+			config.Variants[generatedName] = generated
+		}
+	}
+
+	return nil
+}
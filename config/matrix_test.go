@@ -0,0 +1,135 @@
+package config_test
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+func TestMatrixConfigYAML(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    variants:
+      test:
+        matrix:
+          PYTHON_VERSION: ["3.9", "3.11"]
+          BASE: [foo, "docker-registry.wikimedia.org/bar:latest"]
+        base: ${BASE}
+        python:
+          version: python${PYTHON_VERSION}
+        runs:
+          environment:
+            ARCH: ${TARGETARCH}
+            LITERAL: $${PYTHON_VERSION}
+      all:
+        includes: [test-foo-3.11]`))
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t,
+		[]string{
+			"all",
+			"test-docker-registry.wikimedia.org-bar-latest-3.11",
+			"test-docker-registry.wikimedia.org-bar-latest-3.9",
+			"test-foo-3.11",
+			"test-foo-3.9",
+		},
+		slices.Sorted(maps.Keys(cfg.Variants)),
+	)
+
+	variant := cfg.Variants["test-foo-3.11"]
+
+	assert.Equal(t, "foo", variant.Base)
+	assert.Equal(t, "python3.11", variant.Python.Version)
+	assert.Equal(t, "${TARGETARCH}", variant.Runs.Environment["ARCH"])
+	assert.Equal(t, "$${PYTHON_VERSION}", variant.Runs.Environment["LITERAL"])
+	assert.Equal(t, config.ArgumentsConfig{"BASE": "foo", "PYTHON_VERSION": "3.11"}, variant.Arguments)
+	assert.Nil(t, variant.Matrix)
+
+	assert.Equal(t, "docker-registry.wikimedia.org/bar:latest", cfg.Variants["test-docker-registry.wikimedia.org-bar-latest-3.9"].Base)
+
+	if assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "all")) {
+		all, err := config.GetVariant(cfg, "all")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "python3.11", all.Python.Version)
+		}
+	}
+}
+
+func TestMatrixConfigConflict(t *testing.T) {
+	_, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    variants:
+      test:
+        matrix:
+          VERSION: ["1"]
+      test-1: {}`))
+
+	if assert.Error(t, err) {
+		assert.Equal(t, `variant "test-1" generated by the matrix of "test" already exists`, err.Error())
+	}
+}
+
+func TestMatrixConfigInvalid(t *testing.T) {
+	t.Run("variable name", func(t *testing.T) {
+		_, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    variants:
+      test:
+        matrix:
+          NOT-A-VARIABLE: ["1"]`))
+
+		assert.Error(t, err)
+	})
+
+	t.Run("no values", func(t *testing.T) {
+		_, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    variants:
+      test:
+        matrix:
+          VERSION: []`))
+
+		assert.Error(t, err)
+	})
+
+	t.Run("expansion", func(t *testing.T) {
+		examples := []struct {
+			matrix config.MatrixConfig
+			err    string
+		}{
+			{
+				config.MatrixConfig{"NOT-A-VARIABLE": {"1"}},
+				`invalid matrix of variant "test": "NOT-A-VARIABLE" is not a valid variable name`,
+			},
+			{
+				config.MatrixConfig{"VERSION": {"1"}, "OS": {}},
+				`invalid matrix of variant "test": variable "OS" has no values`,
+			},
+		}
+
+		for _, example := range examples {
+			variant := config.NewVariantConfig("test")
+			variant.Matrix = example.matrix
+
+			cfg := &config.Config{Variants: map[string]config.VariantConfig{"test": *variant}}
+			err := config.ExpandMatrices(cfg)
+
+			if assert.Error(t, err) {
+				assert.Equal(t, example.err, err.Error())
+				assert.Contains(t, cfg.Variants, "test")
+			}
+		}
+	})
+}
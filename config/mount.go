@@ -50,8 +50,8 @@ func (mcs *MountsConfig) UnmarshalJSON(unmarshal []byte) error {
 // [BuilderConfig] during execution.
 type MountConfig struct {
 	From        string `json:"from"`
-	Destination string `json:"destination" validate:"omitempty"`
-	Source      string `json:"source" validate:"omitempty"`
+	Destination string `json:"destination" validate:"omitempty" interpolate:"deferred"`
+	Source      string `json:"source" validate:"omitempty" interpolate:"deferred"`
 }
 
 // RunOptions returns a number of [build.RunOption] for the mount.
@@ -67,10 +67,12 @@ func (mc MountConfig) RunOptions() []build.RunOption {
 }
 
 // Dependencies returns the variant dependencies of the mount.
+//
+// Note that a mount can reference things other than another variant (e.g.
+// an external image or the local build context). References that are not
+// to defined variants are filtered out when building the dependency graph.
 func (mc MountConfig) Dependencies() []string {
-	// Since a mount can reference things other than another variant, only
-	// return references that match the variant name validation regexp.
-	if !variantNameRegexp.MatchString(mc.From) {
+	if mc.From == "" || mc.From == LocalArtifactKeyword {
 		return []string{}
 	}
 
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/containerd/containerd/platforms"
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// PlatformsConfig holds common configuration overrides keyed by the target
+// platform (e.g. "linux/arm64") to which they apply.
+type PlatformsConfig map[string]CommonConfig
+
+// Dependencies returns variant dependencies of all platform overrides.
+func (pc PlatformsConfig) Dependencies() []string {
+	deps := []string{}
+
+	for _, name := range slices.Sorted(maps.Keys(pc)) {
+		cc := pc[name]
+		deps = append(deps, cc.Dependencies()...)
+	}
+
+	return deps
+}
+
+// Merge takes another PlatformsConfig and merges the overrides for each of
+// its platforms into this one's.
+func (pc *PlatformsConfig) Merge(pc2 PlatformsConfig) {
+	if pc2 == nil {
+		return
+	}
+
+	if *pc == nil {
+		*pc = make(PlatformsConfig)
+	}
+
+	for name, cc2 := range pc2 {
+		cc := (*pc)[name]
+		cc.Merge(cc2)
+		(*pc)[name] = cc
+	}
+}
+
+// ForPlatform returns the overrides that apply to the given target platform,
+// in order of their platform specifiers. Specifiers are matched against the
+// normalized platform, so that "linux/arm" matches "linux/arm/v7" for
+// example.
+func (pc PlatformsConfig) ForPlatform(platform oci.Platform) []CommonConfig {
+	overrides := []CommonConfig{}
+
+	for _, name := range slices.Sorted(maps.Keys(pc)) {
+		specifier, err := platforms.Parse(name)
+
+		if err != nil {
+			continue
+		}
+
+		if platforms.NewMatcher(specifier).Match(platforms.Normalize(platform)) {
+			overrides = append(overrides, pc[name])
+		}
+	}
+
+	return overrides
+}
+
+// MergePlatform merges the overrides for the given target platform into the
+// variant's common configuration, after which the variant no longer has any
+// platform overrides. Overrides take precedence over all other configuration
+// of the variant, including that of the variant itself.
+func (vc *VariantConfig) MergePlatform(platform oci.Platform) {
+	overrides := vc.Platforms.ForPlatform(platform)
+	vc.Platforms = nil
+
+	for _, cc := range overrides {
+		cc.Platforms = nil
+		vc.CommonConfig.Merge(cc)
+	}
+}
@@ -0,0 +1,142 @@
+package config_test
+
+import (
+	"testing"
+
+	oci "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+func TestPlatformsConfigYAML(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    apt:
+      packages: [curl]
+    platforms:
+      linux/arm64:
+        base: foo-arm64
+        apt:
+          packages: [libfoo-arm64]
+    variants:
+      build:
+        platforms:
+          linux/arm64:
+            apt:
+              packages: [gcc-aarch64-linux-gnu]
+          linux/arm:
+            base: foo-arm
+      production:
+        base: bar`))
+
+	if !assert.NoError(t, err) ||
+		!assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "build")) ||
+		!assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "production")) {
+		return
+	}
+
+	arm64 := oci.Platform{OS: "linux", Architecture: "arm64"}
+	armv7 := oci.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	amd64 := oci.Platform{OS: "linux", Architecture: "amd64"}
+
+	variant := func(t *testing.T, name string, platform oci.Platform) *config.VariantConfig {
+		vcfg, err := config.GetVariant(cfg, name)
+
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		vcfg.MergePlatform(platform)
+
+		return vcfg
+	}
+
+	t.Run("build/arm64", func(t *testing.T) {
+		vcfg := variant(t, "build", arm64)
+
+		assert.Equal(t, "foo-arm64", vcfg.Base)
+		assert.Equal(t, []string{"curl", "libfoo-arm64", "gcc-aarch64-linux-gnu"}, vcfg.Apt.Packages["default"])
+		assert.Nil(t, vcfg.Platforms)
+	})
+
+	t.Run("build/arm/v7", func(t *testing.T) {
+		vcfg := variant(t, "build", armv7)
+
+		assert.Equal(t, "foo-arm", vcfg.Base)
+		assert.Equal(t, []string{"curl"}, vcfg.Apt.Packages["default"])
+	})
+
+	t.Run("build/amd64", func(t *testing.T) {
+		vcfg := variant(t, "build", amd64)
+
+		assert.Equal(t, "foo", vcfg.Base)
+		assert.Equal(t, []string{"curl"}, vcfg.Apt.Packages["default"])
+	})
+
+	t.Run("production/arm64", func(t *testing.T) {
+		vcfg := variant(t, "production", arm64)
+
+		assert.Equal(t, "foo-arm64", vcfg.Base)
+	})
+
+	t.Run("leaves the config untouched", func(t *testing.T) {
+		variant(t, "build", arm64)
+
+		vcfg := variant(t, "build", amd64)
+
+		assert.Equal(t, []string{"curl"}, vcfg.Apt.Packages["default"])
+	})
+}
+
+func TestPlatformsConfigNested(t *testing.T) {
+	_, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    platforms:
+      linux/arm64:
+        platforms:
+          linux/arm64: {}
+    variants:
+      build: {}`))
+
+	assert.Error(t, err)
+}
+
+func TestPlatformsConfigValidation(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		err := config.Validate(config.CommonConfig{
+			Platforms: config.PlatformsConfig{
+				"linux/arm64":  {},
+				"linux/arm/v7": {},
+			},
+		})
+
+		assert.False(t, config.IsValidationError(err))
+	})
+
+	t.Run("bad platform", func(t *testing.T) {
+		err := config.Validate(config.CommonConfig{
+			Platforms: config.PlatformsConfig{
+				"linux/not an arch": {},
+			},
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, `platforms[linux/not an arch]: "linux/not an arch" is not a valid platform (e.g. "linux/arm64")`, msg)
+		}
+	})
+
+	t.Run("bad override", func(t *testing.T) {
+		err := config.Validate(config.CommonConfig{
+			Platforms: config.PlatformsConfig{
+				"linux/arm64": {Lives: config.LivesConfig{In: "/"}},
+			},
+		})
+
+		assert.True(t, config.IsValidationError(err))
+	})
+}
@@ -28,28 +28,28 @@ type PythonConfig struct {
 	UseNoDepsFlag Flag `json:"no-deps"`
 
 	// Specify a specific version of pip to install (T418253)
-	PipVersion string `json:"pip-version"`
+	PipVersion string `json:"pip-version" interpolate:"false"`
 
 	// Use Poetry for package management
 	Poetry PoetryConfig `json:"poetry"`
 
 	// Specify a specific version of setuptools to install (T418253)
-	SetuptoolsVersion string `json:"setuptools-version"`
+	SetuptoolsVersion string `json:"setuptools-version" interpolate:"false"`
 
 	// Specify a specific version of tox to install (T346226)
-	ToxVersion string `json:"tox-version"`
+	ToxVersion string `json:"tox-version" interpolate:"false"`
 
 	// Specify an existing venv path
 	Venv string `json:"venv"`
 
 	// Specify a specific version of wheel to install (T418253)
-	WheelVersion string `json:"wheel-version"`
+	WheelVersion string `json:"wheel-version" interpolate:"false"`
 }
 
 // PoetryConfig holds configuration fields related to installation of project
 // dependencies via Poetry.
 type PoetryConfig struct {
-	Version string `json:"version" validate:"omitempty,pypkgver"`
+	Version string `json:"version" validate:"omitempty,pypkgver" interpolate:"false"`
 	Devel   Flag   `json:"devel"`
 	Only    string `json:"only" validate:"omitempty"`
 	Without string `json:"without" validate:"omitempty"`
@@ -2,9 +2,12 @@ package config
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"path"
+	"slices"
 
 	"github.com/ghodss/yaml"
 	"github.com/santhosh-tekuri/jsonschema/v5"
@@ -121,6 +124,24 @@ func buildCopiesDepGraph(config *Config) {
 	config.CopiesDepGraph = graph
 }
 
+// GetExternalImages returns the image references (i.e. dependencies that
+// are not defined variants) used by copies and mounts of the given variant.
+func GetExternalImages(config *Config, vcfg *VariantConfig) []string {
+	images := []string{}
+
+	for _, dependency := range vcfg.Dependencies() {
+		if _, exists := config.Variants[dependency]; exists {
+			continue
+		}
+
+		if dependency != LocalArtifactKeyword && !slices.Contains(images, dependency) {
+			images = append(images, dependency)
+		}
+	}
+
+	return images
+}
+
 // GetVariant retrieves a requested *VariantConfig from the main config
 func GetVariant(config *Config, name string) (*VariantConfig, error) {
 	variant := NewVariantConfig(name)
@@ -139,16 +160,36 @@ func IsUnmarshalTypeError(err error) bool {
 
 // ReadYAMLConfig converts YAML bytes to json and returns new Config struct.
 func ReadYAMLConfig(data []byte) (*Config, error) {
+	return readYAMLConfig(context.Background(), data, nil)
+}
+
+// ReadYAMLConfigWithImports converts YAML bytes to json and returns a new
+// Config struct, reading any imported config files with the given reader. The
+// given path is that of the config file and is used to resolve the paths of
+// imports relative to it.
+func ReadYAMLConfigWithImports(ctx context.Context, data []byte, cfgPath string, reader ImportReader) (*Config, error) {
+	return readYAMLConfig(ctx, data, &importer{
+		read:  reader,
+		graph: NewDepGraph(),
+		path:  path.Clean(cfgPath),
+	})
+}
+
+func readYAMLConfig(ctx context.Context, data []byte, imp *importer) (*Config, error) {
 	jsonData, err := yaml.YAMLToJSON(data)
 	if err != nil {
 		return nil, err
 	}
 
-	return ReadConfig(jsonData)
+	return readConfig(ctx, jsonData, imp)
 }
 
 // ReadConfig unmarshals the given YAML bytes into a new Config struct.
 func ReadConfig(data []byte) (*Config, error) {
+	return readConfig(context.Background(), data, nil)
+}
+
+func readConfig(ctx context.Context, data []byte, imp *importer) (*Config, error) {
 	var (
 		version VersionConfig
 		config  Config
@@ -190,13 +231,28 @@ func ReadConfig(data []byte) (*Config, error) {
 		return nil, err
 	}
 
+	if len(config.Imports) > 0 {
+		err = imp.resolve(ctx, &config, data)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = ExpandMatrices(&config)
+
+	if err != nil {
+		return nil, err
+	}
+
 	err = Validate(config)
 
 	return &config, err
 }
 
 // ReadConfigFile unmarshals the given YAML file contents into a Config
-// struct.
+// struct. Imported config files are read relative to it. Imports from named
+// build contexts are not supported.
 func ReadConfigFile(path string) (*Config, error) {
 	data, err := ioutil.ReadFile(path)
 
@@ -204,5 +260,15 @@ func ReadConfigFile(path string) (*Config, error) {
 		return nil, err
 	}
 
-	return ReadYAMLConfig(data)
+	return ReadYAMLConfigWithImports(context.Background(), data, path, ReadImportFile)
+}
+
+// ReadImportFile is an [ImportReader] that reads config files from the local
+// filesystem. Imports from named build contexts are not supported.
+func ReadImportFile(_ context.Context, buildContext string, path string) ([]byte, error) {
+	if buildContext != "" {
+		return nil, fmt.Errorf("cannot import from build context %q outside of a build", buildContext)
+	}
+
+	return ioutil.ReadFile(path)
 }
@@ -228,3 +228,38 @@ func TestGetVariant(t *testing.T) {
 	assert.Equal(t, uint(123), dev.Runs.UID)
 
 }
+
+func TestGetExternalImages(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    variants:
+      build: {}
+      production:
+        copies:
+          - from: build
+          - from: docker-registry.wikimedia.org/foo:1.0
+            source: /foo
+          - from: local
+        builders:
+          - custom:
+              command: [make]
+              mounts:
+                - from: docker-registry.wikimedia.org/bar:2.0
+                - from: docker-registry.wikimedia.org/foo:1.0
+                - local`))
+
+	if assert.NoError(t, err) {
+		vcfg, err := config.GetVariant(cfg, "production")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t,
+				[]string{
+					"docker-registry.wikimedia.org/foo:1.0",
+					"docker-registry.wikimedia.org/bar:2.0",
+				},
+				config.GetExternalImages(cfg, vcfg),
+			)
+		}
+	}
+}
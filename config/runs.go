@@ -8,22 +8,29 @@ import (
 // runtime environment.
 type RunsConfig struct {
 	UserConfig  `json:",inline"`
-	Environment map[string]string `json:"environment" validate:"envvars"`  // environment variables
-	In          string            `json:"in" validate:"omitempty,abspath"` // runtime directory
-	Insecurely  Flag              `json:"insecurely"`                      // runs user owns application files
+	Environment map[string]string `json:"environment" validate:"envvars" interpolate:"runtime"` // environment variables
+	In          string            `json:"in" validate:"omitempty,abspath"`                      // runtime directory
+	Insecurely  Flag              `json:"insecurely"`                                           // runs user owns application files
+	Hardening   HardeningConfig   `json:"hardening"`                                            // security checks of the final image
+	Writable    []string          `json:"writable" validate:"dive,abspath"`                     // directories owned by the runtime user
 }
 
 // Merge takes another RunsConfig and overwrites this struct's fields. All
-// fields except Environment are overwritten if set. The latter is an additive
-// merge.
+// fields except Environment and Writable are overwritten if set. The latter
+// are additive merges.
 func (run *RunsConfig) Merge(run2 RunsConfig) {
 	run.UserConfig.Merge(run2.UserConfig)
 	run.Insecurely.Merge(run2.Insecurely)
+	run.Hardening.Merge(run2.Hardening)
 
 	if run2.In != "" {
 		run.In = run2.In
 	}
 
+	if run2.Writable != nil {
+		run.Writable = append(run.Writable, run2.Writable...)
+	}
+
 	if run.Environment == nil {
 		run.Environment = make(map[string]string)
 	}
@@ -40,22 +47,44 @@ func (run *RunsConfig) Merge(run2 RunsConfig) {
 //
 // Creates LocalLibPrefix directory and unprivileged user home directory,
 // creates the unprivileged user and its group, and sets up directory
-// permissions.
+// permissions. Creates the writable directories and hands their ownership to
+// the runtime user.
 //
 // # PhasePrivilegeDropped
 //
 // Injects build.Env instructions for all names/values defined by
 // RunsConfig.Environment.
+//
+// # PhasePostInstall
+//
+// Recursively hands ownership of the writable directories, which may have
+// been overwritten by copied application files, back to the runtime user.
+// Sets the runtime working directory.
+//
+// # PhaseFinal
+//
+// Runs the hardening checks, if enabled, for the runtime user. See
+// [HardeningConfig.Instructions].
 func (run RunsConfig) InstructionsForPhase(phase build.Phase) []build.Instruction {
+	uid, gid := run.runtimeUser()
+
 	switch phase {
 	case build.PhasePrivileged:
+		runs := run.setup("$RUNS_AS", "$RUNS_UID", "$RUNS_GID")
+
+		if len(run.Writable) > 0 {
+			runs = append(runs, build.CreateDirectories(run.Writable))
+
+			for _, dir := range run.Writable {
+				runs = append(runs, build.Chown(uid, gid, dir))
+			}
+		}
+
 		return []build.Instruction{
 			build.NewStringArg("RUNS_AS", run.As),
 			build.NewUintArg("RUNS_UID", run.UID),
 			build.NewUintArg("RUNS_GID", run.GID),
-			build.RunAll{
-				build.CreateUser("$RUNS_AS", "$RUNS_UID", "$RUNS_GID"),
-			},
+			build.RunAll{runs},
 		}
 	case build.PhasePrivilegeDropped:
 		if len(run.Environment) > 0 {
@@ -64,12 +93,37 @@ func (run RunsConfig) InstructionsForPhase(phase build.Phase) []build.Instructio
 			}
 		}
 	case build.PhasePostInstall:
+		ins := []build.Instruction{}
+
+		if len(run.Writable) > 0 {
+			ins = append(ins, build.RunAllWithOptions{
+				Runs: []build.Run{
+					build.CreateDirectories(run.Writable),
+					build.ChownRecursive(uid, gid, run.Writable),
+				},
+				Options: []build.RunOption{build.RunAs{User: "0"}},
+			})
+		}
+
 		if run.In != "" {
-			return []build.Instruction{
-				build.WorkingDirectory{run.In},
-			}
+			ins = append(ins, build.WorkingDirectory{run.In})
 		}
+
+		return ins
+	case build.PhaseFinal:
+		// HOME is that of the runtime user by the final phase
+		return run.Hardening.Instructions(uid, gid, append([]string{"$HOME"}, run.Writable...))
 	}
 
 	return []build.Instruction{}
 }
+
+// runtimeUser returns references to the UID and GID of the user that runs
+// the application, which is the lives user when running insecurely.
+func (run RunsConfig) runtimeUser() (uid string, gid string) {
+	if run.Insecurely.True {
+		return "$LIVES_UID", "$LIVES_GID"
+	}
+
+	return "$RUNS_UID", "$RUNS_GID"
+}
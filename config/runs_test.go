@@ -63,10 +63,7 @@ func TestRunsConfigInstructions(t *testing.T) {
 				build.NewStringArg("RUNS_AS", "someuser"),
 				build.NewUintArg("RUNS_UID", 666),
 				build.NewUintArg("RUNS_GID", 777),
-				build.RunAll{[]build.Run{
-					{"(getent group %s || groupadd -o -g %s -r %s)", []string{"$RUNS_GID", "$RUNS_GID", "$RUNS_AS"}},
-					{"(getent passwd %s || useradd -l -o -m -d %s -r -g %s -u %s %s)", []string{"$RUNS_UID", "/home/$RUNS_AS", "$RUNS_GID", "$RUNS_UID", "$RUNS_AS"}},
-				}},
+				build.RunAll{build.CreateUser("$RUNS_AS", "$RUNS_UID", "$RUNS_GID")},
 			},
 			cfg.InstructionsForPhase(build.PhasePrivileged),
 		)
@@ -101,6 +98,68 @@ func TestRunsConfigInstructions(t *testing.T) {
 	})
 }
 
+func TestRunsConfigWritable(t *testing.T) {
+	cfg := config.RunsConfig{
+		Writable: []string{"/srv/app/cache", "/srv/app/uploads"},
+	}
+
+	t.Run("PhasePrivileged", func(t *testing.T) {
+		ins := cfg.InstructionsForPhase(build.PhasePrivileged)
+
+		require.Len(t, ins, 4)
+		assert.Equal(t,
+			build.RunAll{append(
+				build.CreateUser("$RUNS_AS", "$RUNS_UID", "$RUNS_GID"),
+				build.Run{"mkdir -p", []string{"/srv/app/cache", "/srv/app/uploads"}},
+				build.Run{"chown %s:%s", []string{"$RUNS_UID", "$RUNS_GID", "/srv/app/cache"}},
+				build.Run{"chown %s:%s", []string{"$RUNS_UID", "$RUNS_GID", "/srv/app/uploads"}},
+			)},
+			ins[3],
+		)
+	})
+
+	t.Run("PhasePostInstall", func(t *testing.T) {
+		assert.Equal(t,
+			[]build.Instruction{
+				build.RunAllWithOptions{
+					Runs: []build.Run{
+						{"mkdir -p", []string{"/srv/app/cache", "/srv/app/uploads"}},
+						{"chown -R %s:%s", []string{"$RUNS_UID", "$RUNS_GID", "/srv/app/cache", "/srv/app/uploads"}},
+					},
+					Options: []build.RunOption{build.RunAs{User: "0"}},
+				},
+			},
+			cfg.InstructionsForPhase(build.PhasePostInstall),
+		)
+	})
+
+	t.Run("PhasePostInstall running insecurely", func(t *testing.T) {
+		cfg := cfg
+		cfg.Insecurely = config.Flag{True: true, Set: true}
+
+		ins := cfg.InstructionsForPhase(build.PhasePostInstall)
+
+		require.Len(t, ins, 1)
+		assert.Equal(t,
+			build.Run{"chown -R %s:%s", []string{"$LIVES_UID", "$LIVES_GID", "/srv/app/cache", "/srv/app/uploads"}},
+			ins[0].(build.RunAllWithOptions).Runs[1],
+		)
+	})
+
+	t.Run("PhaseFinal with hardening", func(t *testing.T) {
+		cfg := cfg
+		cfg.Hardening = config.HardeningConfig{Enabled: config.Flag{True: true, Set: true}}
+
+		ins := cfg.InstructionsForPhase(build.PhaseFinal)
+
+		require.Len(t, ins, 1)
+		assert.Contains(t,
+			string(ins[0].(build.RunScript).Script),
+			`\( -path "$HOME" -o -path "/srv/app/cache" -o -path "/srv/app/uploads" \) -prune`,
+		)
+	})
+}
+
 func TestRunsConfigValidation(t *testing.T) {
 	t.Run("environment", func(t *testing.T) {
 		t.Run("ok", func(t *testing.T) {
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+)
+
+// Section is a number of build instructions that originate from the same
+// configuration field (e.g. "apt" or "copies").
+type Section struct {
+	Name         string
+	Instructions []build.Instruction
+}
+
+// Sections holds an ordered number of [Section].
+type Sections []Section
+
+// Instructions returns the instructions of all sections in order.
+func (sections Sections) Instructions() []build.Instruction {
+	instructions := []build.Instruction{}
+
+	for _, section := range sections {
+		instructions = append(instructions, section.Instructions...)
+	}
+
+	return instructions
+}
+
+// ApplyUser wraps the copy instructions of every section using
+// [build.ApplyUser].
+func (sections Sections) ApplyUser(uid string, gid string) Sections {
+	applied := make(Sections, len(sections))
+
+	for i, section := range sections {
+		applied[i] = Section{
+			Name:         section.Name,
+			Instructions: build.ApplyUser(uid, gid, section.Instructions),
+		}
+	}
+
+	return applied
+}
+
+// appendSection appends a new section of the given name if there are any
+// instructions.
+func (sections Sections) appendSection(name string, instructions ...build.Instruction) Sections {
+	if len(instructions) == 0 {
+		return sections
+	}
+
+	return append(sections, Section{Name: name, Instructions: instructions})
+}
+
+// prependSection prepends a new section of the given name if there are any
+// instructions.
+func (sections Sections) prependSection(name string, instructions ...build.Instruction) Sections {
+	if len(instructions) == 0 {
+		return sections
+	}
+
+	return append(Sections{{Name: name, Instructions: instructions}}, sections...)
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+)
+
+// SlimConfig holds configuration for removing files that are not needed at
+// runtime from the final image and for squashing its layers.
+type SlimConfig struct {
+	// Docs removes documentation, man pages and info pages
+	Docs Flag `json:"docs"`
+
+	// Locales removes translations of system packages
+	Locales Flag `json:"locales"`
+
+	// Caches removes the pip and npm caches of all users
+	Caches Flag `json:"caches"`
+
+	// Paths is a list of additional absolute paths or globs to remove. The
+	// top-level directory of each path must be given literally.
+	Paths []string `json:"paths" validate:"dive,removepath"`
+
+	// Squash squashes the layers added on top of the base image into a
+	// single layer so that files removed from previous layers no longer take
+	// up space
+	Squash Flag `json:"squash"`
+}
+
+// globChars are the characters that make a path to remove a glob.
+const globChars = "*?["
+
+// bracketExpressionRegexp matches the bracket expressions of globs that are
+// left unquoted when removing paths.
+var bracketExpressionRegexp = regexp.MustCompile(`^\[[!^]?[a-zA-Z0-9._\-]+\]`)
+
+var (
+	// SlimDocPaths are removed when [SlimConfig.Docs] is enabled.
+	SlimDocPaths = []string{
+		"/usr/share/doc/*",
+		"/usr/share/man/*",
+		"/usr/share/info/*",
+		"/usr/share/groff/*",
+		"/usr/share/lintian/*",
+	}
+
+	// SlimLocalePaths are removed when [SlimConfig.Locales] is enabled.
+	SlimLocalePaths = []string{
+		"/usr/share/locale/*",
+	}
+
+	// SlimCachePaths are removed when [SlimConfig.Caches] is enabled.
+	SlimCachePaths = []string{
+		"/root/.cache/pip",
+		"/root/.npm",
+		"/home/*/.cache/pip",
+		"/home/*/.npm",
+	}
+)
+
+// Merge takes another SlimConfig and merges its fields into this one's.
+func (slim *SlimConfig) Merge(slim2 SlimConfig) {
+	slim.Docs.Merge(slim2.Docs)
+	slim.Locales.Merge(slim2.Locales)
+	slim.Caches.Merge(slim2.Caches)
+	slim.Squash.Merge(slim2.Squash)
+
+	if slim2.Paths != nil {
+		slim.Paths = append(slim.Paths, slim2.Paths...)
+	}
+}
+
+// InstructionsForPhase injects build instructions that remove files and
+// squash layers during the final phase.
+//
+// # PhaseFinal
+//
+// Removes the configured files as root, then squashes the layers added on top
+// of the base image into a single layer if configured to do so.
+func (slim SlimConfig) InstructionsForPhase(phase build.Phase) []build.Instruction {
+	ins := []build.Instruction{}
+
+	switch phase {
+	case build.PhaseFinal:
+		paths := []string{}
+
+		if slim.Docs.True {
+			paths = append(paths, SlimDocPaths...)
+		}
+
+		if slim.Locales.True {
+			paths = append(paths, SlimLocalePaths...)
+		}
+
+		if slim.Caches.True {
+			paths = append(paths, SlimCachePaths...)
+		}
+
+		paths = append(paths, slim.Paths...)
+
+		if len(paths) > 0 {
+			quoted := make([]string, len(paths))
+			for i, p := range paths {
+				quoted[i] = quoteGlob(p)
+			}
+
+			ins = append(ins, build.RunAllWithOptions{
+				Runs: []build.Run{
+					{"rm -rf " + strings.Join(quoted, " "), []string{}},
+				},
+				Options: []build.RunOption{build.RunAs{User: "0"}},
+			})
+		}
+
+		if slim.Squash.True {
+			ins = append(ins, build.Squash{})
+		}
+	}
+
+	return ins
+}
+
+// quoteGlob single quotes the given path for the shell, leaving only glob
+// characters and bracket expressions (e.g. "[a-z]") unquoted so that they are
+// still expanded.
+func quoteGlob(p string) string {
+	var quoted strings.Builder
+	literal := false
+
+	toggle := func(glob bool) {
+		if glob == literal {
+			quoted.WriteByte('\'')
+			literal = !literal
+		}
+	}
+
+	for i := 0; i < len(p); i++ {
+		if p[i] == '[' {
+			if m := bracketExpressionRegexp.FindString(p[i:]); m != "" {
+				toggle(true)
+				quoted.WriteString(m)
+				i += len(m) - 1
+				continue
+			}
+		}
+
+		toggle(p[i] == '*' || p[i] == '?')
+
+		if p[i] == '\'' {
+			quoted.WriteString(`'\''`)
+		} else {
+			quoted.WriteByte(p[i])
+		}
+	}
+
+	toggle(true)
+
+	return quoted.String()
+}
@@ -0,0 +1,101 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+)
+
+func TestSlimConfigYAML(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    slim:
+      docs: true
+      paths: [/srv/app/tests]
+    variants:
+      production:
+        slim:
+          locales: true
+          caches: true
+          squash: true
+          paths: [/srv/app/docs]`))
+
+	require.NoError(t, err)
+
+	require.NoError(t, config.ExpandIncludesAndCopies(cfg, "production"))
+
+	variant, err := config.GetVariant(cfg, "production")
+	require.NoError(t, err)
+
+	assert.Equal(t,
+		config.SlimConfig{
+			Docs:    config.Flag{True: true, Set: true},
+			Locales: config.Flag{True: true, Set: true},
+			Caches:  config.Flag{True: true, Set: true},
+			Squash:  config.Flag{True: true, Set: true},
+			Paths:   []string{"/srv/app/tests", "/srv/app/docs"},
+		},
+		variant.Slim,
+	)
+}
+
+func TestSlimConfigInstructions(t *testing.T) {
+	cfg := config.SlimConfig{
+		Docs:   config.Flag{True: true, Set: true},
+		Caches: config.Flag{True: true, Set: true},
+		Squash: config.Flag{True: true, Set: true},
+		Paths:  []string{"/srv/app/tests", "/srv/app/it's here", "/srv/app/*.[a-z]", "/srv/app/[foo"},
+	}
+
+	t.Run("PhaseFinal", func(t *testing.T) {
+		assert.Equal(t,
+			[]build.Instruction{
+				build.RunAllWithOptions{
+					Runs: []build.Run{{
+						"rm -rf '/usr/share/doc/'* '/usr/share/man/'* '/usr/share/info/'* '/usr/share/groff/'* '/usr/share/lintian/'* " +
+							"'/root/.cache/pip' '/root/.npm' '/home/'*'/.cache/pip' '/home/'*'/.npm' " +
+							`'/srv/app/tests' '/srv/app/it'\''s here' '/srv/app/'*'.'[a-z] '/srv/app/[foo'`,
+						[]string{},
+					}},
+					Options: []build.RunOption{build.RunAs{User: "0"}},
+				},
+				build.Squash{},
+			},
+			cfg.InstructionsForPhase(build.PhaseFinal),
+		)
+	})
+
+	t.Run("PhasePostInstall", func(t *testing.T) {
+		assert.Empty(t, cfg.InstructionsForPhase(build.PhasePostInstall))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.Empty(t, config.SlimConfig{}.InstructionsForPhase(build.PhaseFinal))
+	})
+}
+
+func TestSlimConfigValidation(t *testing.T) {
+	err := config.Validate(config.SlimConfig{
+		Paths: []string{"/srv/app/tests", "relative/path", "/", "/*", "//*", "/u*/share", "/srv/../*"},
+	})
+
+	if assert.True(t, config.IsValidationError(err)) {
+		assert.Equal(t,
+			strings.Join([]string{
+				`paths[1]: "relative/path" is not a valid absolute path with a literal top-level directory`,
+				`paths[2]: "/" is not a valid absolute path with a literal top-level directory`,
+				`paths[3]: "/*" is not a valid absolute path with a literal top-level directory`,
+				`paths[4]: "//*" is not a valid absolute path with a literal top-level directory`,
+				`paths[5]: "/u*/share" is not a valid absolute path with a literal top-level directory`,
+				`paths[6]: "/srv/../*" is not a valid absolute path with a literal top-level directory`,
+			}, "\n"),
+			config.HumanizeValidationError(err),
+		)
+	}
+}
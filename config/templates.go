@@ -0,0 +1,103 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+)
+
+// TemplateConfig declares a file of the local build context that is rendered
+// into the image with variable references substituted.
+type TemplateConfig struct {
+	Source      string `json:"source" validate:"required"`
+	Destination string `json:"destination" validate:"required" interpolate:"false"`
+
+	content []byte
+}
+
+// TemplatesConfig holds configuration for files rendered into the image.
+type TemplatesConfig []TemplateConfig
+
+// ContextFileReader reads the file at the given path of the local build
+// context.
+type ContextFileReader func(ctx context.Context, path string) ([]byte, error)
+
+// Merge appends the given templates to these ones.
+func (tc *TemplatesConfig) Merge(tc2 TemplatesConfig) {
+	*tc = append(*tc, tc2...)
+}
+
+// Expand returns a version of this TemplatesConfig with relative destinations
+// resolved against the given application directory.
+func (tc TemplatesConfig) Expand(appDirectory string) TemplatesConfig {
+	expanded := make(TemplatesConfig, len(tc))
+
+	for i, template := range tc {
+		if !path.IsAbs(template.Destination) {
+			template.Destination = path.Join(appDirectory, template.Destination)
+		}
+
+		expanded[i] = template
+	}
+
+	return expanded
+}
+
+// Render reads the source of each template using the given reader and
+// substitutes references to the given variables, using the same syntax as
+// [VariantConfig.Interpolate]. It returns a rendered copy of the templates.
+func (tc TemplatesConfig) Render(ctx context.Context, read ContextFileReader, variables map[string]string) (TemplatesConfig, error) {
+	rendered := make(TemplatesConfig, len(tc))
+
+	for i, template := range tc {
+		if read == nil {
+			return nil, errors.New("templates cannot be read from the build context")
+		}
+
+		data, err := read(ctx, template.Source)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read template %s", template.Source)
+		}
+
+		content, err := interpolator{variables: variables}.string(string(data))
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to render template %s", template.Source)
+		}
+
+		template.content = []byte(content)
+		rendered[i] = template
+	}
+
+	return rendered, nil
+}
+
+// InstructionsForPhase injects instructions that write the rendered
+// templates.
+//
+// # PhaseInstall
+//
+// Writes each rendered template to its destination. Templates are owned by
+// root and readable by all users.
+func (tc TemplatesConfig) InstructionsForPhase(phase build.Phase) []build.Instruction {
+	ins := []build.Instruction{}
+
+	if phase != build.PhaseInstall {
+		return ins
+	}
+
+	for _, template := range tc {
+		ins = append(ins, build.File{
+			Path:    template.Destination,
+			Mode:    os.FileMode(0o644),
+			Content: template.content,
+		})
+	}
+
+	return ins
+}
@@ -0,0 +1,183 @@
+package config_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+	"gitlab.wikimedia.org/repos/releng/blubber/config"
+	"gitlab.wikimedia.org/repos/releng/blubber/util/testtarget"
+)
+
+func TestTemplatesConfigYAML(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    variants:
+      base:
+        templates:
+          - source: version.json.tmpl
+            destination: version.json
+      production:
+        includes: [base]
+        templates:
+          - source: motd.tmpl
+            destination: /etc/motd`))
+
+	if assert.NoError(t, err) && assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "production")) {
+		variant, err := config.GetVariant(cfg, "production")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t,
+				config.TemplatesConfig{
+					{Source: "version.json.tmpl", Destination: "version.json"},
+					{Source: "motd.tmpl", Destination: "/etc/motd"},
+				},
+				variant.Templates,
+			)
+		}
+	}
+}
+
+func TestTemplatesConfigRender(t *testing.T) {
+	files := map[string]string{
+		"version.json.tmpl": `{"version": "${VERSION}", "sha": "${GIT_SHA:-unknown}", "shell": "$HOME"}`,
+		"bad.tmpl":          `${UNDEFINED}`,
+	}
+
+	read := func(_ context.Context, path string) ([]byte, error) {
+		if data, ok := files[path]; ok {
+			return []byte(data), nil
+		}
+
+		return nil, errors.Errorf("no such file %s", path)
+	}
+
+	variables := map[string]string{"VERSION": "1.2.3"}
+
+	t.Run("ok", func(t *testing.T) {
+		tc := config.TemplatesConfig{
+			{Source: "version.json.tmpl", Destination: "version.json"},
+		}
+
+		rendered, err := tc.Render(context.Background(), read, variables)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t,
+				[]build.Instruction{
+					build.File{
+						Path:    "/srv/app/version.json",
+						Mode:    os.FileMode(0o644),
+						Content: []byte(`{"version": "1.2.3", "sha": "unknown", "shell": "$HOME"}`),
+					},
+				},
+				rendered.Expand("/srv/app").InstructionsForPhase(build.PhaseInstall),
+			)
+		}
+	})
+
+	t.Run("undefined variable", func(t *testing.T) {
+		tc := config.TemplatesConfig{{Source: "bad.tmpl", Destination: "bad"}}
+
+		_, err := tc.Render(context.Background(), read, variables)
+
+		if assert.Error(t, err) {
+			assert.Equal(t, `failed to render template bad.tmpl: variable "UNDEFINED" is not defined`, err.Error())
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		tc := config.TemplatesConfig{{Source: "missing.tmpl", Destination: "missing"}}
+
+		_, err := tc.Render(context.Background(), read, variables)
+
+		assert.Error(t, err)
+	})
+
+	t.Run("no reader", func(t *testing.T) {
+		tc := config.TemplatesConfig{{Source: "version.json.tmpl", Destination: "version.json"}}
+
+		_, err := tc.Render(context.Background(), nil, variables)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestTemplatesConfigInstructions(t *testing.T) {
+	tc := config.TemplatesConfig{{Source: "foo.tmpl", Destination: "/foo"}}
+
+	t.Run("PhasePrivileged", func(t *testing.T) {
+		assert.Empty(t, tc.InstructionsForPhase(build.PhasePrivileged))
+	})
+
+	t.Run("PhasePostInstall", func(t *testing.T) {
+		assert.Empty(t, tc.InstructionsForPhase(build.PhasePostInstall))
+	})
+
+	t.Run("PhaseInstall", func(t *testing.T) {
+		tc := config.TemplatesConfig{{Source: "version.json.tmpl", Destination: "/srv/app/config/version.json"}}
+
+		read := func(_ context.Context, path string) ([]byte, error) {
+			return []byte(`{"version": "1.0"}`), nil
+		}
+
+		rendered, err := tc.Render(context.Background(), read, nil)
+		require.NoError(t, err)
+
+		_, req := testtarget.Setup(t,
+			testtarget.NewTargets("foo"),
+			func(target *build.Target) {
+				for _, ins := range rendered.InstructionsForPhase(build.PhaseInstall) {
+					require.NoError(t, ins.Compile(target))
+				}
+			},
+		)
+
+		_, fops := req.ContainsNFileOps(1)
+
+		// The parent directory is created first
+		_, mkdirs := req.ContainsNMkdirActions(fops[0], 1)
+		req.Equal("/srv/app/config", mkdirs[0].Mkdir.Path)
+		req.True(mkdirs[0].Mkdir.MakeParents)
+
+		_, mkfiles := req.ContainsNMkfileActions(fops[0], 1)
+		req.Equal("/srv/app/config/version.json", mkfiles[0].Mkfile.Path)
+		req.Equal(`{"version": "1.0"}`, string(mkfiles[0].Mkfile.Data))
+	})
+}
+
+func TestArgumentsInRuntimeEnvironment(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    arguments:
+      GIT_SHA: unknown
+    runs:
+      environment:
+        APP_GIT_SHA: ${GIT_SHA}
+    variants:
+      production: {}`))
+
+	if !assert.NoError(t, err) || !assert.NoError(t, config.ExpandIncludesAndCopies(cfg, "production")) {
+		return
+	}
+
+	variant, err := config.GetVariant(cfg, "production")
+
+	if assert.NoError(t, err) {
+		err = variant.Interpolate(config.InterpolationVariables(
+			variant.Arguments,
+			map[string]string{"GIT_SHA": "abc123"},
+			nil,
+		))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "abc123", variant.Runs.Environment["APP_GIT_SHA"])
+		}
+	}
+}
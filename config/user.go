@@ -1,10 +1,20 @@
 package config
 
+import (
+	"fmt"
+
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
+)
+
 // UserConfig holds configuration fields related to a user account.
 type UserConfig struct {
-	As  string `json:"as" validate:"omitempty,username"` // user name
-	UID uint   `json:"uid"`                              // user ID
-	GID uint   `json:"gid"`                              // group ID
+	As     string   `json:"as" validate:"omitempty,username"`   // user name
+	UID    uint     `json:"uid"`                                // user ID
+	GID    uint     `json:"gid"`                                // group ID
+	Groups []string `json:"groups" validate:"dive,groupname"`   // supplementary groups
+	Shell  string   `json:"shell" validate:"omitempty,abspath"` // login shell
+	Home   string   `json:"home" validate:"omitempty,abspath"`  // home directory
+	Create Flag     `json:"create"`                             // create the user (default true)
 }
 
 // Merge takes another UserConfig and overwrites this struct's fields.
@@ -20,4 +30,64 @@ func (user *UserConfig) Merge(user2 UserConfig) {
 	if user2.GID != 0 {
 		user.GID = user2.GID
 	}
+
+	if user2.Groups != nil {
+		user.Groups = append(user.Groups, user2.Groups...)
+	}
+
+	if user2.Shell != "" {
+		user.Shell = user2.Shell
+	}
+
+	if user2.Home != "" {
+		user.Home = user2.Home
+	}
+
+	user.Create.Merge(user2.Create)
+}
+
+// ShouldCreate returns whether the user account should be created, which is
+// the case unless explicitly disabled. Otherwise, an existing account of the
+// base image is used.
+func (user UserConfig) ShouldCreate() bool {
+	return !user.Create.Set || user.Create.True
+}
+
+// setup returns build.Run instructions that create or verify the existence of
+// the user account, referenced by the given name, UID and GID, and add it to
+// its supplementary groups.
+func (user UserConfig) setup(name string, uid string, gid string) []build.Run {
+	account := build.UserAccount{
+		Name:   name,
+		UID:    uid,
+		GID:    gid,
+		Home:   user.Home,
+		Shell:  user.Shell,
+		Groups: user.Groups,
+	}
+
+	if user.ShouldCreate() {
+		return build.CreateUserAccount(account)
+	}
+
+	return build.ReuseUserAccount(account)
+}
+
+// passwdEntry returns an /etc/passwd entry for the user. The user has no
+// login shell unless one is configured.
+func (user UserConfig) passwdEntry() string {
+	home := build.UserAccount{Name: user.As, Home: user.Home}.HomeDirectory()
+	shell := "/sbin/nologin"
+
+	if user.Shell != "" {
+		shell = user.Shell
+	}
+
+	return fmt.Sprintf("%s:x:%d:%d::%s:%s", user.As, user.UID, user.GID, home, shell)
+}
+
+// groupEntry returns an /etc/group entry for the user's primary group, which
+// is named after the user.
+func (user UserConfig) groupEntry() string {
+	return fmt.Sprintf("%s:x:%d:", user.As, user.GID)
 }
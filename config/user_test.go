@@ -4,10 +4,105 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"gitlab.wikimedia.org/repos/releng/blubber/build"
 	"gitlab.wikimedia.org/repos/releng/blubber/config"
 )
 
+func TestUserConfigYAML(t *testing.T) {
+	cfg, err := config.ReadYAMLConfig([]byte(`---
+    version: v4
+    base: foo
+    lives:
+      groups: [ssl-cert]
+    runs:
+      as: www-data
+      uid: 33
+      gid: 33
+      create: false
+    variants:
+      development:
+        lives:
+          groups: [www-data]
+          shell: /bin/bash
+          home: /srv/home`))
+
+	require.NoError(t, err)
+	require.NoError(t, config.ExpandIncludesAndCopies(cfg, "development"))
+
+	variant, err := config.GetVariant(cfg, "development")
+	require.NoError(t, err)
+
+	assert.Equal(t,
+		config.UserConfig{
+			As:     "somebody",
+			UID:    65533,
+			GID:    65533,
+			Groups: []string{"ssl-cert", "www-data"},
+			Shell:  "/bin/bash",
+			Home:   "/srv/home",
+		},
+		variant.Lives.UserConfig,
+	)
+
+	assert.False(t, variant.Runs.ShouldCreate())
+	assert.True(t, variant.Lives.ShouldCreate())
+}
+
+func TestUserConfigInstructions(t *testing.T) {
+	t.Run("create", func(t *testing.T) {
+		cfg := config.RunsConfig{
+			UserConfig: config.UserConfig{
+				Groups: []string{"www-data"},
+				Shell:  "/bin/bash",
+				Home:   "/srv/home",
+			},
+		}
+
+		assert.Equal(t,
+			build.RunAll{build.CreateUserAccount(build.UserAccount{
+				Name:   "$RUNS_AS",
+				UID:    "$RUNS_UID",
+				GID:    "$RUNS_GID",
+				Home:   "/srv/home",
+				Shell:  "/bin/bash",
+				Groups: []string{"www-data"},
+			})},
+			cfg.InstructionsForPhase(build.PhasePrivileged)[3],
+		)
+	})
+
+	t.Run("reuse", func(t *testing.T) {
+		cfg := config.RunsConfig{
+			UserConfig: config.UserConfig{
+				Create: config.Flag{True: false, Set: true},
+			},
+		}
+
+		assert.Equal(t,
+			build.RunAll{build.ReuseUserAccount(build.UserAccount{
+				Name: "$RUNS_AS",
+				UID:  "$RUNS_UID",
+				GID:  "$RUNS_GID",
+			})},
+			cfg.InstructionsForPhase(build.PhasePrivileged)[3],
+		)
+	})
+
+	t.Run("home", func(t *testing.T) {
+		cfg := config.NewVariantConfig("foo")
+		cfg.Base = "foo"
+		cfg.Lives.As = "foo"
+		cfg.Lives.Home = "/srv/home"
+
+		assert.Contains(t,
+			cfg.InstructionsForPhase(build.PhasePrivilegeDropped),
+			build.Env{map[string]string{"HOME": "/srv/home"}},
+		)
+	})
+}
+
 func TestUserConfigValidation(t *testing.T) {
 	t.Run("as", func(t *testing.T) {
 		t.Run("ok", func(t *testing.T) {
@@ -60,4 +155,28 @@ func TestUserConfigValidation(t *testing.T) {
 			}
 		})
 	})
+
+	t.Run("groups", func(t *testing.T) {
+		err := config.Validate(config.UserConfig{
+			Groups: []string{"www-data", "ssl cert"},
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, `groups[1]: "ssl cert" is not a valid group name`, msg)
+		}
+	})
+
+	t.Run("home", func(t *testing.T) {
+		err := config.Validate(config.UserConfig{
+			Home: "home/foo",
+		})
+
+		if assert.True(t, config.IsValidationError(err)) {
+			msg := config.HumanizeValidationError(err)
+
+			assert.Equal(t, `home: "home/foo" is not a valid absolute non-root path`, msg)
+		}
+	})
 }
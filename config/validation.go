@@ -12,6 +12,7 @@ import (
 	"sync"
 	"text/template"
 
+	"github.com/containerd/containerd/platforms"
 	"github.com/distribution/distribution/reference"
 	"gopkg.in/go-playground/validator.v9"
 )
@@ -28,12 +29,37 @@ var (
 	debianPackageRegexp = regexp.MustCompile(fmt.Sprintf(
 		`^%s(?:=%s|/%s)?$`, debianPackageName, debianVersionSpec, debianReleaseName))
 	debianComponentRegexp = regexp.MustCompile(debianComponent)
+	aptPinRegexp          = regexp.MustCompile(`^(?:release|origin|version) \S.*$`)
+
+	// See https://wiki.alpinelinux.org/wiki/Alpine_Package_Keeper
+	alpinePackageName   = `[a-zA-Z0-9][a-zA-Z0-9+._\-]*`
+	alpineVersionSpec   = `(?:=|~|<|>|<=|>=)[0-9][a-zA-Z0-9._\-]*`
+	alpineTagName       = `[a-zA-Z0-9][a-zA-Z0-9_\-]*`
+	alpineTagRegexp     = regexp.MustCompile(fmt.Sprintf(`^%s$`, alpineTagName))
+	alpinePackageRegexp = regexp.MustCompile(fmt.Sprintf(
+		`^%s(?:@%s)?(?:%s)?$`, alpinePackageName, alpineTagName, alpineVersionSpec))
+	apkKeyNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9@._\-]*\.pub$`)
+
+	// See https://rpm-software-management.github.io/rpm/manual/spec.html
+	rpmPackageName   = `[a-zA-Z0-9_][a-zA-Z0-9+._\-]*`
+	rpmVersionSpec   = `-(?:[0-9]+:)?[a-zA-Z0-9+._~^\-]+`
+	rpmPackageRegexp = regexp.MustCompile(fmt.Sprintf(
+		`^%s(?:%s)?$`, rpmPackageName, rpmVersionSpec))
+	rpmRepoIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9\-_.:]+$`)
 
 	// See IEEE Std 1003.1-2008 (http://pubs.opengroup.org/onlinepubs/9699919799/)
 	environmentVariableRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]+$`)
 
-	// Pattern for valid variant names
-	variantNameRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9\-\.]+[a-zA-Z0-9]$`)
+	// Pattern for valid variant names, which may be prefixed by the
+	// namespaces of imports (e.g. "shared/python-base")
+	variantName       = `[a-zA-Z][a-zA-Z0-9\-\.]+[a-zA-Z0-9]`
+	variantNameRegexp = regexp.MustCompile(fmt.Sprintf(`^%s(?:/%s)*$`, variantName, variantName))
+	namespaceRegexp   = regexp.MustCompile(fmt.Sprintf(`^%s$`, variantName))
+
+	// Pattern for file owners given as a user name or ID, optionally followed
+	// by a group name or ID
+	ownerName   = `[a-zA-Z0-9_][a-zA-Z0-9_.\-]*`
+	ownerRegexp = regexp.MustCompile(fmt.Sprintf(`^%s(?::%s)?$`, ownerName, ownerName))
 
 	// Pattern for Python package version constraints. We allow a subset of
 	// the spec that omits support for extras, environment markers, and urls.
@@ -46,19 +72,35 @@ var (
 
 	humanizedErrors = map[string]string{
 		"abspath":           `{{.Field}}: "{{.Value}}" is not a valid absolute non-root path`,
+		"alpinepackage":     `{{.Field}}: "{{.Value}}" is not a valid Alpine package name`,
+		"alpinetag":         `{{.Field}}: "{{.Value}}" is not a valid Alpine repository tag`,
+		"apkkeyname":        `{{.Field}}: "{{.Value}}" is not a valid apk public key file name`,
+		"aptpin":            `{{.Field}}: "{{.Value}}" is not a valid APT pin (e.g. "release n=bookworm")`,
 		"artifactfrom":      `{{.Field}}: "{{.Value}}" is not a valid image reference or known variant`,
 		"currentversion":    `{{.Field}}: config version "{{.Value}}" is unsupported`,
+		"configpath":        `{{.Field}}: "{{.Value}}" is not a known configuration path`,
 		"debiancomponent":   `{{.Field}}: "{{.Value}}" is not a valid Debian component name`,
 		"debianpackage":     `{{.Field}}: "{{.Value}}" is not a valid Debian package name`,
 		"debianrelease":     `{{.Field}}: "{{.Value}}" is not a valid Debian release name`,
 		"envvars":           `{{.Field}}: contains invalid environment variable names`,
+		"filemode":          `{{.Field}}: "{{.Value}}" is not a valid octal file mode (e.g. "0755")`,
+		"groupname":         `{{.Field}}: "{{.Value}}" is not a valid group name`,
 		"httpurl":           `{{.Field}}: "{{.Value}}" is not a valid HTTP/HTTPS URL`,
 		"imageref":          `{{.Field}}: "{{.Value}}" is not a valid image reference`,
 		"nodeenv":           `{{.Field}}: "{{.Value}}" is not a valid Node environment name`,
+		"namespace":         `{{.Field}}: "{{.Value}}" is not a valid namespace`,
+		"oneof":             `{{.Field}}: "{{.Value}}" is not one of: {{.Param}}`,
+		"owner":             `{{.Field}}: "{{.Value}}" is not a valid owner (e.g. "root" or "root:root")`,
+		"platform":          `{{.Field}}: "{{.Value}}" is not a valid platform (e.g. "linux/arm64")`,
 		"pypkgver":          `{{.Field}}: "{{.Value}}" is not a valid Python package version specification`,
+		"removablepath":     `{{.Field}}: "{{.Value}}" is not a known list or map configuration path`,
 		"relativelocal":     `{{.Field}}: path must be relative when "from" is "local"`,
+		"removepath":        `{{.Field}}: "{{.Value}}" is not a valid absolute path with a literal top-level directory`,
 		"required":          `{{.Field}}: is required`,
 		"requiredwith":      `{{.Field}}: is required if "{{.Param}}" is also set`,
+		"rpmpackage":        `{{.Field}}: "{{.Value}}" is not a valid RPM package name`,
+		"rpmrepoid":         `{{.Field}}: "{{.Value}}" is not a valid RPM repository ID`,
+		"sha256":            `{{.Field}}: "{{.Value}}" is not a valid hex encoded SHA-256 checksum`,
 		"unique":            `{{.Field}}: cannot contain duplicates`,
 		"uniqueartifacts":   `{{.Field}}: cannot contain duplicates`,
 		"username":          `{{.Field}}: "{{.Value}}" is not a valid user name`,
@@ -72,22 +114,37 @@ var (
 		"currentversion": "eq=" + CurrentVersion,
 		"nodeenv":        "alphanum",
 		"username":       "hostname,ne=root",
+		"groupname":      "hostname",
 		"artifactfrom":   "variantref|imageref",
+		"sha256":         "hexadecimal,len=64",
 	}
 
 	validatorFuncs = map[string]validator.FuncCtx{
 		"abspath":         isAbsNonRootPath,
+		"alpinepackage":   isAlpinePackage,
+		"alpinetag":       isAlpineTag,
+		"apkkeyname":      isApkKeyName,
+		"aptpin":          isAptPin,
+		"configpath":      isConfigPath,
 		"debiancomponent": isDebianComponent,
 		"debianpackage":   isDebianPackage,
 		"debianrelease":   isDebianRelease,
 		"envvars":         isEnvironmentVariables,
+		"filemode":        isFileMode,
 		"httpurl":         isHTTPURL,
 		"imageref":        isImageRef,
 		"isfalse":         isFalse,
 		"istrue":          isTrue,
+		"namespace":       isNamespace,
+		"owner":           isOwner,
+		"platform":        isPlatform,
 		"pypkgver":        isPythonPackageVersion,
+		"removepath":      isRemovePath,
 		"relativelocal":   isRelativePathForLocalArtifact,
+		"removablepath":   isRemovablePath,
 		"requiredwith":    isSetIfOtherFieldIsSet,
+		"rpmpackage":      isRPMPackage,
+		"rpmrepoid":       isRPMRepoID,
 		"uniqueartifacts": uniqueByEquality[ArtifactsConfig],
 		"variantref":      isVariantReference,
 		"variants":        hasVariantNames,
@@ -115,12 +172,25 @@ func newValidator() *validator.Validate {
 	}
 
 	for name, f := range validatorFuncs {
-		validate.RegisterValidationCtx(name, f, true)
+		validate.RegisterValidationCtx(name, skipInterpolated(f), true)
 	}
 
 	return validate
 }
 
+// skipInterpolated wraps the given validator so that it passes string values
+// that contain variable references. Such values are validated once they have
+// been interpolated. See [VariantConfig.Interpolate].
+func skipInterpolated(f validator.FuncCtx) validator.FuncCtx {
+	return func(ctx context.Context, fl validator.FieldLevel) bool {
+		if fl.Field().Kind() == reflect.String && isInterpolated(fl.Field().String()) {
+			return true
+		}
+
+		return f(ctx, fl)
+	}
+}
+
 // Validate runs all validations defined for config fields against the given
 // Config value. If the returned error is not nil, it will contain a
 // user-friendly message describing all invalid field values.
@@ -132,6 +202,16 @@ func Validate(config interface{}) error {
 	return validate.StructCtx(ctx, config)
 }
 
+// ValidateVariant runs all validations defined for config fields against the
+// given variant of the given Config (e.g. once it has been interpolated).
+func ValidateVariant(config Config, vcfg VariantConfig) error {
+	validate := newValidator()
+
+	ctx := context.WithValue(context.Background(), rootCfgCtx, config)
+
+	return validate.StructCtx(ctx, vcfg)
+}
+
 // HumanizeValidationError transforms the given validator.ValidationErrors
 // into messages more likely to be understood by human beings.
 func HumanizeValidationError(err error) string {
@@ -194,6 +274,51 @@ func isAbsNonRootPath(_ context.Context, fl validator.FieldLevel) bool {
 	return path.IsAbs(value) && path.Base(path.Clean(value)) != "/"
 }
 
+// isRemovePath validates a path or glob of files to remove. To guard against
+// removing the entire filesystem, the top-level directory may not be a glob
+// (e.g. "/*" or "/u*").
+func isRemovePath(_ context.Context, fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+
+	if !path.IsAbs(value) {
+		return false
+	}
+
+	top, _, _ := strings.Cut(strings.TrimPrefix(path.Clean(value), "/"), "/")
+
+	return top != "" && !strings.ContainsAny(top, globChars)
+}
+
+func isAlpinePackage(_ context.Context, fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+
+	return alpinePackageRegexp.MatchString(value)
+}
+
+func isAlpineTag(_ context.Context, fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+
+	return alpineTagRegexp.MatchString(value)
+}
+
+func isApkKeyName(_ context.Context, fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+
+	return apkKeyNameRegexp.MatchString(value)
+}
+
+func isAptPin(_ context.Context, fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+
+	return aptPinRegexp.MatchString(value)
+}
+
+func isConfigPath(_ context.Context, fl validator.FieldLevel) bool {
+	_, ok := fieldByPath(reflect.ValueOf(&VariantConfig{}).Elem(), fl.Field().String())
+
+	return ok
+}
+
 func isDebianComponent(_ context.Context, fl validator.FieldLevel) bool {
 	value := fl.Field().String()
 
@@ -212,6 +337,24 @@ func isDebianRelease(_ context.Context, fl validator.FieldLevel) bool {
 	return debianReleaseRegexp.MatchString(value)
 }
 
+func isRPMPackage(_ context.Context, fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+
+	return rpmPackageRegexp.MatchString(value)
+}
+
+func isRPMRepoID(_ context.Context, fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+
+	return rpmRepoIDRegexp.MatchString(value)
+}
+
+func isFileMode(_ context.Context, fl validator.FieldLevel) bool {
+	_, err := parseFileMode(fl.Field().String())
+
+	return err == nil
+}
+
 func isHTTPURL(_ context.Context, fl validator.FieldLevel) bool {
 	url, err := url.Parse(fl.Field().String())
 
@@ -244,12 +387,32 @@ func isFalse(_ context.Context, fl validator.FieldLevel) bool {
 	return ok && val == false
 }
 
+func isNamespace(_ context.Context, fl validator.FieldLevel) bool {
+	return namespaceRegexp.MatchString(fl.Field().String())
+}
+
+func isOwner(_ context.Context, fl validator.FieldLevel) bool {
+	return ownerRegexp.MatchString(fl.Field().String())
+}
+
+func isPlatform(_ context.Context, fl validator.FieldLevel) bool {
+	_, err := platforms.Parse(fl.Field().String())
+
+	return err == nil
+}
+
 func isPythonPackageVersion(_ context.Context, fl validator.FieldLevel) bool {
 	value := fl.Field().String()
 
 	return pythonContraintRegexp.MatchString(value)
 }
 
+func isRemovablePath(_ context.Context, fl validator.FieldLevel) bool {
+	field, ok := fieldByPath(reflect.ValueOf(&VariantConfig{}).Elem(), fl.Field().String())
+
+	return ok && isRemovable(field)
+}
+
 func isRelativePathForLocalArtifact(_ context.Context, fl validator.FieldLevel) bool {
 	value := fl.Field().String()
 	from := fl.Parent().FieldByName("From").String()
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"os"
+	"slices"
+	"strings"
+
 	"gitlab.wikimedia.org/repos/releng/blubber/build"
 )
 
@@ -13,8 +17,14 @@ func NewVariantConfig(name string) *VariantConfig {
 
 // VariantConfig holds configuration fields for each defined build variant.
 type VariantConfig struct {
-	Includes     []string     `json:"includes" validate:"dive,variantref"`
-	Copies       CopiesConfig `json:"copies" validate:"omitempty,uniqueartifacts,dive"`
+	Includes     []string            `json:"includes" validate:"dive,variantref"`
+	Copies       CopiesConfig        `json:"copies" validate:"omitempty,uniqueartifacts,dive"`
+	Assemble     AssembleConfig      `json:"assemble"`
+	Downloads    DownloadsConfig     `json:"downloads" validate:"dive"`
+	Templates    TemplatesConfig     `json:"templates" validate:"dive"`
+	Matrix       MatrixConfig        `json:"matrix" interpolate:"false"`
+	Reset        []string            `json:"reset" validate:"dive,configpath"`
+	Remove       map[string][]string `json:"remove" validate:"dive,keys,removablepath,endkeys"`
 	CommonConfig `json:",inline"`
 
 	name string
@@ -23,15 +33,24 @@ type VariantConfig struct {
 // Dependencies returns variant dependencies.
 func (vc *VariantConfig) Dependencies() []string {
 	return append(
-		vc.Copies.Dependencies(),
+		append(vc.Copies.Dependencies(), vc.Assemble.Dependencies()...),
 		vc.CommonConfig.Dependencies()...,
 	)
 }
 
 // Merge takes another VariantConfig and overwrites this struct's fields.
+//
+// The configuration at the paths given by the other variant's Reset is
+// cleared beforehand, and the values given by its Remove are removed
+// afterward, so that a variant may drop configuration it inherits.
 func (vc *VariantConfig) Merge(vc2 VariantConfig) {
+	vc.resetPaths(vc2.Reset)
 	vc.Copies.Merge(vc2.Copies)
+	vc.Assemble.Merge(vc2.Assemble)
+	vc.Downloads.Merge(vc2.Downloads)
+	vc.Templates.Merge(vc2.Templates)
 	vc.CommonConfig.Merge(vc2.CommonConfig)
+	vc.removeValues(vc2.Remove)
 }
 
 // InstructionsForPhase injects build instructions related to dropping
@@ -41,7 +60,9 @@ func (vc *VariantConfig) Merge(vc2 VariantConfig) {
 //
 // # PhasePrivileged
 //
-// Ensure the process and file owner is root.
+// Ensure the process and file owner is root. For variants without a base
+// image that have users (see [VariantConfig.HasUsers]), declare the user
+// build arguments and create minimal /etc/passwd and /etc/group files.
 //
 // # PhasePrivilegeDropped
 //
@@ -53,7 +74,9 @@ func (vc *VariantConfig) Merge(vc2 VariantConfig) {
 //
 // # PhaseInstall
 //
-// Ensure the process and file owner is the "lives.as" user.
+// Ensure the process and file owner is the "lives.as" user. Assembles the
+// root filesystem from another variant if configured to do so, and writes
+// rendered templates.
 //
 // # PhasePostInstall
 //
@@ -61,35 +84,50 @@ func (vc *VariantConfig) Merge(vc2 VariantConfig) {
 // to run insecurely as the "lives.as" user. Finally, sets the application
 // entrypoint.
 func (vc *VariantConfig) InstructionsForPhase(phase build.Phase) []build.Instruction {
-	instructions := vc.CommonConfig.InstructionsForPhase(phase)
+	return vc.SectionsForPhase(phase).Instructions()
+}
+
+// SectionsForPhase returns the same instructions as
+// [VariantConfig.InstructionsForPhase] grouped by the configuration field from
+// which they originate.
+func (vc *VariantConfig) SectionsForPhase(phase build.Phase) Sections {
+	sections := vc.CommonConfig.SectionsForPhase(phase)
 
 	switch phase {
 	case build.PhasePostInstall:
 		if len(vc.EntryPoint) > 0 {
-			instructions = append(instructions, build.EntryPoint{vc.EntryPoint})
+			sections = sections.appendSection("entrypoint", build.EntryPoint{vc.EntryPoint})
 		}
 	}
 
+	sections = sections.appendSection("assemble", vc.Assemble.Expand(vc.Lives.In, vc.Lives.As, vc.Runs.As).InstructionsForPhase(phase)...)
+
 	// CopiesConfig may not implement InstructionsForPhase for all possible
 	// phases, which makes the expansion of it here less than efficient, but to
 	// assume which phases it does implement would result in gross coupling
-	instructions = append(instructions, vc.Copies.Expand(vc.Lives.In).InstructionsForPhase(phase)...)
+	sections = sections.appendSection("copies", vc.Copies.Expand(vc.Lives.In).InstructionsForPhase(phase)...)
+	sections = sections.appendSection("downloads", vc.Downloads.Expand(vc.Lives.In).InstructionsForPhase(phase)...)
+	sections = sections.appendSection("templates", vc.Templates.Expand(vc.Lives.In).InstructionsForPhase(phase)...)
 
-	if !vc.IsScratch() {
-		switchUser, uid, gid := vc.userForPhase(phase)
+	if vc.IsScratch() && vc.HasUsers() && phase == build.PhasePrivileged {
+		sections = sections.appendSection("users", vc.scratchUserInstructions()...)
+	}
+
+	if vc.HasUsers() {
+		switchUser, home, uid, gid := vc.userForPhase(phase)
 
 		if switchUser != "" {
-			instructions = append(
-				[]build.Instruction{
-					build.User{UID: uid},
-					build.Home(switchUser),
-				},
-				instructions...,
+			account := build.UserAccount{Name: switchUser, Home: home}
+
+			sections = sections.prependSection(
+				"user",
+				build.User{UID: uid},
+				build.Env{map[string]string{"HOME": account.HomeDirectory()}},
 			)
 		}
 
 		if uid != "" {
-			instructions = build.ApplyUser(uid, gid, instructions)
+			sections = sections.ApplyUser(uid, gid)
 		}
 	}
 
@@ -103,19 +141,69 @@ func (vc *VariantConfig) InstructionsForPhase(phase build.Phase) []build.Instruc
 			baseIns = build.Base{Image: vc.Base, Stage: vc.name}
 		}
 
-		instructions = append([]build.Instruction{baseIns}, instructions...)
+		sections = sections.prependSection("base", baseIns)
+	}
+
+	return sections
+}
+
+// HasUsers returns whether the variant's image has lives and runs users. This
+// is always the case for variants with a base image. Scratch variants only
+// have users if they define an entrypoint or assemble their root filesystem,
+// so that scratch variants used merely to export files are left untouched.
+func (vc *VariantConfig) HasUsers() bool {
+	return !vc.IsScratch() || len(vc.EntryPoint) > 0 || vc.Assemble.From != ""
+}
+
+// scratchUserInstructions returns instructions that declare the user build
+// arguments of a scratch variant and, unless they are assembled from another
+// variant, create minimal /etc/passwd and /etc/group files for root and the
+// lives and runs users. No shell is required in the image.
+func (vc *VariantConfig) scratchUserInstructions() []build.Instruction {
+	ins := []build.Instruction{
+		build.NewStringArg("LIVES_AS", vc.Lives.As),
+		build.NewUintArg("LIVES_UID", vc.Lives.UID),
+		build.NewUintArg("LIVES_GID", vc.Lives.GID),
+		build.NewStringArg("RUNS_AS", vc.Runs.As),
+		build.NewUintArg("RUNS_UID", vc.Runs.UID),
+		build.NewUintArg("RUNS_GID", vc.Runs.GID),
+	}
+
+	if vc.Assemble.From != "" {
+		return ins
+	}
+
+	users := []UserConfig{{As: "root"}, vc.Lives.UserConfig, vc.Runs.UserConfig}
+	passwd := []string{}
+	group := []string{}
+
+	for _, user := range users {
+		if user.As == "" {
+			continue
+		}
+
+		if entry := user.passwdEntry(); !slices.Contains(passwd, entry) {
+			passwd = append(passwd, entry)
+		}
+
+		if entry := user.groupEntry(); !slices.Contains(group, entry) {
+			group = append(group, entry)
+		}
 	}
 
-	return instructions
+	return append(ins,
+		build.File{Path: "/etc/passwd", Mode: os.FileMode(0o644), Content: []byte(strings.Join(passwd, "\n") + "\n")},
+		build.File{Path: "/etc/group", Mode: os.FileMode(0o644), Content: []byte(strings.Join(group, "\n") + "\n")},
+	)
 }
 
-func (vc *VariantConfig) userForPhase(phase build.Phase) (switchUser string, uid string, gid string) {
+func (vc *VariantConfig) userForPhase(phase build.Phase) (switchUser string, home string, uid string, gid string) {
 	switch phase {
 	case build.PhasePrivileged:
 		switchUser = "root"
 
 	case build.PhasePrivilegeDropped:
-		switchUser = vc.Lives.As
+		switchUser, home = vc.Lives.As, vc.Lives.Home
 		uid, gid = "$LIVES_UID", "$LIVES_GID"
 
 	case build.PhasePreInstall:
@@ -128,10 +216,10 @@ func (vc *VariantConfig) userForPhase(phase build.Phase) (switchUser string, uid
 		if vc.Runs.Insecurely.True {
 			uid, gid = "$LIVES_UID", "$LIVES_GID"
 		} else {
-			switchUser = "$RUNS_AS"
+			switchUser, home = "$RUNS_AS", vc.Runs.Home
 			uid, gid = "$RUNS_UID", "$RUNS_GID"
 		}
 	}
 
-	return switchUser, uid, gid
+	return switchUser, home, uid, gid
 }
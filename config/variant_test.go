@@ -1,13 +1,17 @@
 package config_test
 
 import (
+	"fmt"
+	"os"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"gitlab.wikimedia.org/repos/releng/blubber/build"
 	"gitlab.wikimedia.org/repos/releng/blubber/config"
+	"gitlab.wikimedia.org/repos/releng/blubber/util/testtarget"
 )
 
 func TestVariantConfigYAML(t *testing.T) {
@@ -52,6 +56,7 @@ func TestVariantDependencies(t *testing.T) {
 	vcfg.Copies = config.CopiesConfig{
 		{From: "dep0"},
 	}
+	vcfg.Assemble = config.AssembleConfig{From: "dep6"}
 	vcfg.Node = config.NodeConfig{
 		Requirements: config.RequirementsConfig{
 			{From: "dep1"},
@@ -82,7 +87,7 @@ func TestVariantDependencies(t *testing.T) {
 
 	assert.Equal(
 		t,
-		[]string{"dep0", "dep1", "dep2", "dep3", "dep4", "dep5"},
+		[]string{"dep0", "dep6", "dep1", "dep2", "dep3", "dep4", "dep5"},
 		vcfg.Dependencies(),
 	)
 }
@@ -171,6 +176,8 @@ func TestVariantConfigInstructions(t *testing.T) {
 
 			assert.Equal(t,
 				[]build.Instruction{
+					build.User{UID: "$RUNS_UID"},
+					build.Env{map[string]string{"HOME": "/home/$RUNS_AS"}},
 					build.EntryPoint{[]string{"/foo", "bar"}},
 				},
 				cfg.InstructionsForPhase(build.PhasePostInstall),
@@ -235,6 +242,155 @@ func TestVariantConfigInstructions(t *testing.T) {
 	})
 }
 
+func TestVariantConfigScratchUsers(t *testing.T) {
+	cfg := config.NewVariantConfig("foovariant")
+	cfg.Lives = config.LivesConfig{In: "/srv/app", UserConfig: config.UserConfig{As: "somebody", UID: 65533, GID: 65533}}
+	cfg.Runs = config.RunsConfig{UserConfig: config.UserConfig{As: "runuser", UID: 900, GID: 900}}
+	cfg.Copies = config.CopiesConfig{{From: "build", Source: "/usr/bin/foo", Destination: "/usr/bin/foo"}}
+	cfg.EntryPoint = []string{"/usr/bin/foo"}
+
+	t.Run("PhasePrivileged", func(t *testing.T) {
+		assert.Equal(t,
+			[]build.Instruction{
+				build.ScratchBase{Stage: "foovariant"},
+				build.User{},
+				build.Env{map[string]string{"HOME": "/root"}},
+				build.StringArg{"LIVES_AS", "somebody"},
+				build.UintArg{"LIVES_UID", 65533},
+				build.UintArg{"LIVES_GID", 65533},
+				build.StringArg{"RUNS_AS", "runuser"},
+				build.UintArg{"RUNS_UID", 900},
+				build.UintArg{"RUNS_GID", 900},
+				build.File{
+					Path: "/etc/passwd",
+					Mode: os.FileMode(0o644),
+					Content: []byte(
+						"root:x:0:0::/root:/sbin/nologin\n" +
+							"somebody:x:65533:65533::/home/somebody:/sbin/nologin\n" +
+							"runuser:x:900:900::/home/runuser:/sbin/nologin\n",
+					),
+				},
+				build.File{
+					Path: "/etc/group",
+					Mode: os.FileMode(0o644),
+					Content: []byte(
+						"root:x:0:\n" +
+							"somebody:x:65533:\n" +
+							"runuser:x:900:\n",
+					),
+				},
+			},
+			cfg.InstructionsForPhase(build.PhasePrivileged),
+		)
+
+		t.Run("compiles onto an empty filesystem", func(t *testing.T) {
+			var targets build.TargetGroup
+			targets.NewTarget("foovariant", "", nil, build.NewOptions())
+
+			_, req := testtarget.Setup(t,
+				targets,
+				func(target *build.Target) {
+					for _, ins := range cfg.InstructionsForPhase(build.PhasePrivileged) {
+						require.NoError(t, ins.Compile(target))
+					}
+				},
+			)
+
+			req.ContainsNSourceOps(0)
+			_, fops := req.ContainsNFileOps(2)
+
+			for i, file := range []string{"/etc/passwd", "/etc/group"} {
+				_, mkdirs := req.ContainsNMkdirActions(fops[i], 1)
+				req.Equal("/etc", mkdirs[0].Mkdir.Path)
+				req.True(mkdirs[0].Mkdir.MakeParents)
+
+				_, mkfiles := req.ContainsNMkfileActions(fops[i], 1)
+				req.Equal(file, mkfiles[0].Mkfile.Path)
+			}
+		})
+	})
+
+	t.Run("PhaseInstall", func(t *testing.T) {
+		assert.Equal(t,
+			[]build.Instruction{
+				build.CopyAs{"$LIVES_UID", "$LIVES_GID", build.CopyFrom{"build", build.Copy{[]string{"/usr/bin/foo"}, "/usr/bin/foo", nil}}},
+			},
+			cfg.InstructionsForPhase(build.PhaseInstall),
+		)
+	})
+
+	t.Run("PhasePostInstall", func(t *testing.T) {
+		assert.Equal(t,
+			[]build.Instruction{
+				build.User{UID: "$RUNS_UID"},
+				build.Env{map[string]string{"HOME": "/home/$RUNS_AS"}},
+				build.EntryPoint{[]string{"/usr/bin/foo"}},
+			},
+			cfg.InstructionsForPhase(build.PhasePostInstall),
+		)
+	})
+
+	t.Run("when assembling", func(t *testing.T) {
+		cfg := *cfg
+		cfg.Assemble = config.AssembleConfig{From: "build"}
+
+		// passwd and group entries are copied from the assembled variant
+		for _, ins := range cfg.InstructionsForPhase(build.PhasePrivileged) {
+			assert.NotEqual(t, "FILE", strings.Fields(fmt.Sprint(ins))[0])
+		}
+	})
+
+	t.Run("without entrypoint", func(t *testing.T) {
+		cfg := *cfg
+		cfg.EntryPoint = nil
+
+		assert.Equal(t,
+			[]build.Instruction{build.ScratchBase{Stage: "foovariant"}},
+			cfg.InstructionsForPhase(build.PhasePrivileged),
+		)
+
+		assert.Equal(t,
+			[]build.Instruction{
+				build.CopyFrom{"build", build.Copy{[]string{"/usr/bin/foo"}, "/usr/bin/foo", nil}},
+			},
+			cfg.InstructionsForPhase(build.PhaseInstall),
+		)
+	})
+}
+
+func TestVariantConfigSections(t *testing.T) {
+	cfg := config.NewVariantConfig("foovariant")
+	cfg.Base = "foobase"
+	cfg.Apt = config.AptConfig{Packages: config.AptPackages{"": {"libfoo"}}}
+	cfg.Copies = config.CopiesConfig{{From: "local"}}
+	cfg.EntryPoint = []string{"/foo"}
+
+	names := func(sections config.Sections) []string {
+		ns := make([]string, len(sections))
+
+		for i, section := range sections {
+			ns[i] = section.Name
+		}
+
+		return ns
+	}
+
+	assert.Equal(t,
+		[]string{"base", "user", "apt", "lives", "runs"},
+		names(cfg.SectionsForPhase(build.PhasePrivileged)),
+	)
+
+	assert.Equal(t,
+		[]string{"copies"},
+		names(cfg.SectionsForPhase(build.PhaseInstall)),
+	)
+
+	assert.Equal(t,
+		cfg.InstructionsForPhase(build.PhasePrivileged),
+		cfg.SectionsForPhase(build.PhasePrivileged).Instructions(),
+	)
+}
+
 func TestVariantConfigValidation(t *testing.T) {
 	t.Run("includes", func(t *testing.T) {
 		t.Run("ok", func(t *testing.T) {